@@ -1,8 +1,6 @@
 package controllers
 
 import (
-	"net/http"
-
 	"github.com/gin-gonic/gin"
 	"github.com/senomas/go-api/models"
 )
@@ -21,51 +19,26 @@ type UpdateBookInput struct {
 
 // GET /books
 // POST /books
-// Find books
-func FindBooks(c *gin.Context) {
-	var books []models.Book
-	models.DB.Finds(c, &models.Book{}, &books)
-}
-
+// POST /books/aggregate
+// GET /books/search
+// GET /books/export
+// GET /books/stream
 // GET /books/:id
-// Find a book
-func FindBook(c *gin.Context) {
-	var book models.Book
-	models.DB.Find(c, &book)
-}
-
 // PUT /books
-// Create new book
-func CreateBook(c *gin.Context) {
-	var input CreateBookInput
-	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	book := models.Book{Title: input.Title, Author: input.Author, Summary: input.Summary}
-	models.DB.Create(c, &book)
-}
-
+// POST /books/_bulk
+// POST /books/import
 // PATCH /books/:id
-// Update a book
-func UpdateBook(c *gin.Context) {
-	var input UpdateBookInput
-	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	var book models.Book
-	models.DB.Update(c, &book, func() {
-		book.Title = input.Title
-		book.Author = input.Author
-	})
-}
-
 // DELETE /books/:id
-// Delete a book
-func DeleteBook(c *gin.Context) {
-	var book models.Book
-	models.DB.Delete(c, &book)
+// POST /books/:id/restore
+// POST /books/purge (admin)
+// GET /books/:id/history
+// GET /books/:id/history/:rev
+// POST /books/:id/revert/:rev
+// Book routes, the writes are audited and published through the outbox. admin
+// guards the admin routes, nil leaves them out.
+func SetupBookRoutes(r *gin.RouterGroup, admin gin.HandlerFunc) *Resource[models.Book, CreateBookInput, UpdateBookInput] {
+	res := RegisterResource[models.Book, CreateBookInput, UpdateBookInput](r, "/books", &ResourceConfig[models.Book, CreateBookInput, UpdateBookInput]{Admin: admin})
+	res.Repository.Audit = true
+	res.Repository.Outbox = true
+	return res
 }
@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"reflect"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senomas/go-api/models"
+)
+
+// ResourceConfig customizes how create (C) and update (U) inputs are applied
+// to model T. Nil functions copy the fields with matching names, update only
+// copies non-zero fields. Admin guards the admin routes, which are not
+// mounted without it: it aborts the requests of the other actors.
+type ResourceConfig[T any, C any, U any] struct {
+	Create func(input C) T
+	Update func(input U, data *T)
+	Admin  gin.HandlerFunc
+}
+
+// Resource holds the handlers of model T. Idempotency makes the create and
+// bulk routes replay their response to a retried Idempotency-Key.
+type Resource[T any, C any, U any] struct {
+	Repository  *models.Repository[T]
+	Config      ResourceConfig[T, C, U]
+	Idempotency *models.Idempotency
+}
+
+// RegisterResource mounts the CRUD routes of model T under path and registers
+// T for AutoMigrate:
+//
+//	GET    path                   find (query DSL in ?query=)
+//	POST   path                   find (query DSL in body)
+//	POST   path/aggregate         group by and aggregates (query DSL in body)
+//	GET    path/search            full text search (text in ?q=)
+//	GET    path/export            stream as JSON, NDJSON or CSV (query DSL in ?query=)
+//	GET    path/stream            live query as SSE or WebSocket (query DSL in ?query=)
+//	GET    path/:id               find one
+//	PUT    path                   create from C (Idempotency-Key)
+//	POST   path/_bulk             create, upsert from C, update from U, delete (Idempotency-Key)
+//	POST   path/import            create from C per CSV or NDJSON row
+//	PATCH  path/:id               update from U
+//	DELETE path/:id               delete, soft delete for models with gorm.DeletedAt
+//	POST   path/:id/restore       undelete a soft deleted record
+//	POST   path/purge             remove the soft deleted records (Admin)
+//	GET    path/:id/history       revisions of an audited record, the latest first
+//	GET    path/:id/history/:rev  one revision
+//	POST   path/:id/revert/:rev   update back to a revision
+func RegisterResource[T any, C any, U any](r *gin.RouterGroup, path string, config *ResourceConfig[T, C, U]) *Resource[T, C, U] {
+	var model T
+	models.RegisterModel(&model)
+
+	res := NewResource(config)
+
+	r.GET(path, res.Finds)
+	r.POST(path, res.Finds)
+	r.POST(path+"/aggregate", res.Aggregate)
+	r.GET(path+"/search", res.Search)
+	r.GET(path+"/export", res.Export)
+	r.GET(path+"/stream", res.Stream)
+	r.GET(path+"/:id", res.Find)
+	r.PUT(path, res.Idempotency.Handler(), res.Create)
+	r.POST(path+"/_bulk", res.Idempotency.Handler(), res.Bulk)
+	r.POST(path+"/import", res.Import)
+	r.PATCH(path+"/:id", res.Update)
+	r.DELETE(path+"/:id", res.Delete)
+	r.POST(path+"/:id/restore", res.Restore)
+	if res.Config.Admin != nil {
+		r.POST(path+"/purge", res.Config.Admin, res.Purge)
+	}
+	r.GET(path+"/:id/history", res.History)
+	r.GET(path+"/:id/history/:rev", res.FindRevision)
+	r.POST(path+"/:id/revert/:rev", res.Revert)
+	return res
+}
+
+// NewResource returns the handlers of model T without mounting them, for
+// routes that expose only some of them.
+func NewResource[T any, C any, U any](config *ResourceConfig[T, C, U]) *Resource[T, C, U] {
+	res := &Resource[T, C, U]{Repository: models.NewRepository[T](nil), Idempotency: models.NewIdempotency(nil)}
+	if config != nil {
+		res.Config = *config
+	}
+	if res.Config.Create == nil {
+		res.Config.Create = func(input C) T {
+			var data T
+			copyFields(&data, &input, false)
+			return data
+		}
+	}
+	if res.Config.Update == nil {
+		res.Config.Update = func(input U, data *T) {
+			copyFields(data, &input, true)
+		}
+	}
+	return res
+}
+
+func (res *Resource[T, C, U]) Finds(c *gin.Context) {
+	models.Finds(c, res.Repository)
+}
+
+func (res *Resource[T, C, U]) Aggregate(c *gin.Context) {
+	models.Aggregate(c, res.Repository)
+}
+
+func (res *Resource[T, C, U]) Search(c *gin.Context) {
+	models.Search(c, res.Repository)
+}
+
+func (res *Resource[T, C, U]) Export(c *gin.Context) {
+	models.Export(c, res.Repository)
+}
+
+func (res *Resource[T, C, U]) Stream(c *gin.Context) {
+	models.Stream(c, res.Repository)
+}
+
+func (res *Resource[T, C, U]) Find(c *gin.Context) {
+	models.Find(c, res.Repository)
+}
+
+func (res *Resource[T, C, U]) Create(c *gin.Context) {
+	var input C
+	if err := models.BindJSON(c, &input); err != nil {
+		res.Repository.ErrorJSON(c, err)
+		return
+	}
+
+	models.Create(c, res.Repository, res.Config.Create(input))
+}
+
+// Update accepts U as application/json, or a merge patch / JSON patch of T.
+func (res *Resource[T, C, U]) Update(c *gin.Context) {
+	models.Update(c, res.Repository, func(data *T) error {
+		var input U
+		if err := models.BindJSON(c, &input); err != nil {
+			return err
+		}
+		res.Config.Update(input, data)
+		return nil
+	})
+}
+
+// Bulk binds the data of each operation as the Create and Update handlers
+// do, see models.Bulk.
+func (res *Resource[T, C, U]) Bulk(c *gin.Context) {
+	models.Bulk(c, res.Repository, func(data []byte) (T, error) {
+		var input C
+		if err := models.BindJSONBody(data, &input); err != nil {
+			var zero T
+			return zero, err
+		}
+		return res.Config.Create(input), nil
+	}, func(data []byte, record *T) error {
+		var input U
+		if err := models.BindJSONBody(data, &input); err != nil {
+			return err
+		}
+		res.Config.Update(input, record)
+		return nil
+	})
+}
+
+func (res *Resource[T, C, U]) Import(c *gin.Context) {
+	models.Import(c, res.Repository, res.Config.Create)
+}
+
+func (res *Resource[T, C, U]) Delete(c *gin.Context) {
+	models.Delete(c, res.Repository)
+}
+
+func (res *Resource[T, C, U]) Restore(c *gin.Context) {
+	models.Restore(c, res.Repository)
+}
+
+func (res *Resource[T, C, U]) Purge(c *gin.Context) {
+	models.Purge(c, res.Repository)
+}
+
+func (res *Resource[T, C, U]) History(c *gin.Context) {
+	models.History(c, res.Repository)
+}
+
+func (res *Resource[T, C, U]) FindRevision(c *gin.Context) {
+	models.FindRevision(c, res.Repository)
+}
+
+func (res *Resource[T, C, U]) Revert(c *gin.Context) {
+	models.Revert(c, res.Repository)
+}
+
+func copyFields(dst any, src any, skipZero bool) {
+	dv := reflect.ValueOf(dst).Elem()
+	sv := reflect.ValueOf(src).Elem()
+	for i := 0; i < sv.NumField(); i++ {
+		sf := sv.Type().Field(i)
+		if !sf.IsExported() || (skipZero && sv.Field(i).IsZero()) {
+			continue
+		}
+		if df := dv.FieldByName(sf.Name); df.IsValid() && df.CanSet() && sf.Type.AssignableTo(df.Type()) {
+			df.Set(sv.Field(i))
+		}
+	}
+}
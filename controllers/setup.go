@@ -1,14 +1,36 @@
 package controllers
 
 import (
+	"context"
+	"sync"
+
 	"github.com/gin-gonic/gin"
+	"github.com/senomas/go-api/models"
 )
 
-func SetupRoutes(r *gin.Engine) {
-	r.GET("/books", FindBooks)
-	r.POST("/books", FindBooks)
-	r.GET("/books/:id", FindBook)
-	r.PUT("/books", CreateBook)
-	r.PATCH("/books/:id", UpdateBook)
-	r.DELETE("/books/:id", DeleteBook)
+// SetupRoutes mounts the routes of the API, admin guards the admin ones, see
+// ResourceConfig. The events of the routes reach the live queries and the
+// webhooks only while RunWorkers runs.
+func SetupRoutes(r *gin.Engine, admin gin.HandlerFunc) {
+	r.Use(models.AuditContext(nil))
+	SetupBookRoutes(&r.RouterGroup, admin)
+	SetupWebhookRoutes(&r.RouterGroup)
+}
+
+// RunWorkers runs the background work of the routes until ctx is done: the
+// outbox relay publishing the events to the live queries and the webhooks,
+// pruning the published ones, and the webhook deliveries. Run it once per
+// database, after models.Setup.
+func RunWorkers(ctx context.Context) error {
+	webhooks := models.NewWebhooks(nil)
+	relay := models.NewOutboxRelay(nil, models.MultiSink{models.DB.Feed, webhooks})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		webhooks.Run(ctx)
+	}()
+	err := relay.Run(ctx)
+	wg.Wait()
+	return err
 }
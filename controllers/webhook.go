@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/senomas/go-api/models"
+)
+
+type CreateWebhookInput struct {
+	URL       string          `json:"url" binding:"required,url"`
+	Type      string          `json:"type" binding:"required"`
+	Events    []string        `json:"events"`
+	Condition models.JSONText `json:"condition"`
+	Secret    string          `json:"secret" binding:"required,min=16"`
+}
+
+type UpdateWebhookInput struct {
+	URL       string          `json:"url" binding:"omitempty,url"`
+	Type      string          `json:"type"`
+	Events    []string        `json:"events"`
+	Condition models.JSONText `json:"condition"`
+	Secret    string          `json:"secret" binding:"omitempty,min=16"`
+}
+
+type WebhookResource struct {
+	*Resource[models.WebhookSubscription, CreateWebhookInput, UpdateWebhookInput]
+	Webhooks *models.Webhooks
+}
+
+// GET /webhooks
+// POST /webhooks
+// GET /webhooks/:id
+// PUT /webhooks
+// PATCH /webhooks/:id
+// DELETE /webhooks/:id
+// GET /webhooks/:id/deliveries
+// GET /webhooks/:id/dead-letters
+// Webhook subscriptions and their delivery log, a models.Webhooks added to
+// the sink of the outbox relay delivers them
+func SetupWebhookRoutes(r *gin.RouterGroup) *WebhookResource {
+	res := &WebhookResource{
+		Resource: NewResource[models.WebhookSubscription, CreateWebhookInput, UpdateWebhookInput](nil),
+		Webhooks: models.NewWebhooks(nil),
+	}
+
+	r.GET("/webhooks", res.Finds)
+	r.POST("/webhooks", res.Finds)
+	r.GET("/webhooks/:id", res.Find)
+	r.PUT("/webhooks", res.Idempotency.Handler(), res.Create)
+	r.PATCH("/webhooks/:id", res.Update)
+	r.DELETE("/webhooks/:id", res.Delete)
+	r.GET("/webhooks/:id/deliveries", res.Deliveries)
+	r.GET("/webhooks/:id/dead-letters", res.DeadLetters)
+	return res
+}
+
+func (res *WebhookResource) Deliveries(c *gin.Context) {
+	models.WebhookDeliveries(c, res.Webhooks)
+}
+
+func (res *WebhookResource) DeadLetters(c *gin.Context) {
+	models.WebhookDeadLetters(c, res.Webhooks)
+}
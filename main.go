@@ -9,7 +9,11 @@ func main() {
 	r := gin.Default()
 	r.SetTrustedProxies([]string{"0.0.0.0"})
 
-	controllers.SetupRoutes(r)
+	// no admin routes without an authenticating proxy to tell the admins
+	controllers.SetupRoutes(r, nil)
+	// the events reach the live queries and the webhooks through
+	// controllers.RunWorkers, run beside the server once models.Setup
+	// installed the database
 
 	r.Run()
 }
@@ -0,0 +1,204 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Aggregate functions of QueryAggregate.Func.
+const (
+	AggregateCount = "count"
+	AggregateMin   = "min"
+	AggregateMax   = "max"
+	AggregateSum   = "sum"
+	AggregateAvg   = "avg"
+)
+
+// QueryAggregate is one computed column of an aggregate query. Field is empty
+// for count(*), Alias names the column in the result rows and defaults to
+// the function, followed by the field when there is one.
+type QueryAggregate struct {
+	Func  string `json:"fn"`
+	Field string `json:"f,omitempty"`
+	Alias string `json:"as,omitempty"`
+}
+
+var aliasRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func Count(alias string) QueryAggregate {
+	return QueryAggregate{Func: AggregateCount, Alias: alias}
+}
+
+func Min(field string, alias string) QueryAggregate {
+	return QueryAggregate{Func: AggregateMin, Field: field, Alias: alias}
+}
+
+func Max(field string, alias string) QueryAggregate {
+	return QueryAggregate{Func: AggregateMax, Field: field, Alias: alias}
+}
+
+func Sum(field string, alias string) QueryAggregate {
+	return QueryAggregate{Func: AggregateSum, Field: field, Alias: alias}
+}
+
+func Avg(field string, alias string) QueryAggregate {
+	return QueryAggregate{Func: AggregateAvg, Field: field, Alias: alias}
+}
+
+// Aggregate groups the rows of the query by the groupBy fields and computes
+// the aggregates per group.
+func (q *Query) Aggregate(groupBy []string, aggregates ...QueryAggregate) *Query {
+	q.GroupBy = groupBy
+	q.Aggregates = aggregates
+	return q
+}
+
+func (a QueryAggregate) alias() string {
+	if a.Alias != "" {
+		return a.Alias
+	}
+	if a.Field == "" {
+		return strings.ToLower(a.Func)
+	}
+	return strings.ToLower(a.Func) + "_" + a.Field
+}
+
+// aggregateSchema returns the fields of the result rows of q, the group by
+// fields and the aliases of the aggregates, with the column of an aggregate
+// being its SQL expression. Having and OrderBy are checked against it.
+func (s *Schema) aggregateSchema(qe *ValidationError, q *Query) *Schema {
+	result := &Schema{Fields: map[string]*SchemaField{}}
+	for i, name := range q.GroupBy {
+		path := fmt.Sprintf("groupBy[%d]", i)
+		if f, ok := s.Fields[name]; !ok {
+			qe.add(path, name, "UNKNOWN FIELD %s", name)
+		} else if !f.Filterable {
+			qe.add(path, name, "FIELD NOT FILTERABLE %s", name)
+		} else {
+			result.Fields[name] = &SchemaField{Name: name, Column: f.Column, Type: f.Type, Filterable: true, Sortable: true}
+		}
+	}
+	for i, a := range q.Aggregates {
+		path := fmt.Sprintf("aggregates[%d]", i)
+		fn := strings.ToLower(a.Func)
+		fieldType := FieldNumber
+		column := "*"
+		if a.Field != "" {
+			f, ok := s.Fields[a.Field]
+			if !ok {
+				qe.add(path+".f", a.Field, "UNKNOWN FIELD %s", a.Field)
+				continue
+			} else if !f.Filterable {
+				qe.add(path+".f", a.Field, "FIELD NOT FILTERABLE %s", a.Field)
+				continue
+			}
+			column = f.Column
+			if fn == AggregateMin || fn == AggregateMax {
+				fieldType = f.Type
+			} else if fn != AggregateCount && f.Type != FieldNumber {
+				qe.add(path+".f", a.Field, "INVALID %s FIELD %s FOR %s", f.Type, a.Field, fn)
+				continue
+			}
+		}
+		switch fn {
+		case AggregateCount:
+		case AggregateMin, AggregateMax, AggregateSum, AggregateAvg:
+			if a.Field == "" {
+				qe.add(path+".f", "", "MISSING FIELD FOR %s", fn)
+				continue
+			}
+		default:
+			qe.add(path+".fn", a.Field, "UNKNOWN AGGREGATE %s", a.Func)
+			continue
+		}
+		alias := a.alias()
+		if !aliasRegexp.MatchString(alias) {
+			qe.add(path+".as", a.Field, "INVALID ALIAS %s", alias)
+		} else if _, ok := result.Fields[alias]; ok {
+			qe.add(path+".as", a.Field, "DUPLICATE ALIAS %s", alias)
+		} else {
+			expr := strings.ToUpper(fn) + "(" + column + ")"
+			result.Fields[alias] = &SchemaField{Name: alias, Column: expr, Type: fieldType, Filterable: true, Sortable: true}
+		}
+	}
+	return result
+}
+
+// ValidateAggregate checks an aggregate query: the condition against the
+// fields of the schema, Having and OrderBy against the group by fields and
+// aliases.
+func (s *Schema) ValidateAggregate(q *Query) error {
+	qe := &ValidationError{Message: "INVALID QUERY"}
+	if len(q.GroupBy) == 0 && len(q.Aggregates) == 0 {
+		qe.add("aggregates", "", "MISSING AGGREGATE")
+	}
+	if q.Select != nil {
+		qe.add("select", "", "SELECT NOT SUPPORTED WITH AGGREGATE")
+	}
+	result := s.aggregateSchema(qe, q)
+	result.checkOrderBy(qe, q.OrderBy)
+	s.checkCondition(qe, "condition", &q.Condition)
+	if q.Having != nil {
+		result.checkCondition(qe, "having", q.Having)
+	}
+	if len(qe.Errors) > 0 {
+		return qe
+	}
+	return nil
+}
+
+// Aggregate returns one row per group of query, keyed by the group by fields
+// and the aliases of the aggregates. Without GroupBy the aggregates are
+// computed over all matching rows.
+func (r *Repository[T]) Aggregate(ctx context.Context, query *Query, limit int) ([]map[string]any, error) {
+	if err := r.Schema.ValidateAggregate(query); err != nil {
+		return nil, err
+	}
+	result := r.Schema.aggregateSchema(&ValidationError{}, query)
+
+	var model T
+	db := r.db()
+	tx, err := r.scopeDeleted(r.tx(ctx).Model(&model), query)
+	if err != nil {
+		return nil, err
+	}
+	where, params := query.Condition.ApplyDialect(db.SQLDialect(), "", []any{})
+	tx = tx.Where(where, params...)
+
+	columns := []string{}
+	groups := []string{}
+	for _, name := range query.GroupBy {
+		column := result.Column(name)
+		columns = append(columns, column+" AS "+tx.Statement.Quote(name))
+		groups = append(groups, column)
+	}
+	for _, a := range query.Aggregates {
+		alias := a.alias()
+		columns = append(columns, result.Column(alias)+" AS "+tx.Statement.Quote(alias))
+	}
+	tx = tx.Select(strings.Join(columns, ","))
+	if len(groups) > 0 {
+		tx = tx.Group(strings.Join(groups, ","))
+	}
+	if query.Having != nil {
+		if having, params := query.Having.ApplyDialect(db.SQLDialect(), "", []any{}); having != "" {
+			tx = tx.Having(having, params...)
+		}
+	}
+	for _, key := range query.OrderBy.Keys {
+		for _, column := range db.orderColumns(tx, key.Field, key.Desc, key.Nulls) {
+			tx = tx.Order(column)
+		}
+	}
+	if limit > 0 {
+		tx = tx.Limit(limit)
+	}
+
+	rows := []map[string]any{}
+	if err := tx.Find(&rows).Error; err != nil {
+		return nil, db.TranslateError(err)
+	}
+	return rows, nil
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	test_lib "github.com/senomas/go-api/test/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepository_Aggregate(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	for _, book := range []Book{
+		{Title: "Tintin in Tibet", Author: "Herge"},
+		{Title: "Tintin in America", Author: "Herge"},
+		{Title: "Harry Potter and the Chamber of Secrets", Author: "J. K. Rowling"},
+		{Title: "Book of Nobody", Author: "Nobody"},
+	} {
+		_, err := repo.Create(ctx, book)
+		assert.NoError(t, err)
+	}
+
+	query := NewQuery(nil, NewCondition().NotEqual("author", "Nobody"), nil).
+		Aggregate(Fields("author"), Count("books"), Max("id", "last")).
+		SortBy(Desc("books"))
+	rows, err := repo.Aggregate(ctx, query, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, `[
+	{
+		"author": "Herge",
+		"books": 2,
+		"last": 2
+	},
+	{
+		"author": "J. K. Rowling",
+		"books": 1,
+		"last": 3
+	}
+]`, test_lib.Marshal(t, rows))
+
+	query.Having = NewCondition().Greater("books", 1.0)
+	rows, err = repo.Aggregate(ctx, query, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(rows))
+
+	rows, err = repo.Aggregate(ctx, NewQuery(nil, nil, nil).Aggregate(nil, Count(""), Avg("id", "")), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, `[
+	{
+		"avg_id": 2.5,
+		"count": 4
+	}
+]`, test_lib.Marshal(t, rows))
+}
+
+func TestSchema_Fail_Aggregate(t *testing.T) {
+	s := SchemaOf(&Book{})
+	query := s.NewQuery()
+	err := json.Unmarshal([]byte(`{
+		"groupBy": ["author", "password"],
+		"aggregates": [{"fn": "sum", "f": "title"}, {"fn": "median", "f": "id"}, {"fn": "count", "as": "author"}],
+		"having": {"o": "AND", "e": [{"o": ">", "f": "id", "v": 1}]},
+		"orderBy": [{"f": "books"}]
+	}`), query)
+	assert.NoError(t, err)
+	err = s.ValidateAggregate(query)
+	assert.EqualError(t, err, "INVALID QUERY groupBy[1]: UNKNOWN FIELD password, aggregates[0].f: INVALID string FIELD title FOR sum, "+
+		"aggregates[1].fn: UNKNOWN AGGREGATE median, aggregates[2].as: DUPLICATE ALIAS author, orderBy[0].f: UNKNOWN FIELD books, "+
+		"having.e[0].f: UNKNOWN FIELD id")
+
+	err = s.ValidateQuery(NewQuery(nil, nil, nil).Aggregate(Fields("author")))
+	assert.EqualError(t, err, "INVALID QUERY groupBy: AGGREGATE NOT SUPPORTED")
+}
@@ -0,0 +1,310 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Actions of a Revision.
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+)
+
+// Headers read by AuditContext, an authenticating proxy in front of the API
+// sets the actor.
+const (
+	RequestIDHeader = "X-Request-ID"
+	ActorHeader     = "X-Actor"
+)
+
+// Revision is one write of an audited record, numbered from 1 per record.
+// Before and After are its json form around the write, null for the side
+// that did not exist, and Diff lists the FieldChanges between them.
+type Revision struct {
+	ID         uint      `json:"-" gorm:"primary_key"`
+	RecordType string    `json:"-" gorm:"uniqueIndex:idx_revisions_record,priority:1"`
+	RecordID   string    `json:"-" gorm:"uniqueIndex:idx_revisions_record,priority:2"`
+	Rev        int       `json:"rev" gorm:"uniqueIndex:idx_revisions_record,priority:3"`
+	Action     string    `json:"action"`
+	Actor      string    `json:"actor,omitempty"`
+	RequestID  string    `json:"requestId,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	Before     JSONText  `json:"before"`
+	After      JSONText  `json:"after"`
+	Diff       JSONText  `json:"diff"`
+}
+
+// FieldChange is a json field whose value differs between the Before and
+// After of a Revision.
+type FieldChange struct {
+	Field  string          `json:"field"`
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// JSONText is json stored in a text column, empty is null.
+type JSONText json.RawMessage
+
+func (j JSONText) Value() (driver.Value, error) {
+	if len(j) == 0 {
+		return nil, nil
+	}
+	return string(j), nil
+}
+
+func (j *JSONText) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*j = nil
+	case []byte:
+		*j = append(JSONText{}, v...)
+	case string:
+		*j = JSONText(v)
+	default:
+		return fmt.Errorf("Invalid JSONText %T", src)
+	}
+	return nil
+}
+
+func (j JSONText) MarshalJSON() ([]byte, error) {
+	if len(j) == 0 {
+		return []byte("null"), nil
+	}
+	return j, nil
+}
+
+func (j *JSONText) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		*j = nil
+	} else {
+		*j = append(JSONText{}, data...)
+	}
+	return nil
+}
+
+func (JSONText) GormDataType() string {
+	return "text"
+}
+
+type auditKey struct{}
+
+type auditInfo struct {
+	actor     string
+	requestID string
+}
+
+// WithAudit returns ctx carrying the actor and request ID recorded in the
+// revisions written with it.
+func WithAudit(ctx context.Context, actor string, requestID string) context.Context {
+	return context.WithValue(ctx, auditKey{}, auditInfo{actor: actor, requestID: requestID})
+}
+
+// AuditContext is a middleware passing the actor and request ID of the
+// request to the revisions it writes. The request ID is the X-Request-ID
+// header, or a generated one, and is echoed in the response. actor returns
+// the authenticated user, nil reads the X-Actor header.
+func AuditContext(actor func(c *gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if requestID == "" {
+			id := make([]byte, 16)
+			if _, err := rand.Read(id); err == nil {
+				requestID = hex.EncodeToString(id)
+			}
+		}
+		c.Header(RequestIDHeader, requestID)
+		name := c.GetHeader(ActorHeader)
+		if actor != nil {
+			name = actor(c)
+		}
+		c.Request = c.Request.WithContext(WithAudit(c.Request.Context(), name, requestID))
+		c.Next()
+	}
+}
+
+// MigrateAudit creates the revisions table.
+func MigrateAudit(db *gorm.DB) error {
+	return db.AutoMigrate(&Revision{})
+}
+
+func (r *Repository[T]) auditEnabled() error {
+	if !r.Audit {
+		var model T
+		return &BadRequestError{Err: fmt.Errorf("Audit is not enabled for %s", modelType(&model).Name())}
+	}
+	return nil
+}
+
+// audit writes the next revision of the record in tx, before is nil for a
+// create and after for a hard delete.
+func (r *Repository[T]) audit(tx *gorm.DB, action string, before *T, after *T) error {
+	if !r.Audit {
+		return nil
+	}
+	s, err := r.gormSchema()
+	if err != nil {
+		return err
+	}
+	record := after
+	if record == nil {
+		record = before
+	}
+	id, err := r.PrimaryKey(tx.Statement.Context, *record)
+	if err != nil {
+		return err
+	}
+
+	rev := Revision{RecordType: s.Table, RecordID: fmt.Sprint(id), Action: action}
+	if info, ok := tx.Statement.Context.Value(auditKey{}).(auditInfo); ok {
+		rev.Actor, rev.RequestID = info.actor, info.requestID
+	}
+	if rev.Before, err = snapshot(before); err != nil {
+		return err
+	}
+	if rev.After, err = snapshot(after); err != nil {
+		return err
+	}
+	if rev.Diff, err = diffSnapshots(rev.Before, rev.After); err != nil {
+		return err
+	}
+	// the unique index fails one of two concurrent writes of the same number
+	if err := tx.Model(&Revision{}).Select("COALESCE(MAX(rev), 0)").
+		Where("record_type = ? AND record_id = ?", rev.RecordType, rev.RecordID).Scan(&rev.Rev).Error; err != nil {
+		return r.db().TranslateError(err)
+	}
+	rev.Rev++
+	if err := tx.Create(&rev).Error; err != nil {
+		return r.db().TranslateError(err)
+	}
+	return nil
+}
+
+func snapshot[T any](data *T) (JSONText, error) {
+	if data == nil {
+		return nil, nil
+	}
+	return json.Marshal(data)
+}
+
+// diffSnapshots lists the fields that differ between two snapshots by name.
+func diffSnapshots(before JSONText, after JSONText) (JSONText, error) {
+	fields := []map[string]json.RawMessage{{}, {}}
+	for i, snap := range []JSONText{before, after} {
+		if len(snap) > 0 {
+			if err := json.Unmarshal(snap, &fields[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	names := []string{}
+	for _, m := range fields {
+		for name := range m {
+			if !containsString(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	changes := []FieldChange{}
+	for _, name := range names {
+		b, a := fields[0][name], fields[1][name]
+		if !bytes.Equal(b, a) {
+			changes = append(changes, FieldChange{Field: name, Before: b, After: a})
+		}
+	}
+	return json.Marshal(changes)
+}
+
+// History returns the page of the revisions of the record identified by id,
+// the latest first. The revisions outlive soft and hard deletes.
+func (r *Repository[T]) History(ctx context.Context, id any, offset int, limit int) (Page[Revision], error) {
+	page := Page[Revision]{Data: []Revision{}}
+	if err := r.auditEnabled(); err != nil {
+		return page, err
+	}
+	s, err := r.gormSchema()
+	if err != nil {
+		return page, err
+	}
+	tx := r.tx(ctx).Model(&Revision{}).Where("record_type = ? AND record_id = ?", s.Table, fmt.Sprint(id))
+	var count int64
+	if err := tx.Count(&count).Error; err != nil {
+		return page, r.db().TranslateError(err)
+	}
+	page.Count = &count
+	tx = tx.Order("rev DESC")
+	if offset > 0 {
+		tx = tx.Offset(offset)
+	}
+	if limit > 0 {
+		tx = tx.Limit(limit)
+	}
+	if err := tx.Find(&page.Data).Error; err != nil {
+		return page, r.db().TranslateError(err)
+	}
+	return page, nil
+}
+
+// FindRevision returns revision rev of the record identified by id.
+func (r *Repository[T]) FindRevision(ctx context.Context, id any, rev int) (Revision, error) {
+	var revision Revision
+	if err := r.auditEnabled(); err != nil {
+		return revision, err
+	}
+	s, err := r.gormSchema()
+	if err != nil {
+		return revision, err
+	}
+	if err := r.tx(ctx).Where("record_type = ? AND record_id = ? AND rev = ?", s.Table, fmt.Sprint(id), rev).
+		First(&revision).Error; err != nil {
+		return revision, r.db().TranslateError(err)
+	}
+	return revision, nil
+}
+
+// Revert updates the record identified by id back to its fields as of
+// revision rev, through Update so that it is checked against ifMatch and
+// audited as any update. The primary key, version and soft delete fields
+// are kept.
+func (r *Repository[T]) Revert(ctx context.Context, id any, rev int, ifMatch string) (T, error) {
+	var data T
+	revision, err := r.FindRevision(ctx, id, rev)
+	if err != nil {
+		return data, err
+	}
+	if len(revision.After) == 0 {
+		return data, &ConflictError{Err: fmt.Errorf("Revision %d deleted the record", rev)}
+	}
+	var old T
+	if err := json.Unmarshal(revision.After, &old); err != nil {
+		return data, err
+	}
+	s, err := r.gormSchema()
+	if err != nil {
+		return data, err
+	}
+	return r.Update(ctx, id, ifMatch, func(data *T) error {
+		dv, ov := reflect.ValueOf(data).Elem(), reflect.ValueOf(&old).Elem()
+		for _, field := range s.Fields {
+			if field.DBName == "" || field.PrimaryKey || isVersionField(field) || field.FieldType == deletedAtType {
+				continue
+			}
+			dv.FieldByIndex(field.StructField.Index).Set(ov.FieldByIndex(field.StructField.Index))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepository_Audit(t *testing.T) {
+	ctx := WithAudit(context.Background(), "haddock", "req-1")
+	repo := newTestRepository(t)
+	if err := MigrateAudit(repo.DB.DB); err != nil {
+		t.Fatal("MigrateAudit Error", err)
+	}
+	_, err := repo.History(ctx, 1, 0, 0)
+	var badRequest *BadRequestError
+	assert.True(t, errors.As(err, &badRequest), err)
+	repo.Audit = true
+
+	_, err = repo.Create(ctx, Book{Title: "Tintin in Tibet", Author: "Herge"})
+	assert.NoError(t, err)
+	_, err = repo.Update(ctx, 1, "", func(book *Book) error {
+		book.Author, book.Summary = "Hergé", "Chang is alive"
+		return nil
+	})
+	assert.NoError(t, err)
+	_, err = repo.Upsert(ctx, Book{Title: "Tintin in Tibet", Author: "Herge", Summary: "Chang is alive"})
+	assert.NoError(t, err)
+	_, err = repo.Delete(ctx, 1, "")
+	assert.NoError(t, err)
+	_, err = repo.Restore(ctx, 1, "")
+	assert.NoError(t, err)
+
+	page, err := repo.History(ctx, 1, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), *page.Count)
+	actions := []string{}
+	for _, rev := range page.Data {
+		actions = append(actions, rev.Action)
+		assert.Equal(t, "haddock", rev.Actor)
+		assert.Equal(t, "req-1", rev.RequestID)
+	}
+	assert.Equal(t, []string{AuditRestore, AuditDelete, AuditUpdate, AuditUpdate, AuditCreate}, actions)
+
+	rev, err := repo.FindRevision(ctx, 1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rev.Rev)
+	assert.JSONEq(t, `{"id":1,"title":"Tintin in Tibet","author":"Herge","deletedAt":null}`, string(rev.Before))
+	assert.JSONEq(t, `{"id":1,"title":"Tintin in Tibet","author":"Hergé","summary":"Chang is alive","deletedAt":null}`, string(rev.After))
+	assert.JSONEq(t, `[{"field":"author","before":"Herge","after":"Hergé"},{"field":"summary","before":null,"after":"Chang is alive"}]`, string(rev.Diff))
+	rev, err = repo.FindRevision(ctx, 1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "null", mustMarshal(t, rev.Before))
+
+	book, err := repo.Revert(ctx, 1, 1, "")
+	assert.NoError(t, err)
+	assert.Equal(t, Book{ID: 1, Title: "Tintin in Tibet", Author: "Herge"}, book)
+	page, err = repo.History(ctx, 1, 0, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), *page.Count)
+	assert.Equal(t, 6, page.Data[0].Rev)
+	assert.JSONEq(t, `[{"field":"summary","before":"Chang is alive","after":null}]`, string(page.Data[0].Diff))
+
+	var notFound *NotFoundError
+	_, err = repo.FindRevision(ctx, 1, 99)
+	assert.True(t, errors.As(err, &notFound), err)
+}
+
+func mustMarshal(t *testing.T, v any) string {
+	bb, err := json.Marshal(v)
+	assert.NoError(t, err)
+	return string(bb)
+}
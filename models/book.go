@@ -1,8 +1,13 @@
 package models
 
+import "gorm.io/gorm"
+
+// Book is soft deleted, its title only has to be unique among the books that
+// are not deleted.
 type Book struct {
-	ID      uint   `json:"id,omitempty" gorm:"primary_key"`
-	Title   string `json:"title,omitempty" gorm:"uniqueIndex"`
-	Author  string `json:"author,omitempty"`
-	Summary string `json:"summary,omitempty"`
+	ID        uint           `json:"id,omitempty" gorm:"primary_key"`
+	Title     string         `json:"title,omitempty" gorm:"uniqueIndex:idx_books_title_active,where:deleted_at IS NULL" query:"search"`
+	Author    string         `json:"author,omitempty" query:"search"`
+	Summary   string         `json:"summary,omitempty" query:"search"`
+	DeletedAt gorm.DeletedAt `json:"deletedAt,omitempty"`
 }
@@ -0,0 +1,337 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Operations of BulkItem.Op.
+const (
+	BulkCreate = "create"
+	BulkUpdate = "update"
+	BulkUpsert = "upsert"
+	BulkDelete = "delete"
+)
+
+// BulkMode selects how the operations of a bulk request are committed.
+// BulkAtomic runs all of them in one transaction, rolled back by the first
+// failure, BulkBestEffort commits every operation on its own.
+type BulkMode string
+
+const (
+	BulkAtomic     BulkMode = "atomic"
+	BulkBestEffort BulkMode = "best-effort"
+)
+
+// BulkRequest is the body of a bulk request, Mode defaults to BulkAtomic.
+type BulkRequest struct {
+	Mode       BulkMode   `json:"mode,omitempty"`
+	Operations []BulkItem `json:"operations"`
+}
+
+// BulkItem is one operation of a BulkRequest. ID identifies the record of
+// update and delete, Data is the input of create, upsert and update and
+// IfMatch is checked as the If-Match header.
+type BulkItem struct {
+	Op      string          `json:"op"`
+	ID      any             `json:"id,omitempty"`
+	IfMatch string          `json:"ifMatch,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// BulkOperation is a BulkItem with its data decoded. Data is the record to
+// create or upsert, Apply modifies the stored record of an update and Err
+// fails the operation, e.g. when its data did not decode.
+type BulkOperation[T any] struct {
+	Op      string
+	ID      any
+	IfMatch string
+	Data    T
+	Apply   func(*T) error
+	Err     error
+}
+
+// BulkResult is the outcome of one operation, Error is set instead of Data
+// when it failed.
+type BulkResult[T any] struct {
+	Status int      `json:"status"`
+	ID     any      `json:"id,omitempty"`
+	ETag   string   `json:"etag,omitempty"`
+	Data   *T       `json:"data,omitempty"`
+	Error  *Problem `json:"error,omitempty"`
+}
+
+var errBulkAborted = errors.New("bulk aborted")
+
+// Bulk runs ops in the given mode and returns their results in the same
+// order. When an operation fails in BulkAtomic mode the others are reported
+// as 424 Failed Dependency.
+func (r *Repository[T]) Bulk(ctx context.Context, mode BulkMode, ops []BulkOperation[T]) ([]BulkResult[T], error) {
+	if len(ops) == 0 {
+		return nil, &BadRequestError{Err: errors.New("Missing operations")}
+	}
+	results := make([]BulkResult[T], len(ops))
+	switch mode {
+	case BulkBestEffort:
+		for i, op := range ops {
+			results[i] = r.bulkResult(ctx, op)
+		}
+		return results, nil
+	case "", BulkAtomic:
+	default:
+		return nil, &BadRequestError{Err: fmt.Errorf("Unknown bulk mode %q", mode)}
+	}
+
+	failed := -1
+	err := r.tx(ctx).Transaction(func(tx *gorm.DB) error {
+		repo := r.withTx(tx)
+		for i, op := range ops {
+			if results[i] = repo.bulkResult(ctx, op); results[i].Error != nil {
+				failed = i
+				return errBulkAborted
+			}
+		}
+		return nil
+	})
+	if failed >= 0 {
+		for i := range results {
+			if i != failed {
+				detail := fmt.Sprintf("Rolled back, operations[%d] failed", failed)
+				results[i] = BulkResult[T]{Status: http.StatusFailedDependency, ID: ops[i].ID, Error: NewProblem(http.StatusFailedDependency, detail)}
+			}
+		}
+		return results, nil
+	} else if err != nil {
+		return nil, r.db().TranslateError(err)
+	}
+	return results, nil
+}
+
+func (r *Repository[T]) bulkResult(ctx context.Context, op BulkOperation[T]) BulkResult[T] {
+	result := BulkResult[T]{ID: op.ID}
+	data, err := op.Data, op.Err
+	if err == nil {
+		switch op.Op {
+		case BulkCreate:
+			result.Status = http.StatusCreated
+			data, err = r.Create(ctx, op.Data)
+		case BulkUpsert:
+			result.Status = http.StatusOK
+			data, err = r.Upsert(ctx, op.Data)
+		case BulkUpdate, BulkDelete:
+			result.Status = http.StatusOK
+			if op.ID == nil {
+				err = &BadRequestError{Err: fmt.Errorf("Missing id for %s", op.Op)}
+			} else if op.Op == BulkUpdate {
+				data, err = r.Update(ctx, op.ID, op.IfMatch, op.Apply)
+			} else {
+				data, err = r.Delete(ctx, op.ID, op.IfMatch)
+			}
+		default:
+			err = &BadRequestError{Err: fmt.Errorf("Unknown op %q", op.Op)}
+		}
+	}
+	if err != nil {
+		result.Error = r.db().ErrorMap(err)
+		result.Status = result.Error.Status
+		return result
+	}
+	if id, err := r.PrimaryKey(ctx, data); err == nil {
+		result.ID = id
+	}
+	if op.Op != BulkDelete {
+		result.ETag = r.ETag(data)
+		result.Data = &data
+	}
+	return result
+}
+
+// withTx returns a copy of the repository running its statements in tx.
+func (r *Repository[T]) withTx(tx *gorm.DB) *Repository[T] {
+	db := *r.db()
+	db.DB = tx
+	repo := *r
+	repo.DB = &db
+	return &repo
+}
+
+// Upsert inserts data or, when a record with the same upsert key exists,
+// overwrites its columns with the ones of data. It is refused when the
+// repository requires If-Match.
+func (r *Repository[T]) Upsert(ctx context.Context, data T) (T, error) {
+	if r.RequireIfMatch {
+		return data, &PreconditionRequiredError{Err: errors.New("Upsert is not allowed, If-Match is required")}
+	}
+	s, err := r.gormSchema()
+	if err != nil {
+		return data, err
+	}
+	keys, err := r.upsertKey()
+	if err != nil {
+		return data, err
+	}
+
+	conflict := clause.OnConflict{}
+	for _, key := range keys {
+		conflict.Columns = append(conflict.Columns, clause.Column{Name: key})
+	}
+	if where := r.uniqueIndexWhere(keys); where != "" {
+		// a partial index is only inferred with its predicate
+		conflict.TargetWhere = clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: where}}}
+	}
+	columns := []string{}
+	for _, field := range s.Fields {
+		if field.DBName == "" || field.PrimaryKey || field.AutoCreateTime != 0 || field.FieldType == deletedAtType || containsString(keys, field.DBName) {
+			continue
+		}
+		if isVersionField(field) {
+			if v := reflect.ValueOf(&data).Elem().FieldByIndex(field.StructField.Index); v.IsZero() {
+				bumpVersion(v)
+			}
+			conflict.DoUpdates = append(conflict.DoUpdates, clause.Assignment{
+				Column: clause.Column{Name: field.DBName},
+				Value:  clause.Expr{SQL: "? + 1", Vars: []any{clause.Column{Table: s.Table, Name: field.DBName}}},
+			})
+			continue
+		}
+		columns = append(columns, field.DBName)
+	}
+	conflict.DoUpdates = append(conflict.DoUpdates, clause.AssignmentColumns(columns)...)
+
+	where := clause.Where{}
+	for _, key := range keys {
+		value, _ := s.LookUpField(key).ValueOf(ctx, reflect.ValueOf(&data))
+		where.Exprs = append(where.Exprs, clause.Eq{Column: clause.Column{Name: key}, Value: value})
+	}
+	err = r.write(ctx, func(tx *gorm.DB) error {
+		// the revision and the outbox event tell a create from an update:
+		// the existing record is locked until the write, a missing one is
+		// inserted alone so that a concurrent insert of its key makes the
+		// upsert an update
+		var before *T
+		created := false
+		if r.Audit || r.Outbox {
+			existing, err := r.lockKey(tx, where)
+			if err != nil {
+				return err
+			}
+			if existing == nil {
+				insert := clause.OnConflict{Columns: conflict.Columns, TargetWhere: conflict.TargetWhere, DoNothing: true}
+				res := tx.Clauses(insert).Create(&data)
+				if res.Error != nil {
+					return r.db().TranslateError(res.Error)
+				}
+				if created = res.RowsAffected > 0; !created {
+					if existing, err = r.lockKey(tx, where); err != nil {
+						return err
+					}
+				}
+			}
+			before = existing
+		}
+		if !created {
+			if err := tx.Clauses(conflict).Create(&data).Error; err != nil {
+				return r.db().TranslateError(err)
+			}
+		}
+		// without RETURNING, or with a bumped version, the stored record
+		// is only known by its key
+		if _, zero := s.PrioritizedPrimaryField.ValueOf(ctx, reflect.ValueOf(&data)); zero || r.hasVersion() {
+			if err := tx.Clauses(where).First(&data).Error; err != nil {
+				return r.db().TranslateError(err)
+			}
+		}
+		if err := r.indexSearch(tx, &data); err != nil {
+			return err
+		}
+		if before == nil {
+			return r.changed(tx, AuditCreate, nil, &data)
+		}
+		return r.changed(tx, AuditUpdate, before, &data)
+	})
+	return data, err
+}
+
+// lockKey returns the record matching where, locked for update on the
+// databases supporting it, nil when there is none.
+func (r *Repository[T]) lockKey(tx *gorm.DB, where clause.Where) (*T, error) {
+	tx = tx.Clauses(where)
+	if dialect := r.db().Dialect; dialect == "postgres" || dialect == "mysql" {
+		tx = tx.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+	var existing T
+	if res := tx.Limit(1).Find(&existing); res.Error != nil {
+		return nil, r.db().TranslateError(res.Error)
+	} else if res.RowsAffected == 0 {
+		return nil, nil
+	}
+	return &existing, nil
+}
+
+func (r *Repository[T]) hasVersion() bool {
+	field, err := r.versionField()
+	return err == nil && field != nil
+}
+
+// upsertKey returns the columns identifying the record of an upsert,
+// Repository.UpsertKey or the first unique index of T.
+func (r *Repository[T]) upsertKey() ([]string, error) {
+	if len(r.UpsertKey) > 0 {
+		return r.UpsertKey, nil
+	}
+	s, err := r.gormSchema()
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	indexes := s.ParseIndexes()
+	for name, idx := range indexes {
+		if idx.Class == "UNIQUE" && len(idx.Fields) > 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 {
+		sort.Strings(names)
+		keys := []string{}
+		for _, f := range indexes[names[0]].Fields {
+			keys = append(keys, f.DBName)
+		}
+		return keys, nil
+	}
+	for _, field := range s.Fields {
+		if field.Unique && !field.PrimaryKey {
+			return []string{field.DBName}, nil
+		}
+	}
+	return nil, fmt.Errorf("No unique key to upsert %s", s.Name)
+}
+
+// uniqueIndexWhere returns the predicate of the partial unique index on keys,
+// empty when the index is not partial.
+func (r *Repository[T]) uniqueIndexWhere(keys []string) string {
+	s, err := r.gormSchema()
+	if err != nil {
+		return ""
+	}
+	for _, idx := range s.ParseIndexes() {
+		if idx.Class != "UNIQUE" || len(idx.Fields) != len(keys) {
+			continue
+		}
+		match := true
+		for i, f := range idx.Fields {
+			match = match && f.DBName == keys[i]
+		}
+		if match {
+			return idx.Where
+		}
+	}
+	return ""
+}
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestRepository_Upsert(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+
+	book, err := repo.Upsert(ctx, Book{Title: "Tintin in Tibet", Author: "Herge"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), book.ID)
+
+	_, err = repo.Create(ctx, Book{Title: "Tintin in America", Author: "Herge"})
+	assert.NoError(t, err)
+
+	book, err = repo.Upsert(ctx, Book{Title: "Tintin in Tibet", Author: "Hergé", Summary: "Chang is alive"})
+	assert.NoError(t, err)
+	assert.Equal(t, Book{ID: 1, Title: "Tintin in Tibet", Author: "Hergé", Summary: "Chang is alive"}, book)
+
+	book, err = repo.Find(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hergé", book.Author)
+
+	repo.RequireIfMatch = true
+	var preconditionRequired *PreconditionRequiredError
+	_, err = repo.Upsert(ctx, Book{Title: "Tintin in Tibet"})
+	assert.True(t, errors.As(err, &preconditionRequired), err)
+}
+
+func TestRepository_UpsertConcurrentCreate(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	if err := MigrateAudit(repo.DB.DB); err != nil {
+		t.Fatal("MigrateAudit Error", err)
+	}
+	repo.Audit = true
+
+	// a concurrent writer inserts the key between the lookup and the write
+	concurrent := true
+	repo.DB.DB.Callback().Create().Before("gorm:create").Register("test:concurrent", func(tx *gorm.DB) {
+		if concurrent && tx.Statement.Table == "books" {
+			concurrent = false
+			tx.Session(&gorm.Session{NewDB: true}).Exec("INSERT INTO books (title, author) VALUES ('Tintin in Tibet', 'Herge')")
+		}
+	})
+	book, err := repo.Upsert(ctx, Book{Title: "Tintin in Tibet", Author: "Hergé"})
+	assert.NoError(t, err)
+	assert.Equal(t, Book{ID: 1, Title: "Tintin in Tibet", Author: "Hergé"}, book)
+
+	history, err := repo.History(ctx, 1, 0, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, history.Data, 1) {
+		assert.Equal(t, AuditUpdate, history.Data[0].Action)
+	}
+}
+
+func TestRepository_Bulk(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	_, err := repo.Create(ctx, Book{Title: "Tintin in Tibet", Author: "Herge"})
+	assert.NoError(t, err)
+
+	rename := func(title string) func(*Book) error {
+		return func(book *Book) error {
+			book.Title = title
+			return nil
+		}
+	}
+	results, err := repo.Bulk(ctx, BulkBestEffort, []BulkOperation[Book]{
+		{Op: BulkCreate, Data: Book{Title: "Tintin in America", Author: "Herge"}},
+		{Op: BulkCreate, Data: Book{Title: "Tintin in Tibet", Author: "Herge"}},
+		{Op: BulkUpsert, Data: Book{Title: "Tintin in Tibet", Author: "Hergé"}},
+		{Op: BulkUpdate, ID: 2, Apply: rename("Tintin in Congo")},
+		{Op: BulkDelete, ID: 9999},
+		{Op: BulkUpdate, Err: &BadRequestError{Err: errors.New("Invalid data")}},
+		{Op: "merge", ID: 1},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{201, 409, 200, 200, 404, 400, 400}, bulkStatus(results))
+	assert.Equal(t, uint(2), results[0].ID)
+	assert.Equal(t, "Hergé", results[2].Data.Author)
+	assert.Equal(t, "Tintin in Congo", results[3].Data.Title)
+	assert.Equal(t, "unique_violation", results[1].Error.Code)
+	assert.Equal(t, `Unknown op "merge"`, results[6].Error.Detail)
+
+	results, err = repo.Bulk(ctx, BulkAtomic, []BulkOperation[Book]{
+		{Op: BulkCreate, Data: Book{Title: "Tintin in Jakarta", Author: "Herge"}},
+		{Op: BulkDelete, ID: 1},
+		{Op: BulkUpdate, ID: 2, Apply: rename("Tintin in Jakarta")},
+		{Op: BulkCreate, Data: Book{Title: "Tintin in Sydney", Author: "Herge"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{424, 424, 409, 424}, bulkStatus(results))
+	assert.Equal(t, "Rolled back, operations[2] failed", results[0].Error.Detail)
+	page, err := repo.Finds(ctx, nil, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), *page.Count)
+
+	results, err = repo.Bulk(ctx, "", []BulkOperation[Book]{
+		{Op: BulkCreate, Data: Book{Title: "Tintin in Jakarta", Author: "Herge"}},
+		{Op: BulkDelete, ID: 1},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{201, 200}, bulkStatus(results))
+	page, err = repo.Finds(ctx, nil, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{2, 3}, []uint{page.Data[0].ID, page.Data[1].ID})
+
+	_, err = repo.Bulk(ctx, "sometimes", []BulkOperation[Book]{{Op: BulkDelete, ID: 1}})
+	assert.EqualError(t, err, `Unknown bulk mode "sometimes"`)
+	_, err = repo.Bulk(ctx, BulkAtomic, nil)
+	assert.EqualError(t, err, "Missing operations")
+}
+
+func bulkStatus(results []BulkResult[Book]) []int {
+	status := []int{}
+	for _, r := range results {
+		status = append(status, r.Status)
+	}
+	return status
+}
@@ -0,0 +1,242 @@
+package models
+
+import (
+	"errors"
+	"regexp"
+	"sync"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/gorm/schema"
+)
+
+type ConstraintKind string
+
+const (
+	ConstraintUnique     ConstraintKind = "unique"
+	ConstraintForeignKey ConstraintKind = "foreign_key"
+	ConstraintNotNull    ConstraintKind = "not_null"
+	ConstraintCheck      ConstraintKind = "check"
+)
+
+// Constraint describes a database constraint of a model. Name is the
+// constraint or index name in the database, Field the json field reported to
+// clients and Code the stable error code, "<kind>_violation" when empty.
+type Constraint struct {
+	Name   string
+	Kind   ConstraintKind
+	Table  string
+	Column string
+	Field  string
+	Code   string
+}
+
+// Constrained is implemented by models declaring constraints that can not be
+// derived from their gorm tags, such as foreign keys or custom error codes.
+type Constrained interface {
+	Constraints() []Constraint
+}
+
+var constraints = struct {
+	sync.RWMutex
+	byName   map[string]*Constraint
+	byColumn map[string]*Constraint
+	models   map[string]bool
+}{byName: map[string]*Constraint{}, byColumn: map[string]*Constraint{}, models: map[string]bool{}}
+
+// RegisterConstraints records the unique indexes, not null columns and check
+// constraints from the gorm tags of model, followed by the constraints it
+// declares through Constrained.
+func RegisterConstraints(model any) error {
+	s, err := schema.Parse(model, &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		return err
+	}
+	constraints.Lock()
+	defer constraints.Unlock()
+	if constraints.models[s.Table] {
+		return nil
+	}
+	constraints.models[s.Table] = true
+
+	fields := SchemaOf(model)
+	add := func(c Constraint) {
+		if c.Table == "" {
+			c.Table = s.Table
+		}
+		if c.Field == "" {
+			c.Field = c.Column
+			for _, f := range fields.Fields {
+				if f.Column == c.Column {
+					c.Field = f.Name
+				}
+			}
+		}
+		if c.Code == "" {
+			c.Code = string(c.Kind) + "_violation"
+		}
+		if c.Name != "" {
+			constraints.byName[c.Name] = &c
+		}
+		if c.Column != "" {
+			constraints.byColumn[c.Table+"."+c.Column+"."+string(c.Kind)] = &c
+		}
+	}
+	for _, idx := range s.ParseIndexes() {
+		if idx.Class == "UNIQUE" && len(idx.Fields) > 0 {
+			add(Constraint{Name: idx.Name, Kind: ConstraintUnique, Column: idx.Fields[0].DBName})
+		}
+	}
+	for _, f := range s.Fields {
+		if f.Unique {
+			add(Constraint{Kind: ConstraintUnique, Column: f.DBName})
+		}
+		if f.NotNull {
+			add(Constraint{Kind: ConstraintNotNull, Column: f.DBName})
+		}
+	}
+	for _, chk := range s.ParseCheckConstraints() {
+		c := Constraint{Name: chk.Name, Kind: ConstraintCheck}
+		if chk.Field != nil {
+			c.Column = chk.Field.DBName
+		}
+		add(c)
+	}
+	if m, ok := model.(Constrained); ok {
+		for _, c := range m.Constraints() {
+			add(c)
+		}
+	}
+	return nil
+}
+
+func lookupConstraint(c Constraint) Constraint {
+	constraints.RLock()
+	defer constraints.RUnlock()
+	if r, ok := constraints.byName[c.Name]; ok && c.Name != "" {
+		return *r
+	}
+	if r, ok := constraints.byColumn[c.Table+"."+c.Column+"."+string(c.Kind)]; ok && c.Column != "" {
+		return *r
+	}
+	if c.Field == "" {
+		c.Field = c.Column
+	}
+	c.Code = string(c.Kind) + "_violation"
+	return c
+}
+
+var (
+	sqliteColumn = regexp.MustCompile(`constraint failed: ([^.\s,]+)\.([^\s,]+)`)
+	sqliteCheck  = regexp.MustCompile(`CHECK constraint failed: (\S+)`)
+	mysqlKey     = regexp.MustCompile(`for key '(?:[^.']*\.)?([^']*)'`)
+	mysqlFK      = regexp.MustCompile("CONSTRAINT `([^`]*)`")
+	mysqlQuoted  = regexp.MustCompile(`'([^']*)'`)
+)
+
+// sqliteConstraint classifies by extended result code, sqlite only reports
+// the offending table.column inside the message.
+func sqliteConstraint(err error) (Constraint, bool) {
+	var e sqlite3.Error
+	if !errors.As(err, &e) || e.Code != sqlite3.ErrConstraint {
+		return Constraint{}, false
+	}
+	c := Constraint{}
+	switch e.ExtendedCode {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		c.Kind = ConstraintUnique
+	case sqlite3.ErrConstraintForeignKey:
+		c.Kind = ConstraintForeignKey
+	case sqlite3.ErrConstraintNotNull:
+		c.Kind = ConstraintNotNull
+	case sqlite3.ErrConstraintCheck:
+		c.Kind = ConstraintCheck
+		if match := sqliteCheck.FindStringSubmatch(e.Error()); match != nil {
+			c.Name = match[1]
+		}
+		return c, true
+	default:
+		return Constraint{}, false
+	}
+	if match := sqliteColumn.FindStringSubmatch(e.Error()); match != nil {
+		c.Table, c.Column = match[1], match[2]
+	}
+	return c, true
+}
+
+// postgresConstraint classifies by SQLSTATE class 23 codes.
+func postgresConstraint(err error) (Constraint, bool) {
+	var e *pgconn.PgError
+	if !errors.As(err, &e) {
+		return Constraint{}, false
+	}
+	c := Constraint{Name: e.ConstraintName, Table: e.TableName, Column: e.ColumnName}
+	switch e.Code {
+	case "23505":
+		c.Kind = ConstraintUnique
+	case "23503":
+		c.Kind = ConstraintForeignKey
+	case "23502":
+		c.Kind = ConstraintNotNull
+	case "23514":
+		c.Kind = ConstraintCheck
+	default:
+		return Constraint{}, false
+	}
+	return c, true
+}
+
+// mysqlConstraint classifies by server error number, the constraint or
+// column name is only available in the message.
+func mysqlConstraint(err error) (Constraint, bool) {
+	var e *mysql.MySQLError
+	if !errors.As(err, &e) {
+		return Constraint{}, false
+	}
+	c := Constraint{}
+	var match []string
+	switch e.Number {
+	case 1062:
+		c.Kind = ConstraintUnique
+		if match = mysqlKey.FindStringSubmatch(e.Message); match != nil {
+			c.Name = match[1]
+		}
+	case 1451, 1452:
+		c.Kind = ConstraintForeignKey
+		if match = mysqlFK.FindStringSubmatch(e.Message); match != nil {
+			c.Name = match[1]
+		}
+	case 1048:
+		c.Kind = ConstraintNotNull
+		if match = mysqlQuoted.FindStringSubmatch(e.Message); match != nil {
+			c.Column = match[1]
+		}
+	case 3819:
+		c.Kind = ConstraintCheck
+		if match = mysqlQuoted.FindStringSubmatch(e.Message); match != nil {
+			c.Name = match[1]
+		}
+	default:
+		return Constraint{}, false
+	}
+	return c, true
+}
+
+func constraintTranslator(extract func(error) (Constraint, bool)) func(error) error {
+	return func(err error) error {
+		if c, ok := extract(err); ok {
+			return &ConstraintError{Constraint: lookupConstraint(c), Err: err}
+		}
+		return err
+	}
+}
+
+func (c Constraint) target() string {
+	if c.Table != "" && c.Column != "" {
+		return c.Table + "." + c.Column
+	} else if c.Column != "" {
+		return c.Column
+	}
+	return c.Name
+}
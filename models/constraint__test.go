@@ -0,0 +1,110 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+	test_lib "github.com/senomas/go-api/test/lib"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type constraintTestModel struct {
+	ID     uint   `json:"id" gorm:"primary_key"`
+	Code   string `json:"code" gorm:"uniqueIndex:idx_constraint_code"`
+	Name   string `json:"name" gorm:"not null"`
+	Amount int    `json:"amount" gorm:"check:chk_amount,amount >= 0"`
+}
+
+func (constraintTestModel) TableName() string {
+	return "constraint_tests"
+}
+
+func (constraintTestModel) Constraints() []Constraint {
+	return []Constraint{
+		{Name: "idx_constraint_code", Kind: ConstraintUnique, Column: "code", Code: "code_taken"},
+		{Name: "chk_amount", Kind: ConstraintCheck, Column: "amount", Code: "negative_amount"},
+	}
+}
+
+func translateTest(t *testing.T, translate func(error) error, err error) *ConstraintError {
+	var ce *ConstraintError
+	if !errors.As(translate(err), &ce) {
+		t.Fatal("not a ConstraintError", err)
+	}
+	return ce
+}
+
+func TestConstraint_Sqlite(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:constraint?mode=memory"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal("Init GORM Error", err)
+	}
+	if err := db.AutoMigrate(&constraintTestModel{}); err != nil {
+		t.Fatal("AutoMigrate Error", err)
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.SetMaxOpenConns(1)
+	}
+	ctx := context.Background()
+	repo := NewRepository[constraintTestModel](NewDatabaseModel(db))
+
+	_, err = repo.Create(ctx, constraintTestModel{Code: "A", Name: "first"})
+	assert.NoError(t, err)
+
+	var ce *ConstraintError
+	_, err = repo.Create(ctx, constraintTestModel{Code: "A", Name: "second"})
+	assert.True(t, errors.As(err, &ce), err)
+	assert.Equal(t, test_lib.Marshal(t, Constraint{Name: "idx_constraint_code", Kind: ConstraintUnique, Table: "constraint_tests", Column: "code", Field: "code", Code: "code_taken"}), test_lib.Marshal(t, ce.Constraint))
+
+	_, err = repo.Create(ctx, constraintTestModel{Code: "B", Name: "negative", Amount: -1})
+	assert.True(t, errors.As(err, &ce), err)
+	assert.Equal(t, "negative_amount", ce.Constraint.Code)
+	assert.Equal(t, "amount", ce.Constraint.Field)
+
+	err = db.Exec("INSERT INTO constraint_tests (code, name) VALUES (?, NULL)", "C").Error
+	ce = translateTest(t, repo.DB.TranslateError, err)
+	assert.Equal(t, ConstraintNotNull, ce.Constraint.Kind)
+	assert.Equal(t, "name", ce.Constraint.Field)
+	assert.Equal(t, "not_null_violation", ce.Constraint.Code)
+}
+
+func TestConstraint_Postgres(t *testing.T) {
+	RegisterConstraints(&Book{})
+	translate := constraintTranslator(postgresConstraint)
+
+	ce := translateTest(t, translate, &pgconn.PgError{Severity: "ERROR", Code: "23505", TableName: "books", ConstraintName: "idx_books_title_active"})
+	assert.Equal(t, "title", ce.Constraint.Field)
+	assert.Equal(t, "unique_violation", ce.Constraint.Code)
+	assert.Equal(t, "Duplicate value books.title", ce.Error())
+
+	ce = translateTest(t, translate, &pgconn.PgError{Severity: "ERROR", Code: "23503", TableName: "books", ConstraintName: "fk_books_publisher", ColumnName: "publisher_id"})
+	assert.Equal(t, ConstraintForeignKey, ce.Constraint.Kind)
+	assert.Equal(t, "publisher_id", ce.Constraint.Field)
+	assert.Equal(t, "foreign_key_violation", ce.Constraint.Code)
+
+	err := &pgconn.PgError{Severity: "ERROR", Code: "42P01"}
+	assert.Equal(t, error(err), translate(err))
+}
+
+func TestConstraint_Mysql(t *testing.T) {
+	RegisterConstraints(&Book{})
+	translate := constraintTranslator(mysqlConstraint)
+
+	ce := translateTest(t, translate, &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'Tintin in Tibet' for key 'books.idx_books_title_active'"})
+	assert.Equal(t, "title", ce.Constraint.Field)
+	assert.Equal(t, "Duplicate value books.title", ce.Error())
+
+	ce = translateTest(t, translate, &mysql.MySQLError{Number: 1048, Message: "Column 'author' cannot be null"})
+	assert.Equal(t, ConstraintNotNull, ce.Constraint.Kind)
+	assert.Equal(t, "author", ce.Constraint.Field)
+
+	ce = translateTest(t, translate, &mysql.MySQLError{Number: 3819, Message: "Check constraint 'chk_amount' is violated."})
+	assert.Equal(t, ConstraintCheck, ce.Constraint.Kind)
+	assert.Equal(t, "chk_amount", ce.Constraint.Name)
+}
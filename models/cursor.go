@@ -0,0 +1,188 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm/schema"
+)
+
+type CountMode string
+
+const (
+	CountExact    CountMode = "exact"
+	CountEstimate CountMode = "estimate"
+	CountNone     CountMode = "none"
+)
+
+// PageOptions selects offset or keyset pagination for Repository.Paginate.
+// With Keyset set, Cursor is the next or prev cursor of a previous page, or
+// empty for the first page, and Offset must be zero.
+type PageOptions struct {
+	Offset int
+	Limit  int
+	Keyset bool
+	Cursor string
+	Count  CountMode
+}
+
+// keysetKey is one column of the keyset order, the OrderBy keys followed by
+// the primary key.
+type keysetKey struct {
+	field *schema.Field
+	desc  bool
+}
+
+// pageCursor is the signed payload of a cursor. Order fingerprints the keyset
+// so a cursor can not be replayed against another ordering, Prev marks the
+// cursor of the rows before the first row of a page.
+type pageCursor struct {
+	Order  string            `json:"o"`
+	Values []json.RawMessage `json:"k"`
+	Prev   bool              `json:"p,omitempty"`
+	values []any
+}
+
+var defaultCursorKey = func() []byte {
+	if key := os.Getenv("CURSOR_KEY"); key != "" {
+		return []byte(key)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+func (r *Repository[T]) keysetKeys(query *Query) ([]keysetKey, error) {
+	s, err := r.gormSchema()
+	if err != nil {
+		return nil, err
+	}
+	pk := s.PrioritizedPrimaryField
+	if pk == nil {
+		return nil, fmt.Errorf("No primary key %s", s.Name)
+	}
+	keys := []keysetKey{}
+	desc := false
+	for _, key := range query.OrderBy.Keys {
+		if key.Nulls != "" {
+			return nil, &BadRequestError{Err: fmt.Errorf("Cursor does not support nulls ordering of %s", key.Field)}
+		}
+		desc = key.Desc
+		column := r.Schema.Column(key.Field)
+		if column == pk.DBName {
+			// the primary key is unique, further keys never apply
+			return append(keys, keysetKey{field: pk, desc: desc}), nil
+		}
+		field := s.LookUpField(column)
+		if field == nil {
+			return nil, fmt.Errorf("Unknown column %s", column)
+		}
+		keys = append(keys, keysetKey{field: field, desc: desc})
+	}
+	return append(keys, keysetKey{field: pk, desc: desc}), nil
+}
+
+func keysetOrder(keys []keysetKey) string {
+	order := []string{}
+	for _, k := range keys {
+		if k.desc {
+			order = append(order, k.field.DBName+" DESC")
+		} else {
+			order = append(order, k.field.DBName)
+		}
+	}
+	return strings.Join(order, ",")
+}
+
+// keysetWhere returns the condition selecting the rows after the cursor in
+// keyset order, or before it for prev cursors.
+func keysetWhere(keys []keysetKey, cursor *pageCursor) (string, []any) {
+	ors := []string{}
+	params := []any{}
+	for i, k := range keys {
+		ands := []string{}
+		for j := 0; j < i; j++ {
+			ands = append(ands, keys[j].field.DBName+" = ?")
+			params = append(params, cursor.values[j])
+		}
+		op := ">"
+		if k.desc != cursor.Prev {
+			op = "<"
+		}
+		ands = append(ands, k.field.DBName+" "+op+" ?")
+		params = append(params, cursor.values[i])
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	return strings.Join(ors, " OR "), params
+}
+
+func (db *DatabaseModel) cursorKey() []byte {
+	if db.CursorKey != nil {
+		return db.CursorKey
+	}
+	return defaultCursorKey
+}
+
+// encodeCursor returns the keyset values of row as an opaque cursor, the
+// base64 payload and its HMAC-SHA256 signature.
+func (db *DatabaseModel) encodeCursor(row reflect.Value, keys []keysetKey, prev bool) (string, error) {
+	c := pageCursor{Order: keysetOrder(keys), Prev: prev}
+	for _, k := range keys {
+		bytes, err := json.Marshal(row.FieldByIndex(k.field.StructField.Index).Interface())
+		if err != nil {
+			return "", err
+		}
+		c.Values = append(c.Values, bytes)
+	}
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, db.cursorKey())
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func (db *DatabaseModel) decodeCursor(cursor string, keys []keysetKey) (*pageCursor, error) {
+	invalid := &BadRequestError{Err: errors.New("Invalid cursor")}
+	parts := strings.Split(cursor, ".")
+	if len(parts) != 2 {
+		return nil, invalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, invalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, invalid
+	}
+	mac := hmac.New(sha256.New, db.cursorKey())
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, invalid
+	}
+
+	c := &pageCursor{}
+	if err := json.Unmarshal(payload, c); err != nil || c.Order != keysetOrder(keys) || len(c.Values) != len(keys) {
+		return nil, invalid
+	}
+	for i, k := range keys {
+		v := reflect.New(k.field.FieldType)
+		if err := json.Unmarshal(c.Values[i], v.Interface()); err != nil {
+			return nil, invalid
+		}
+		c.values = append(c.values, v.Elem().Interface())
+	}
+	return c, nil
+}
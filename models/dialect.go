@@ -0,0 +1,105 @@
+package models
+
+import (
+	"strings"
+	"sync"
+)
+
+// Dialect renders the Condition operators whose SQL differs between
+// databases. Like returns the SQL matching column against the LIKE pattern,
+// where \ escapes the % and _ wildcards, and the parameter to bind. LIKE is
+// case sensitive and ILIKE case insensitive on every dialect.
+type Dialect interface {
+	Like(column string, pattern string, insensitive bool) (string, any)
+}
+
+var dialects sync.Map
+
+func init() {
+	RegisterDialect("postgres", postgresDialect{})
+	RegisterDialect("sqlite", sqliteDialect{})
+	RegisterDialect("mysql", mysqlDialect{})
+}
+
+// RegisterDialect sets the Dialect used for the gorm dialector name.
+func RegisterDialect(name string, d Dialect) {
+	dialects.Store(name, d)
+}
+
+// DialectOf returns the Dialect registered for name, the postgres one when
+// there is none.
+func DialectOf(name string) Dialect {
+	if d, ok := dialects.Load(name); ok {
+		return d.(Dialect)
+	}
+	return postgresDialect{}
+}
+
+func (db *DatabaseModel) SQLDialect() Dialect {
+	return DialectOf(db.Dialect)
+}
+
+// postgres LIKE is case sensitive, ILIKE is native and \ is the default
+// escape character.
+type postgresDialect struct{}
+
+func (postgresDialect) Like(column string, pattern string, insensitive bool) (string, any) {
+	if insensitive {
+		return column + " ILIKE ?", pattern
+	}
+	return column + " LIKE ?", pattern
+}
+
+// sqlite LIKE ignores the case of ASCII letters and has no default escape
+// character, case sensitive matches use GLOB.
+type sqliteDialect struct{}
+
+func (sqliteDialect) Like(column string, pattern string, insensitive bool) (string, any) {
+	if insensitive {
+		return column + ` LIKE ? ESCAPE '\'`, pattern
+	}
+	return column + " GLOB ?", likeToGlob(pattern)
+}
+
+// mysql LIKE follows the collation of the column, which is case insensitive
+// by default.
+type mysqlDialect struct{}
+
+func (mysqlDialect) Like(column string, pattern string, insensitive bool) (string, any) {
+	if insensitive {
+		return "LOWER(" + column + ") LIKE LOWER(?)", pattern
+	}
+	return column + " LIKE BINARY ?", pattern
+}
+
+// EscapeLike escapes the LIKE wildcards of s, so that it matches literally.
+func EscapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// likeToGlob translates a LIKE pattern to the sqlite GLOB syntax.
+func likeToGlob(pattern string) string {
+	sb := strings.Builder{}
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			c = pattern[i]
+			if c == '*' || c == '?' || c == '[' {
+				sb.WriteString("[" + string(c) + "]")
+			} else {
+				sb.WriteByte(c)
+			}
+		case c == '%':
+			sb.WriteByte('*')
+		case c == '_':
+			sb.WriteByte('?')
+		case c == '*' || c == '?' || c == '[':
+			sb.WriteString("[" + string(c) + "]")
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialect_Like(t *testing.T) {
+	condition := NewCondition().Like("title", "100%_done").ILike("author", "rowling")
+
+	where, params := condition.ApplyDialect(DialectOf("postgres"), "", []any{})
+	assert.Equal(t, "title LIKE ? AND author ILIKE ?", where)
+	assert.Equal(t, []any{`%100\%\_done%`, "%rowling%"}, params)
+
+	where, params = condition.ApplyDialect(DialectOf("sqlite"), "", []any{})
+	assert.Equal(t, `title GLOB ? AND author LIKE ? ESCAPE '\'`, where)
+	assert.Equal(t, []any{"*100%_done*", "%rowling%"}, params)
+
+	where, params = condition.ApplyDialect(DialectOf("mysql"), "", []any{})
+	assert.Equal(t, "title LIKE BINARY ? AND LOWER(author) LIKE LOWER(?)", where)
+	assert.Equal(t, []any{`%100\%\_done%`, "%rowling%"}, params)
+}
+
+func TestDialect_LikePattern(t *testing.T) {
+	condition := NewCondition().LikePattern("title", "Harry_Potter*%")
+
+	where, params := condition.Apply("", []any{})
+	assert.Equal(t, "title LIKE ?", where)
+	assert.Equal(t, []any{"Harry_Potter*%"}, params)
+
+	_, params = condition.ApplyDialect(DialectOf("sqlite"), "", []any{})
+	assert.Equal(t, []any{"Harry?Potter[*]*"}, params)
+}
+
+func TestDialect_MatchMode(t *testing.T) {
+	value := NewCondition()
+	assert.NoError(t, json.Unmarshal([]byte(`{"o":"AND","e":[{"o":"LIKE","f":"title","v":"%Tin_in%","m":"pattern"},{"o":"LIKE","f":"title","v":"Tin_in","m":"prefix"},{"o":"LIKE","f":"title","v":"100%"}]}`), value))
+
+	where, params := value.Apply("", []any{})
+	assert.Equal(t, "title LIKE ? AND title LIKE ? AND title LIKE ?", where)
+	assert.Equal(t, []any{"%Tin_in%", `Tin\_in%`, `100\%`}, params)
+
+	err := json.Unmarshal([]byte(`{"o":"AND","e":[{"o":"LIKE","f":"title","v":"Tintin","m":"glob"}]}`), value)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "UNSUPPORTED MATCH MODE glob")
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	assert.Equal(t, `50\%\_off\\`, EscapeLike(`50%_off\`))
+	assert.Equal(t, "[[]a]*", likeToGlob(`[a]%`))
+	assert.Equal(t, "a%_", likeToGlob(`a\%\_`))
+}
+
+func TestDialect_LikeLiteral(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	for _, title := range []string{"Tin_in", "Tintin", "100% Tintin", "1000 Tintin"} {
+		_, err := repo.Create(ctx, Book{Title: title, Author: "Herge"})
+		assert.NoError(t, err)
+	}
+	titles := func(condition string) []string {
+		query := repo.Schema.NewQuery()
+		assert.NoError(t, json.Unmarshal([]byte(`{"condition":{"o":"AND","e":[`+condition+`]}}`), query))
+		page, err := repo.Finds(ctx, query.SortBy(Asc("id")), 0, 0)
+		assert.NoError(t, err)
+		titles := []string{}
+		for _, book := range page.Data {
+			titles = append(titles, book.Title)
+		}
+		return titles
+	}
+	// without a mode the % and _ of the value are no wildcards
+	assert.Equal(t, []string{"Tin_in"}, titles(`{"o":"LIKE","f":"title","v":"Tin_in"}`))
+	assert.Equal(t, []string{"100% Tintin"}, titles(`{"o":"ILIKE","f":"title","v":"100% tintin"}`))
+	assert.Equal(t, []string{"Tin_in", "Tintin"}, titles(`{"o":"LIKE","f":"title","v":"Tin_in","m":"pattern"}`))
+	assert.Equal(t, []string{"100% Tintin", "1000 Tintin"}, titles(`{"o":"LIKE","f":"title","v":"100%","m":"pattern"}`))
+}
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+type NotFoundError struct {
+	Err error
+}
+
+// ConstraintError reports a violated database constraint, see Constraint.
+type ConstraintError struct {
+	Constraint Constraint
+	Err        error
+}
+
+// ConflictError reports a request that conflicts with the current state of
+// the record.
+type ConflictError struct {
+	Err error
+}
+
+// PreconditionFailedError reports an If-Match or version that no longer
+// matches the stored record.
+type PreconditionFailedError struct {
+	Err error
+}
+
+type PreconditionRequiredError struct {
+	Err error
+}
+
+// ForbiddenError refuses a request the client is not allowed to make, e.g. a
+// WebSocket handshake from another origin.
+type ForbiddenError struct {
+	Err error
+}
+
+type BadRequestError struct {
+	Err error
+}
+
+// NotAcceptableError reports an Accept header listing no format the
+// response is available in.
+type NotAcceptableError struct {
+	Err error
+}
+
+type ValidationError struct {
+	Message string       `json:"error"`
+	Errors  []FieldError `json:"errors"`
+}
+
+type FieldError struct {
+	Path    string `json:"path"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *NotFoundError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *NotFoundError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ConstraintError) Error() string {
+	switch e.Constraint.Kind {
+	case ConstraintUnique:
+		return fmt.Sprintf("Duplicate value %s", e.Constraint.target())
+	case ConstraintForeignKey:
+		return fmt.Sprintf("Invalid reference %s", e.Constraint.target())
+	case ConstraintNotNull:
+		return fmt.Sprintf("Missing value %s", e.Constraint.target())
+	}
+	return fmt.Sprintf("Check constraint failed %s", e.Constraint.target())
+}
+
+func (e *ConstraintError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ConflictError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ConflictError) Unwrap() error {
+	return e.Err
+}
+
+func (e *PreconditionFailedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PreconditionFailedError) Unwrap() error {
+	return e.Err
+}
+
+func (e *PreconditionRequiredError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PreconditionRequiredError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ForbiddenError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ForbiddenError) Unwrap() error {
+	return e.Err
+}
+
+func (e *BadRequestError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *BadRequestError) Unwrap() error {
+	return e.Err
+}
+
+func (e *NotAcceptableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *NotAcceptableError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ValidationError) Error() string {
+	msgs := []string{}
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fe.Path+": "+fe.Message)
+	}
+	return e.Message + " " + strings.Join(msgs, ", ")
+}
+
+func (e *ValidationError) add(path, field, format string, args ...any) {
+	e.Errors = append(e.Errors, FieldError{Path: path, Field: field, Message: fmt.Sprintf(format, args...)})
+}
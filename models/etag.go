@@ -0,0 +1,112 @@
+package models
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// isVersionField reports whether the field opts in to optimistic concurrency
+// through the gorm tag, e.g. `gorm:"version"`. Version fields must be
+// integers.
+func isVersionField(field *schema.Field) bool {
+	_, ok := field.TagSettings["VERSION"]
+	return ok && field.DBName != ""
+}
+
+func (r *Repository[T]) versionField() (*schema.Field, error) {
+	s, err := r.gormSchema()
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range s.Fields {
+		if isVersionField(field) {
+			return field, nil
+		}
+	}
+	return nil, nil
+}
+
+func bumpVersion(v reflect.Value) {
+	if v.CanInt() {
+		v.SetInt(v.Int() + 1)
+	} else if v.CanUint() {
+		v.SetUint(v.Uint() + 1)
+	}
+}
+
+// ETag returns the entity tag of data, its version for versioned models and
+// a hash of its json form otherwise.
+func (r *Repository[T]) ETag(data T) string {
+	if field, err := r.versionField(); err == nil && field != nil {
+		return fmt.Sprintf(`"%v"`, reflect.ValueOf(&data).Elem().FieldByIndex(field.StructField.Index).Interface())
+	}
+	bytes, err := json.Marshal(data)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf(`"%x"`, sha1.Sum(bytes))
+}
+
+// unchanged is the condition that the stored record still holds the values
+// of before, the ones its ETag hashes, for the models without a version
+// field. A write under it affects no row once a concurrent one changed the
+// record.
+func (r *Repository[T]) unchanged(ctx context.Context, before *T) (clause.Expression, error) {
+	s, err := r.gormSchema()
+	if err != nil {
+		return nil, err
+	}
+	exprs := []clause.Expression{}
+	for _, field := range s.Fields {
+		if field.DBName == "" || field.PrimaryKey {
+			continue
+		}
+		value, _ := field.ValueOf(ctx, reflect.ValueOf(before))
+		exprs = append(exprs, clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: value})
+	}
+	return clause.And(exprs...), nil
+}
+
+func (r *Repository[T]) checkMatch(data T, ifMatch string) error {
+	if ifMatch == "" {
+		if r.RequireIfMatch {
+			return &PreconditionRequiredError{Err: fmt.Errorf("If-Match is required")}
+		}
+		return nil
+	}
+	if !MatchETag(ifMatch, r.ETag(data), false) {
+		return &PreconditionFailedError{Err: fmt.Errorf("ETag mismatch")}
+	}
+	return nil
+}
+
+// MatchETag reports whether etag is listed in an If-Match or If-None-Match
+// header value. Weak comparison ignores the W/ prefix, as used by
+// If-None-Match, strong comparison never matches weak tags.
+func MatchETag(header string, etag string, weak bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		} else if strings.HasPrefix(tag, "W/") {
+			continue
+		}
+		if tag == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
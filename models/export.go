@@ -0,0 +1,187 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Content types of Export besides JSON, negotiated from the Accept header.
+const (
+	CSVContentType    = "text/csv"
+	NDJSONContentType = "application/x-ndjson"
+)
+
+// ExportChunk is the number of rows Export writes between flushes.
+var ExportChunk = 100
+
+// Each calls fn with the records of query one at a time, in the order of
+// query, without loading them all into memory. There is no row limit.
+func (r *Repository[T]) Each(ctx context.Context, query *Query, fn func(T) error) error {
+	if query == nil {
+		query = NewQuery(nil, nil, nil)
+	}
+	if err := r.Schema.ValidateQuery(query); err != nil {
+		return err
+	}
+
+	var model T
+	tx, err := r.scopeDeleted(r.tx(ctx).Model(&model), query)
+	if err != nil {
+		return err
+	}
+	where, params := query.Condition.ApplyDialect(r.db().SQLDialect(), "", []any{})
+	tx = tx.Where(where, params...)
+	r.orderBy(tx, query)
+	if query.Select != nil {
+		columns := []string{}
+		for _, name := range query.Select {
+			columns = append(columns, r.Schema.Column(name))
+		}
+		tx = tx.Select(columns)
+	}
+
+	rows, err := tx.Rows()
+	if err != nil {
+		return r.db().TranslateError(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data T
+		if err := tx.ScanRows(rows, &data); err != nil {
+			return r.db().TranslateError(err)
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return r.db().TranslateError(rows.Err())
+}
+
+// exporter writes the rows of an export in one of the negotiated formats.
+// The status and headers are only sent with the first row, or by end, so
+// that errors before it can still be reported as a problem.
+type exporter struct {
+	w       gin.ResponseWriter
+	format  string
+	columns []string
+	csv     *csv.Writer
+	begun   bool
+	rows    int
+}
+
+func (e *exporter) begin() error {
+	e.begun = true
+	switch e.format {
+	case CSVContentType:
+		e.w.Header().Set("Content-Type", CSVContentType+"; charset=utf-8")
+		e.w.WriteHeader(http.StatusOK)
+		e.csv = csv.NewWriter(e.w)
+		return e.csv.Write(e.columns)
+	case NDJSONContentType:
+		e.w.Header().Set("Content-Type", NDJSONContentType)
+		e.w.WriteHeader(http.StatusOK)
+		return nil
+	}
+	e.w.Header().Set("Content-Type", binding.MIMEJSON+"; charset=utf-8")
+	e.w.WriteHeader(http.StatusOK)
+	_, err := io.WriteString(e.w, "[")
+	return err
+}
+
+func (e *exporter) write(data any) error {
+	if !e.begun {
+		if err := e.begin(); err != nil {
+			return err
+		}
+	}
+	bb, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	switch e.format {
+	case CSVContentType:
+		var record []string
+		if record, err = csvRecord(bb, e.columns); err == nil {
+			err = e.csv.Write(record)
+		}
+	case NDJSONContentType:
+		_, err = e.w.Write(append(bb, '\n'))
+	default:
+		if e.rows > 0 {
+			_, err = io.WriteString(e.w, ",")
+		}
+		if err == nil {
+			_, err = e.w.Write(bb)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if e.rows++; e.rows%ExportChunk == 0 {
+		return e.flush()
+	}
+	return nil
+}
+
+func (e *exporter) end() error {
+	if !e.begun {
+		if err := e.begin(); err != nil {
+			return err
+		}
+	}
+	if e.format == binding.MIMEJSON {
+		if _, err := io.WriteString(e.w, "]"); err != nil {
+			return err
+		}
+	}
+	return e.flush()
+}
+
+func (e *exporter) flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return err
+		}
+	}
+	e.w.Flush()
+	return nil
+}
+
+// csvRecord returns the columns of the json object bb as CSV values, nested
+// values in their json form and missing ones empty.
+func csvRecord(bb []byte, columns []string) ([]string, error) {
+	values := map[string]any{}
+	dec := json.NewDecoder(bytes.NewReader(bb))
+	dec.UseNumber()
+	if err := dec.Decode(&values); err != nil {
+		return nil, err
+	}
+	record := make([]string, len(columns))
+	for i, column := range columns {
+		switch v := values[column].(type) {
+		case nil:
+		case string:
+			record[i] = v
+		case json.Number:
+			record[i] = v.String()
+		case bool:
+			record[i] = strconv.FormatBool(v)
+		default:
+			nested, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			record[i] = string(nested)
+		}
+	}
+	return record, nil
+}
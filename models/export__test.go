@@ -0,0 +1,54 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepository_Each(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	for _, book := range []Book{
+		{Title: "Tintin in Tibet", Author: "Herge"},
+		{Title: "Harry Potter and the Chamber of Secrets", Author: "J. K. Rowling"},
+		{Title: "Tintin in America", Author: "Herge"},
+	} {
+		_, err := repo.Create(ctx, book)
+		assert.NoError(t, err)
+	}
+
+	books := []Book{}
+	err := repo.Each(ctx, NewQuery(Fields("id", "title"), NewCondition().Like("title", "Tintin"), nil).SortBy(Asc("title")), func(book Book) error {
+		books = append(books, book)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Book{{ID: 3, Title: "Tintin in America"}, {ID: 1, Title: "Tintin in Tibet"}}, books)
+
+	stop := errors.New("stop")
+	count := 0
+	err = repo.Each(ctx, nil, func(book Book) error {
+		if count++; count == 2 {
+			return stop
+		}
+		return nil
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 2, count)
+
+	var validation *ValidationError
+	err = repo.Each(ctx, NewQuery(Fields("password"), nil, nil), func(book Book) error {
+		return nil
+	})
+	assert.True(t, errors.As(err, &validation), err)
+}
+
+func TestCSVRecord(t *testing.T) {
+	record, err := csvRecord([]byte(`{"id": 12345678901, "title": "Tintin, \"Tibet\"", "tags": ["a"], "ok": true}`),
+		[]string{"id", "title", "summary", "tags", "ok"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"12345678901", `Tintin, "Tibet"`, "", `["a"]`, "true"}, record)
+}
@@ -0,0 +1,456 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The filter language is the textual form of Condition:
+//
+//	filter     = or
+//	or         = and { "or" and }
+//	and        = unary { "and" unary }
+//	unary      = "not" unary | "(" or ")" | comparison
+//	comparison = field ( op value | "~" string | "~*" string | ["not"] "in" "(" value { "," value } ")"
+//	             | "between" value "and" value | "is" ["not"] "null" | ("like" | "ilike") string )
+//	op         = "=" | "!=" | "<" | "<=" | ">" | ">="
+//	value      = string | number | "true" | "false"
+//
+// Fields are Unicode letters, digits, "_" and ".", starting with a letter or
+// "_". Keywords are case insensitive, strings are double quoted with Go escapes.
+// "~" and "~*" match a substring with LIKE and ILIKE, "like" and "ilike" a
+// pattern with the % and _ wildcards.
+
+// FilterError reports a syntax error at byte offset Pos of the filter.
+type FilterError struct {
+	Pos     int
+	Message string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("INVALID FILTER AT %d: %s", e.Pos, e.Message)
+}
+
+type filterTokenKind int
+
+const (
+	tokenEOF filterTokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOp
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type filterToken struct {
+	kind  filterTokenKind
+	text  string
+	value any
+	pos   int
+}
+
+func (t filterToken) String() string {
+	if t.kind == tokenEOF {
+		return "end of filter"
+	}
+	return strconv.Quote(t.text)
+}
+
+// keyword reports whether the token is the keyword kw, ignoring case.
+func (t filterToken) keyword(kw string) bool {
+	return t.kind == tokenIdent && strings.EqualFold(t.text, kw)
+}
+
+func lexFilter(input string) ([]filterToken, error) {
+	tokens := []filterToken{}
+	for i := 0; i < len(input); {
+		c := input[i]
+		r, size := utf8.DecodeRuneInString(input[i:])
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, filterToken{kind: tokenLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, filterToken{kind: tokenRParen, text: ")", pos: i})
+			i++
+		case c == ',':
+			tokens = append(tokens, filterToken{kind: tokenComma, text: ",", pos: i})
+			i++
+		case strings.ContainsRune("=!<>~", rune(c)):
+			op := string(c)
+			if i+1 < len(input) && (input[i+1] == '=' && c != '=' && c != '~' || input[i+1] == '*' && c == '~') {
+				op = input[i : i+2]
+			}
+			if op == "!" {
+				return nil, &FilterError{Pos: i, Message: "unexpected character '!'"}
+			}
+			tokens = append(tokens, filterToken{kind: tokenOp, text: op, pos: i})
+			i += len(op)
+		case c == '"':
+			j := i + 1
+			for ; j < len(input) && input[j] != '"'; j++ {
+				if input[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(input) {
+				return nil, &FilterError{Pos: i, Message: "unterminated string"}
+			}
+			s, err := strconv.Unquote(input[i : j+1])
+			if err != nil {
+				return nil, &FilterError{Pos: i, Message: "invalid string " + input[i:j+1]}
+			}
+			tokens = append(tokens, filterToken{kind: tokenString, text: input[i : j+1], value: s, pos: i})
+			i = j + 1
+		case c == '-' || c >= '0' && c <= '9':
+			j := i + 1
+			for ; j < len(input) && strings.ContainsRune("0123456789.eE+-", rune(input[j])); j++ {
+				if (input[j] == '+' || input[j] == '-') && input[j-1] != 'e' && input[j-1] != 'E' {
+					break
+				}
+			}
+			f, err := strconv.ParseFloat(input[i:j], 64)
+			if err != nil {
+				return nil, &FilterError{Pos: i, Message: "invalid number " + input[i:j]}
+			}
+			tokens = append(tokens, filterToken{kind: tokenNumber, text: input[i:j], value: f, pos: i})
+			i = j
+		case c == '_' || unicode.IsLetter(r):
+			j := i + size
+			for j < len(input) {
+				r, size := utf8.DecodeRuneInString(input[j:])
+				if r != '_' && r != '.' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				j += size
+			}
+			tokens = append(tokens, filterToken{kind: tokenIdent, text: input[i:j], pos: i})
+			i = j
+		default:
+			return nil, &FilterError{Pos: i, Message: fmt.Sprintf("unexpected character %q", r)}
+		}
+	}
+	return append(tokens, filterToken{kind: tokenEOF, pos: len(input)}), nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *filterParser) errorf(t filterToken, format string, args ...any) error {
+	return &FilterError{Pos: t.pos, Message: fmt.Sprintf(format, args...) + ", found " + t.String()}
+}
+
+// ParseFilter parses the textual filter language into a Condition, an empty
+// filter gives an empty Condition.
+func ParseFilter(input string) (*Condition, error) {
+	tokens, err := lexFilter(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	if p.peek().kind == tokenEOF {
+		return NewCondition(), nil
+	}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.errorf(t, "expected and, or")
+	}
+	return rootCondition(e), nil
+}
+
+// The parsers of the textual query syntaxes build their Condition through
+// these helpers, so all of them give the tree of the Condition builder.
+
+// rootCondition returns the AND condition of a parsed expression.
+func rootCondition(e any) *Condition {
+	root := NewCondition()
+	if c, ok := e.(Condition); ok && c.op == "AND" {
+		root.entries = c.entries
+	} else {
+		root.entries = []any{e}
+	}
+	return root
+}
+
+// listCondition combines the operands of an AND or OR, a single operand is
+// returned as is.
+func listCondition(op string, entries []any) any {
+	if len(entries) == 1 {
+		return entries[0]
+	}
+	return Condition{op: op, entries: entries}
+}
+
+func notCondition(e any) Condition {
+	if c, ok := e.(Condition); ok && c.op == "AND" {
+		return Condition{op: "NOT", entries: []any{c}}
+	}
+	return Condition{op: "NOT", entries: []any{Condition{op: "AND", entries: []any{e}}}}
+}
+
+func (p *filterParser) parseOr() (any, error) {
+	return p.parseList("OR", p.parseAnd)
+}
+
+func (p *filterParser) parseAnd() (any, error) {
+	return p.parseList("AND", p.parseUnary)
+}
+
+func (p *filterParser) parseList(op string, parse func() (any, error)) (any, error) {
+	e, err := parse()
+	if err != nil {
+		return nil, err
+	}
+	entries := []any{e}
+	for p.peek().keyword(op) {
+		p.next()
+		if e, err = parse(); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return listCondition(op, entries), nil
+}
+
+func (p *filterParser) parseUnary() (any, error) {
+	t := p.peek()
+	switch {
+	case t.keyword("not"):
+		p.next()
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notCondition(e), nil
+	case t.kind == tokenLParen:
+		p.next()
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenRParen {
+			return nil, p.errorf(t, "expected )")
+		}
+		return e, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (any, error) {
+	field := p.next()
+	if field.kind != tokenIdent || isFilterKeyword(field.text) {
+		return nil, p.errorf(field, "expected field")
+	}
+	op := findQueryOp{field: field.text}
+	t := p.next()
+	switch {
+	case t.kind == tokenOp && (t.text == "~" || t.text == "~*"):
+		s, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		op.op, op.value, op.mode = "LIKE", s, MatchContains
+		if t.text == "~*" {
+			op.op = "ILIKE"
+		}
+	case t.kind == tokenOp:
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		op.op, op.value = t.text, v
+	case t.keyword("like"), t.keyword("ilike"):
+		s, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		op.op, op.value, op.mode = strings.ToUpper(t.text), s, MatchPattern
+	case t.keyword("in"):
+		vs, err := p.parseValues()
+		if err != nil {
+			return nil, err
+		}
+		op.op, op.value = "IN", vs
+	case t.keyword("not"):
+		if t := p.next(); !t.keyword("in") {
+			return nil, p.errorf(t, "expected in")
+		}
+		vs, err := p.parseValues()
+		if err != nil {
+			return nil, err
+		}
+		op.op, op.value = "NOT IN", vs
+	case t.keyword("between"):
+		low, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); !t.keyword("and") {
+			return nil, p.errorf(t, "expected and")
+		}
+		high, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		op.op, op.value = "BETWEEN", []any{low, high}
+	case t.keyword("is"):
+		op.op = "IS NULL"
+		if p.peek().keyword("not") {
+			p.next()
+			op.op = "IS NOT NULL"
+		}
+		if t := p.next(); !t.keyword("null") {
+			return nil, p.errorf(t, "expected null")
+		}
+	default:
+		return nil, p.errorf(t, "expected operator")
+	}
+	return op, nil
+}
+
+func (p *filterParser) parseString() (string, error) {
+	t := p.next()
+	if t.kind != tokenString {
+		return "", p.errorf(t, "expected string")
+	}
+	return t.value.(string), nil
+}
+
+func (p *filterParser) parseValue() (any, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokenString, t.kind == tokenNumber:
+		return t.value, nil
+	case t.keyword("true"):
+		return true, nil
+	case t.keyword("false"):
+		return false, nil
+	}
+	return nil, p.errorf(t, "expected value")
+}
+
+// parseValues parses the parenthesized value list of in and not in.
+func (p *filterParser) parseValues() ([]any, error) {
+	if t := p.next(); t.kind != tokenLParen {
+		return nil, p.errorf(t, "expected (")
+	}
+	values := []any{}
+	for {
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		t := p.next()
+		if t.kind == tokenRParen {
+			return values, nil
+		} else if t.kind != tokenComma {
+			return nil, p.errorf(t, "expected , or )")
+		}
+	}
+}
+
+func isFilterKeyword(s string) bool {
+	switch strings.ToLower(s) {
+	case "and", "or", "not", "in", "between", "is", "null", "like", "ilike", "true", "false":
+		return true
+	}
+	return false
+}
+
+// String returns the condition in the filter language, ParseFilter of the
+// result gives back the condition.
+func (q *Condition) String() string {
+	if len(q.entries) == 1 {
+		if c, ok := q.entries[0].(Condition); ok && c.op == "OR" {
+			return c.join()
+		}
+	}
+	return q.join()
+}
+
+func (q *Condition) join() string {
+	parts := []string{}
+	for _, e := range q.entries {
+		parts = append(parts, filterTerm(e))
+	}
+	return strings.Join(parts, " "+strings.ToLower(q.op)+" ")
+}
+
+func filterTerm(e any) string {
+	switch et := e.(type) {
+	case findQueryOp:
+		return et.String()
+	case Condition:
+		if et.op != "NOT" {
+			return "(" + et.join() + ")"
+		}
+		if len(et.entries) == 1 {
+			if c, ok := et.entries[0].(Condition); ok && c.op == "AND" && len(c.entries) == 1 {
+				return "not " + filterTerm(c.entries[0])
+			}
+			return "not " + filterTerm(et.entries[0])
+		}
+	}
+	return fmt.Sprintf("%v", e)
+}
+
+func (q findQueryOp) String() string {
+	switch q.op {
+	case "IS NULL", "IS NOT NULL":
+		return q.field + " " + strings.ToLower(q.op)
+	case "LIKE", "ILIKE":
+		if s, _ := q.value.(string); q.mode == MatchContains {
+			op := "~"
+			if q.op == "ILIKE" {
+				op = "~*"
+			}
+			return q.field + " " + op + " " + strconv.Quote(s)
+		}
+		return q.field + " " + strings.ToLower(q.op) + " " + strconv.Quote(q.pattern())
+	case "BETWEEN":
+		vs := q.value.([]any)
+		return q.field + " between " + filterValue(vs[0]) + " and " + filterValue(vs[1])
+	case "IN", "NOT IN":
+		values := []string{}
+		for _, v := range q.value.([]any) {
+			values = append(values, filterValue(v))
+		}
+		return q.field + " " + strings.ToLower(q.op) + " (" + strings.Join(values, ", ") + ")"
+	}
+	return q.field + " " + q.op + " " + filterValue(q.value)
+}
+
+func filterValue(v any) string {
+	switch vt := v.(type) {
+	case string:
+		return strconv.Quote(vt)
+	case float64:
+		return strconv.FormatFloat(vt, 'g', -1, 64)
+	}
+	return fmt.Sprintf("%v", v)
+}
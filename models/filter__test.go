@@ -0,0 +1,93 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	test_lib "github.com/senomas/go-api/test/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter_Parse(t *testing.T) {
+	condition, err := ParseFilter(`title ~ "potter" and not author = "Lord Voldermort"`)
+	assert.NoError(t, err)
+
+	expected := NewCondition().Like("title", "potter").Not(NewCondition().Equal("author", "Lord Voldermort"))
+	assert.Equal(t, test_lib.Marshal(t, expected), test_lib.Marshal(t, condition))
+
+	where, params := condition.Apply("", []any{})
+	assert.Equal(t, "title LIKE ? AND NOT (author = ?)", where)
+	assert.Equal(t, test_lib.Marshal(t, []any{"%potter%", "Lord Voldermort"}), test_lib.Marshal(t, params))
+}
+
+func TestFilter_Precedence(t *testing.T) {
+	condition, err := ParseFilter(`id = 1 OR id = 2 AND NOT (title like "%a%" or summary is null)`)
+	assert.NoError(t, err)
+
+	where, params := condition.Apply("", []any{})
+	assert.Equal(t, "(id = ? OR (id = ? AND NOT ((title LIKE ? OR summary IS NULL))))", where)
+	assert.Equal(t, test_lib.Marshal(t, []any{1, 2, "%a%"}), test_lib.Marshal(t, params))
+}
+
+func TestFilter_RoundTrip(t *testing.T) {
+	for _, filter := range []string{
+		``,
+		`title = "Tintin in Tibet"`,
+		`id != 1 and id < 2.5 and id <= 3 and id > -4 and id >= 1e+21`,
+		`title ~ "potter" and title ~* "Potter" and title like "Harry%" and title ilike "%stone"`,
+		`id in (1, 4) and id not in (2) and id between 1 and 9`,
+		`summary is null or summary is not null`,
+		`published = true and not (id = 1 or id = 2)`,
+		`not id = 1 and (title = "a" or title = "b\"c")`,
+		`(id = 1 and id = 2) or id = 3`,
+		`tïtle_ß2 = "a"`,
+	} {
+		condition, err := ParseFilter(filter)
+		if !assert.NoError(t, err, filter) {
+			continue
+		}
+		assert.Equal(t, filter, condition.String())
+
+		// the JSON form gives back the same condition
+		bytes, err := json.Marshal(condition)
+		assert.NoError(t, err)
+		value := NewCondition()
+		assert.NoError(t, json.Unmarshal(bytes, value), string(bytes))
+		assert.Equal(t, filter, value.String(), string(bytes))
+	}
+}
+
+func TestFilter_String(t *testing.T) {
+	condition := NewCondition().Like("title", "Tintin").In("id", 1, 4).
+		Or(NewCondition().IsNull("summary").Equal("author", "Herge"))
+	assert.Equal(t, `title ~ "Tintin" and id in (1, 4) and (summary is null or author = "Herge")`, condition.String())
+}
+
+func TestFilter_Errors(t *testing.T) {
+	for filter, expected := range map[string]FilterError{
+		`title = `:                {Pos: 8, Message: "expected value, found end of filter"},
+		`title == "a"`:            {Pos: 7, Message: `expected value, found "="`},
+		`title ! "a"`:             {Pos: 6, Message: "unexpected character '!'"},
+		`title = "a`:              {Pos: 8, Message: "unterminated string"},
+		`title = "a" and`:         {Pos: 15, Message: "expected field, found end of filter"},
+		`(title = "a"`:            {Pos: 12, Message: "expected ), found end of filter"},
+		`title = "a" author`:      {Pos: 12, Message: `expected and, or, found "author"`},
+		`id in (1 2)`:             {Pos: 9, Message: `expected , or ), found "2"`},
+		`id between 1 or 2`:       {Pos: 13, Message: `expected and, found "or"`},
+		`summary is nothing`:      {Pos: 11, Message: `expected null, found "nothing"`},
+		`title ~ 1`:               {Pos: 8, Message: `expected string, found "1"`},
+		`and = 1`:                 {Pos: 0, Message: `expected field, found "and"`},
+		`title = "a" and id # 1`:  {Pos: 19, Message: `unexpected character '#'`},
+		`id not between 1 and 2`:  {Pos: 7, Message: `expected in, found "between"`},
+		`id -- 1`:                 {Pos: 3, Message: "invalid number -"},
+		`title startswith "Harr"`: {Pos: 6, Message: `expected operator, found "startswith"`},
+		`title ≠ "a"`:             {Pos: 6, Message: `unexpected character '≠'`},
+	} {
+		_, err := ParseFilter(filter)
+		var fe *FilterError
+		if assert.True(t, errors.As(err, &fe), filter) {
+			assert.Equal(t, expected, *fe, filter)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+// QueryFrontend compiles the request parameters of one query syntax into the
+// Query and PageOptions of Finds, which then go through the same validation
+// and SQL generation as the JSON query.
+type QueryFrontend interface {
+	Name() string
+	Parse(schema *Schema, params url.Values, query *Query, opts *PageOptions) error
+}
+
+// DefaultQueryFrontends are used by repositories without Frontends.
+var DefaultQueryFrontends = []QueryFrontend{FilterFrontend{}, ODataFrontend{}}
+
+var queryFrontends sync.Map
+
+func init() {
+	RegisterQueryFrontend(FilterFrontend{})
+	RegisterQueryFrontend(ODataFrontend{})
+	RegisterQueryFrontend(RSQLFrontend{})
+}
+
+// RegisterQueryFrontend makes f selectable with the syntax parameter.
+func RegisterQueryFrontend(f QueryFrontend) {
+	queryFrontends.Store(f.Name(), f)
+}
+
+func QueryFrontendOf(name string) (QueryFrontend, bool) {
+	if f, ok := queryFrontends.Load(name); ok {
+		return f.(QueryFrontend), true
+	}
+	return nil, false
+}
+
+// parseFrontends applies the frontend named by the syntax parameter, or the
+// frontends of the repository.
+func (r *Repository[T]) parseFrontends(params url.Values, query *Query, opts *PageOptions) error {
+	frontends := r.Frontends
+	if frontends == nil {
+		frontends = DefaultQueryFrontends
+	}
+	if name := params.Get("syntax"); name != "" {
+		f, ok := QueryFrontendOf(name)
+		if !ok {
+			return &BadRequestError{Err: fmt.Errorf("Unknown syntax %s", name)}
+		}
+		frontends = []QueryFrontend{f}
+	}
+	for _, f := range frontends {
+		if err := f.Parse(r.Schema, params, query, opts); err != nil {
+			return requestError(err)
+		}
+	}
+	return nil
+}
+
+// FilterFrontend reads the filter parameter in the filter language, see
+// ParseFilter.
+type FilterFrontend struct{}
+
+func (FilterFrontend) Name() string {
+	return "filter"
+}
+
+func (FilterFrontend) Parse(schema *Schema, params url.Values, query *Query, opts *PageOptions) error {
+	if str := params.Get("filter"); str != "" {
+		filter, err := ParseFilter(str)
+		if err != nil {
+			return err
+		}
+		query.Condition.and(filter)
+	}
+	return nil
+}
+
+func paramInt(params url.Values, name string, value *int) error {
+	if str := params.Get(name); str != "" {
+		i, err := strconv.Atoi(str)
+		if err != nil || i < 0 {
+			return &BadRequestError{Err: fmt.Errorf("Invalid %s %s", name, str)}
+		}
+		*value = i
+	}
+	return nil
+}
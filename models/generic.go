@@ -2,115 +2,635 @@ package models
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm/clause"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
 )
 
-func (db *DatabaseModel) Finds(c *gin.Context, model interface{}, data interface{}) {
-	tx := db.DB.Model(model)
-	var query Query
+// Finds pages with offset and limit, or with keyset cursors when the cursor
+// parameter is given, empty for the first page. The count parameter selects
+// an exact, estimate or no count. The includeDeleted and onlyDeleted
+// parameters set the options of the query. The query frontends of the
+// repository, or the one named by the syntax parameter, add their parameters
+// to the query.
+func Finds[T any](c *gin.Context, repo *Repository[T]) {
+	query := repo.Schema.NewQuery()
 	if c.Request.Method == "POST" {
-		if err := c.ShouldBindJSON(&query); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		if err := c.ShouldBindJSON(query); err != nil {
+			repo.ErrorJSON(c, requestError(err))
 			return
 		}
 	} else if str := c.Query("query"); str != "" {
-		if err := json.Unmarshal([]byte(str), &query); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		if err := json.Unmarshal([]byte(str), query); err != nil {
+			repo.ErrorJSON(c, requestError(err))
 			return
 		}
 	}
-	where, params := query.Condition.Apply("", []any{})
-	tx.Where(where, params...)
-	if query.OrderBy.Field != "" {
-		tx.Order(clause.OrderByColumn{Column: clause.Column{Name: query.OrderBy.Field}, Desc: query.OrderBy.Desc})
+
+	if err := deletedParams(c, query); err != nil {
+		repo.ErrorJSON(c, err)
+		return
 	}
 
-	var count int64
-	if err := tx.Count(&count).Error; err != nil {
-		c.JSON(http.StatusBadRequest, db.ErrorMap(err))
+	opts := PageOptions{Limit: 1000}
+	if err := pageParams(c, &opts); err != nil {
+		repo.ErrorJSON(c, err)
+		return
+	}
+	opts.Cursor, opts.Keyset = c.GetQuery("cursor")
+	switch mode := CountMode(c.Query("count")); mode {
+	case "", CountExact, CountEstimate, CountNone:
+		opts.Count = mode
+	default:
+		repo.ErrorJSON(c, &BadRequestError{Err: fmt.Errorf("Count error: unknown mode %q", mode)})
+		return
+	}
+	if err := repo.parseFrontends(c.Request.URL.Query(), query, &opts); err != nil {
+		repo.ErrorJSON(c, err)
 		return
 	}
 
-	if query.Select != nil {
-		tx = tx.Select(query.Select)
+	page, err := repo.Paginate(c.Request.Context(), query, opts)
+	if err != nil {
+		repo.ErrorJSON(c, err)
+		return
 	}
 
+	c.JSON(http.StatusOK, page)
+}
+
+// deletedParams reads the includeDeleted and onlyDeleted parameters into
+// query.
+func deletedParams(c *gin.Context, query *Query) error {
+	for _, p := range []struct {
+		name string
+		flag *bool
+	}{{"includeDeleted", &query.IncludeDeleted}, {"onlyDeleted", &query.OnlyDeleted}} {
+		if str := c.Query(p.name); str != "" {
+			b, err := strconv.ParseBool(str)
+			if err != nil {
+				return &BadRequestError{Err: fmt.Errorf("%s error: %w", p.name, err)}
+			}
+			*p.flag = b
+		}
+	}
+	return nil
+}
+
+func pageParams(c *gin.Context, opts *PageOptions) error {
 	if str := c.Query("offset"); str != "" {
 		if i, err := strconv.Atoi(str); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"Offset error": err.Error()})
+			return &BadRequestError{Err: fmt.Errorf("Offset error: %w", err)}
 		} else {
-			tx = tx.Offset(i)
+			opts.Offset = i
 		}
 	}
 	if str := c.Query("limit"); str != "" {
 		if i, err := strconv.Atoi(str); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"Limit error": err.Error()})
+			return &BadRequestError{Err: fmt.Errorf("Limit error: %w", err)}
 		} else {
-			tx = tx.Limit(i)
+			opts.Limit = i
+		}
+	}
+	return nil
+}
+
+// Search responds with the page of records matching the text of the q
+// parameter, the most relevant first, see Repository.Search. The query
+// frontends narrow the results as in Finds.
+func Search[T any](c *gin.Context, repo *Repository[T]) {
+	query := repo.Schema.NewQuery()
+	opts := PageOptions{Limit: 1000}
+	if err := pageParams(c, &opts); err != nil {
+		repo.ErrorJSON(c, err)
+		return
+	}
+	if err := repo.parseFrontends(c.Request.URL.Query(), query, &opts); err != nil {
+		repo.ErrorJSON(c, err)
+		return
+	}
+
+	page, err := repo.Search(c.Request.Context(), c.Query("q"), query, opts)
+	if err != nil {
+		repo.ErrorJSON(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// Export streams the records of the query in the query parameter, narrowed
+// by the query frontends, as a JSON array, NDJSON or CSV as negotiated from
+// the Accept header. Unlike Finds there is no row limit, the rows are read
+// one at a time and flushed every ExportChunk rows. CSV has a header row of
+// the selected fields, all fields when there is no select.
+func Export[T any](c *gin.Context, repo *Repository[T]) {
+	query := repo.Schema.NewQuery()
+	if str := c.Query("query"); str != "" {
+		if err := json.Unmarshal([]byte(str), query); err != nil {
+			repo.ErrorJSON(c, requestError(err))
+			return
 		}
+	}
+	if err := deletedParams(c, query); err != nil {
+		repo.ErrorJSON(c, err)
+		return
+	}
+	if err := repo.parseFrontends(c.Request.URL.Query(), query, &PageOptions{}); err != nil {
+		repo.ErrorJSON(c, err)
+		return
+	}
+	format := c.NegotiateFormat(binding.MIMEJSON, NDJSONContentType, CSVContentType)
+	if format == "" {
+		repo.ErrorJSON(c, &NotAcceptableError{Err: fmt.Errorf("Export is available as %s, %s or %s", binding.MIMEJSON, NDJSONContentType, CSVContentType)})
+		return
+	}
+	columns := query.Select
+	if columns == nil {
+		columns = repo.Schema.Names
+	}
+
+	e := &exporter{w: c.Writer, format: format, columns: columns}
+	err := repo.Each(c.Request.Context(), query, func(data T) error {
+		return e.write(data)
+	})
+	if err == nil {
+		err = e.end()
+	}
+	if err != nil && !e.begun {
+		repo.ErrorJSON(c, err)
+	} else if err != nil {
+		// the response is already under way, the client sees it truncated
+		c.Error(err)
+	}
+}
+
+// Stream serves the live query of the query parameter, narrowed by the query
+// frontends, see Repository.Watch. It is Server-Sent Events, or WebSocket
+// messages when the request upgrades. The Last-Event-ID header, or the
+// lastEventId parameter for the WebSocket clients that can not set it,
+// resumes a stream. The select of the query is ignored, the records are sent
+// whole.
+func Stream[T any](c *gin.Context, repo *Repository[T]) {
+	query := repo.Schema.NewQuery()
+	if str := c.Query("query"); str != "" {
+		if err := json.Unmarshal([]byte(str), query); err != nil {
+			repo.ErrorJSON(c, requestError(err))
+			return
+		}
+	}
+	if err := deletedParams(c, query); err != nil {
+		repo.ErrorJSON(c, err)
+		return
+	}
+	if err := repo.parseFrontends(c.Request.URL.Query(), query, &PageOptions{}); err != nil {
+		repo.ErrorJSON(c, err)
+		return
+	}
+	query.Select = nil
+	var lastEventID uint
+	str := c.GetHeader("Last-Event-ID")
+	if str == "" {
+		str = c.Query("lastEventId")
+	}
+	if str != "" {
+		if id, err := strconv.ParseUint(str, 10, 0); err != nil {
+			repo.ErrorJSON(c, &BadRequestError{Err: fmt.Errorf("Last-Event-ID error: %w", err)})
+			return
+		} else {
+			lastEventID = uint(id)
+		}
+	}
+
+	if isWebSocket(c.Request) {
+		streamWebSocket(c, repo, query, lastEventID)
 	} else {
-		tx = tx.Limit(1000)
+		streamEvents(c, repo, query, lastEventID)
+	}
+}
+
+// Import creates records from a CSV or NDJSON body, or from the file field
+// of a multipart form, binding each row into C with its binding rules and
+// converting it with create. Each map parameter, "header=field", renames a
+// CSV header or NDJSON key to a json field of C. Batches of the batch
+// parameter rows are committed in one transaction each, dry_run only reports.
+// Responds with the ImportReport, 207 with its Error when a fatal error
+// stopped the import after some batches were committed.
+func Import[T any, C any](c *gin.Context, repo *Repository[T], create func(input C) T) {
+	dryRun := false
+	if str := c.Query("dry_run"); str != "" {
+		if b, err := strconv.ParseBool(str); err != nil {
+			repo.ErrorJSON(c, &BadRequestError{Err: fmt.Errorf("Dry run error: %w", err)})
+			return
+		} else {
+			dryRun = b
+		}
+	}
+	batchSize := 0
+	if str := c.Query("batch"); str != "" {
+		if i, err := strconv.Atoi(str); err != nil {
+			repo.ErrorJSON(c, &BadRequestError{Err: fmt.Errorf("Batch error: %w", err)})
+			return
+		} else {
+			batchSize = i
+		}
+	}
+	mapping := map[string]string{}
+	for _, str := range c.QueryArray("map") {
+		header, field, ok := strings.Cut(str, "=")
+		if !ok {
+			repo.ErrorJSON(c, &BadRequestError{Err: fmt.Errorf("Map error: expected header=field, got %q", str)})
+			return
+		}
+		mapping[header] = field
+	}
+
+	var src io.Reader = c.Request.Body
+	format := importFormat(c.ContentType(), "")
+	if c.ContentType() == gin.MIMEMultipartPOSTForm {
+		fh, err := c.FormFile("file")
+		if err != nil {
+			repo.ErrorJSON(c, &BadRequestError{Err: err})
+			return
+		}
+		f, err := fh.Open()
+		if err != nil {
+			repo.ErrorJSON(c, &BadRequestError{Err: err})
+			return
+		}
+		defer f.Close()
+		src, format = f, importFormat(fh.Header.Get("Content-Type"), fh.Filename)
+	}
+	var input C
+	rows, err := newImportReader(format, src, mapping, modelType(&input))
+	if err != nil {
+		repo.ErrorJSON(c, err)
+		return
+	}
+	importer, err := repo.NewImporter(dryRun, batchSize)
+	if err != nil {
+		repo.ErrorJSON(c, err)
+		return
+	}
+
+	fail := func(err error) {
+		if importer.DryRun || importer.Report.Imported == 0 {
+			repo.ErrorJSON(c, err)
+			return
+		}
+		// the committed batches stay, the report tells which rows they hold
+		importer.Report.Error = repo.db().ErrorMap(err)
+		c.JSON(http.StatusMultiStatus, gin.H{"data": importer.Report})
+	}
+
+	ctx := c.Request.Context()
+	for {
+		row, body, err := rows.next()
+		var badRequest *BadRequestError
+		if err == io.EOF {
+			break
+		} else if err != nil && !errors.As(err, &badRequest) {
+			fail(&BadRequestError{Err: err})
+			return
+		}
+		var data T
+		if err == nil {
+			var input C
+			if err = BindJSONBody(body, &input); err == nil {
+				data = create(input)
+			}
+		}
+		if err := importer.Add(ctx, row, data, err); err != nil {
+			fail(err)
+			return
+		}
+	}
+	if err := importer.Flush(ctx); err != nil {
+		fail(err)
+		return
 	}
 
-	if err := tx.Find(data).Error; err != nil {
-		c.JSON(http.StatusBadRequest, DB.ErrorMap(err))
+	c.JSON(http.StatusOK, gin.H{"data": importer.Report})
+}
+
+// Aggregate responds with the rows of the aggregate query in the body, see
+// Repository.Aggregate.
+func Aggregate[T any](c *gin.Context, repo *Repository[T]) {
+	query := repo.Schema.NewQuery()
+	if err := c.ShouldBindJSON(query); err != nil {
+		repo.ErrorJSON(c, requestError(err))
+		return
+	}
+	opts := PageOptions{Limit: 1000}
+	if err := pageParams(c, &opts); err != nil {
+		repo.ErrorJSON(c, err)
+		return
+	}
+
+	rows, err := repo.Aggregate(c.Request.Context(), query, opts.Limit)
+	if err != nil {
+		repo.ErrorJSON(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"count": count, "data": data})
+	c.JSON(http.StatusOK, gin.H{"data": rows})
 }
 
-func (db *DatabaseModel) Find(c *gin.Context, data interface{}) {
-	if err := db.DB.Where("id = ?", c.Param("id")).First(&data).Error; err != nil {
-		c.JSON(http.StatusBadRequest, db.ErrorMap(err))
+// Bulk runs the operations of a BulkRequest body and responds with their
+// results, 200 when all of them succeeded and 207 otherwise. create decodes
+// the data of create and upsert operations, update applies the data of an
+// update operation to the stored record.
+func Bulk[T any](c *gin.Context, repo *Repository[T], create func(data []byte) (T, error), update func(data []byte, record *T) error) {
+	var req BulkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		repo.ErrorJSON(c, requestError(err))
+		return
+	}
+	ops := make([]BulkOperation[T], len(req.Operations))
+	for i, item := range req.Operations {
+		op := BulkOperation[T]{Op: item.Op, ID: item.ID, IfMatch: item.IfMatch}
+		switch item.Op {
+		case BulkCreate, BulkUpsert:
+			op.Data, op.Err = create(item.Data)
+		case BulkUpdate:
+			data := item.Data
+			op.Apply = func(record *T) error {
+				return update(data, record)
+			}
+		}
+		ops[i] = op
+	}
+
+	results, err := repo.Bulk(c.Request.Context(), req.Mode, ops)
+	if err != nil {
+		repo.ErrorJSON(c, err)
 		return
 	}
 
+	status := http.StatusOK
+	for _, result := range results {
+		if result.Error != nil {
+			status = http.StatusMultiStatus
+		}
+	}
+	c.JSON(status, gin.H{"data": results})
+}
+
+// Find responds 304 without body when If-None-Match lists the ETag of the
+// record. The includeDeleted parameter also finds a soft deleted record.
+func Find[T any](c *gin.Context, repo *Repository[T]) {
+	query := &Query{}
+	if err := deletedParams(c, query); err != nil {
+		repo.ErrorJSON(c, err)
+		return
+	}
+	find := repo.Find
+	if query.IncludeDeleted || query.OnlyDeleted {
+		find = repo.FindUnscoped
+	}
+	data, err := find(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		repo.ErrorJSON(c, err)
+		return
+	}
+
+	etag := repo.ETag(data)
+	c.Header("ETag", etag)
+	if match := c.GetHeader("If-None-Match"); match != "" && MatchETag(match, etag, true) {
+		c.Status(http.StatusNotModified)
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"data": data})
 }
 
-func (db *DatabaseModel) Create(c *gin.Context, data interface{}) {
-	if err := db.DB.Create(data).Error; err != nil {
-		c.JSON(http.StatusBadRequest, db.ErrorMap(err))
+// Create responds 201 with a Location header pointing at the new record.
+func Create[T any](c *gin.Context, repo *Repository[T], data T) {
+	data, err := repo.Create(c.Request.Context(), data)
+	if err != nil {
+		repo.ErrorJSON(c, err)
+		return
+	}
+
+	if id, err := repo.PrimaryKey(c.Request.Context(), data); err == nil {
+		c.Header("Location", fmt.Sprintf("%s/%v", strings.TrimSuffix(c.Request.URL.Path, "/"), id))
+	}
+	c.Header("ETag", repo.ETag(data))
+	c.JSON(http.StatusCreated, gin.H{"data": data})
+}
+
+// Delete responds 204 without body when the client sends
+// "Prefer: return=minimal". If-Match is checked against the stored record.
+func Delete[T any](c *gin.Context, repo *Repository[T]) {
+	if _, err := repo.Delete(c.Request.Context(), c.Param("id"), c.GetHeader("If-Match")); err != nil {
+		repo.ErrorJSON(c, err)
+		return
+	}
+
+	if strings.Contains(c.GetHeader("Prefer"), "return=minimal") {
+		c.Status(http.StatusNoContent)
 		return
 	}
+	c.JSON(http.StatusOK, gin.H{"data": true})
+}
 
+// Restore undeletes the soft deleted record identified by :id. If-Match is
+// checked against the stored record.
+func Restore[T any](c *gin.Context, repo *Repository[T]) {
+	data, err := repo.Restore(c.Request.Context(), c.Param("id"), c.GetHeader("If-Match"))
+	if err != nil {
+		repo.ErrorJSON(c, err)
+		return
+	}
+
+	c.Header("ETag", repo.ETag(data))
 	c.JSON(http.StatusOK, gin.H{"data": data})
 }
 
-func (db *DatabaseModel) Delete(c *gin.Context, model interface{}) {
-	if tx := db.DB.Where("id = ?", c.Param("id")).First(model); tx.Error != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": tx.Error.Error()})
+// Purge permanently removes the soft deleted records, only the ones deleted
+// before the RFC 3339 time of the before parameter when given, and responds
+// with their number.
+func Purge[T any](c *gin.Context, repo *Repository[T]) {
+	var before time.Time
+	if str := c.Query("before"); str != "" {
+		t, err := time.Parse(time.RFC3339, str)
+		if err != nil {
+			repo.ErrorJSON(c, &BadRequestError{Err: fmt.Errorf("Before error: %w", err)})
+			return
+		}
+		before = t
+	}
+
+	purged, err := repo.Purge(c.Request.Context(), before)
+	if err != nil {
+		repo.ErrorJSON(c, err)
 		return
-	} else if tx.RowsAffected != 1 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid RowsAffected %v", tx.RowsAffected)})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"purged": purged}})
+}
+
+// History responds with the page of revisions of the record identified by
+// :id, the latest first, see Repository.History.
+func History[T any](c *gin.Context, repo *Repository[T]) {
+	opts := PageOptions{Limit: 1000}
+	if err := pageParams(c, &opts); err != nil {
+		repo.ErrorJSON(c, err)
 		return
 	}
 
-	if err := db.DB.Delete(model).Error; err != nil {
-		c.JSON(http.StatusBadRequest, db.ErrorMap(err))
+	page, err := repo.History(c.Request.Context(), c.Param("id"), opts.Offset, opts.Limit)
+	if err != nil {
+		repo.ErrorJSON(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": true})
+	c.JSON(http.StatusOK, page)
+}
+
+// FindRevision responds with revision :rev of the record identified by :id.
+func FindRevision[T any](c *gin.Context, repo *Repository[T]) {
+	rev, err := revParam(c)
+	if err != nil {
+		repo.ErrorJSON(c, err)
+		return
+	}
+
+	revision, err := repo.FindRevision(c.Request.Context(), c.Param("id"), rev)
+	if err != nil {
+		repo.ErrorJSON(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": revision})
 }
 
-func (db *DatabaseModel) Update(c *gin.Context, data interface{}, applyInput func()) {
-	if err := db.DB.Where("id = ?", c.Param("id")).First(data).Error; err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// Revert updates the record identified by :id back to revision :rev and
+// responds as Update. If-Match is checked against the stored record.
+func Revert[T any](c *gin.Context, repo *Repository[T]) {
+	rev, err := revParam(c)
+	if err != nil {
+		repo.ErrorJSON(c, err)
 		return
 	}
-	applyInput()
 
-	if err := db.DB.Updates(data).Error; err != nil {
-		c.JSON(http.StatusBadRequest, db.ErrorMap(err))
+	data, err := repo.Revert(c.Request.Context(), c.Param("id"), rev, c.GetHeader("If-Match"))
+	if err != nil {
+		repo.ErrorJSON(c, err)
 		return
 	}
 
+	c.Header("ETag", repo.ETag(data))
 	c.JSON(http.StatusOK, gin.H{"data": data})
 }
+
+func revParam(c *gin.Context) (int, error) {
+	rev, err := strconv.Atoi(c.Param("rev"))
+	if err != nil {
+		return 0, &BadRequestError{Err: fmt.Errorf("Rev error: %w", err)}
+	}
+	return rev, nil
+}
+
+// Update applies the request body to the record identified by :id. Merge
+// patch (RFC 7396) and JSON patch (RFC 6902) bodies are applied to the JSON
+// form of the record, any other body is handed to bind. If-Match is checked
+// against the stored record.
+func Update[T any](c *gin.Context, repo *Repository[T], bind func(*T) error) {
+	contentType := c.ContentType()
+	var body []byte
+	if contentType == MergePatchContentType || contentType == JSONPatchContentType {
+		if bb, err := io.ReadAll(c.Request.Body); err != nil {
+			repo.ErrorJSON(c, &BadRequestError{Err: err})
+			return
+		} else {
+			body = bb
+		}
+	}
+
+	data, err := repo.Update(c.Request.Context(), c.Param("id"), c.GetHeader("If-Match"), func(data *T) error {
+		if body == nil {
+			return bind(data)
+		}
+		doc, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		if contentType == MergePatchContentType {
+			doc, err = MergePatch(doc, body)
+		} else {
+			doc, err = JSONPatch(doc, body)
+		}
+		if err != nil {
+			return err
+		}
+		return applyJSON(data, doc)
+	})
+	if err != nil {
+		repo.ErrorJSON(c, err)
+		return
+	}
+
+	c.Header("ETag", repo.ETag(data))
+	c.JSON(http.StatusOK, gin.H{"data": data})
+}
+
+// BindJSON binds the request body into input, reporting binding rule
+// failures as a ValidationError keyed by the json field names.
+func BindJSON(c *gin.Context, input any) error {
+	return bindError(input, c.ShouldBindJSON(input))
+}
+
+// BindJSONBody is BindJSON for a body already read, such as the data of a
+// bulk operation.
+func BindJSONBody(body []byte, input any) error {
+	return bindError(input, binding.JSON.BindBody(body, input))
+}
+
+func bindError(input any, err error) error {
+	if err == nil {
+		return nil
+	}
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return &BadRequestError{Err: err}
+	}
+	ve := &ValidationError{Message: "INVALID INPUT"}
+	t := modelType(input)
+	for _, fe := range verrs {
+		name := fe.Field()
+		if sf, ok := t.FieldByName(fe.StructField()); ok {
+			if tag := strings.Split(sf.Tag.Get("json"), ",")[0]; tag != "" && tag != "-" {
+				name = tag
+			}
+		}
+		ve.add(name, name, "%s", strings.TrimSpace(strings.ToUpper(fe.Tag())+" "+fe.Param()))
+	}
+	return ve
+}
+
+// ErrorJSON renders err as an application/problem+json body.
+func (db *DatabaseModel) ErrorJSON(c *gin.Context, err error) {
+	p := db.ErrorMap(err)
+	c.Header("Content-Type", ProblemContentType)
+	c.JSON(p.Status, p)
+}
+
+func (r *Repository[T]) ErrorJSON(c *gin.Context, err error) {
+	r.db().ErrorJSON(c, err)
+}
+
+func requestError(err error) error {
+	var ve *ValidationError
+	if errors.As(err, &ve) {
+		return err
+	}
+	return &BadRequestError{Err: err}
+}
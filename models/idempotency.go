@@ -0,0 +1,249 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Headers of an idempotent request: the key chosen by the client and the
+// marker of a replayed response.
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+const idempotencyKeyMaxLength = 255
+
+// IdempotencyRecord holds the response to a request with an Idempotency-Key,
+// per actor. Status is 0 while the request is in progress, until ExpiresAt
+// when its process died. Fingerprint is the hash of the method, path and
+// body of the request.
+type IdempotencyRecord struct {
+	ID             uint   `gorm:"primary_key"`
+	Actor          string `gorm:"uniqueIndex:idx_idempotency_records_key,priority:1"`
+	IdempotencyKey string `gorm:"uniqueIndex:idx_idempotency_records_key,priority:2"`
+	Fingerprint    string
+	Status         int
+	Header         JSONText
+	Body           []byte
+	CreatedAt      time.Time
+	ExpiresAt      time.Time
+}
+
+// MigrateIdempotency creates the idempotency_records table.
+func MigrateIdempotency(db *gorm.DB) error {
+	return db.AutoMigrate(&IdempotencyRecord{})
+}
+
+// Idempotency replays the response to a request with an Idempotency-Key
+// header when the client retries it within TTL, see Handler. A request in
+// progress holds its key with a lock it extends every half LockTimeout, a
+// lock not extended for LockTimeout is the one of a dead process and the
+// request is executed again.
+type Idempotency struct {
+	DB          *DatabaseModel
+	TTL         time.Duration
+	LockTimeout time.Duration
+	now         clock
+}
+
+func NewIdempotency(db *DatabaseModel) *Idempotency {
+	return &Idempotency{DB: db, TTL: 24 * time.Hour, LockTimeout: time.Minute}
+}
+
+// Handler is the middleware of the routes it makes idempotent. A request
+// with an Idempotency-Key runs once per key and actor: a retry with the same
+// method, path and body gets the stored response with Idempotent-Replayed,
+// a different request with the key is refused with 422 and a retry while
+// the first one is still running with 409. Server errors are not stored, the
+// request can be retried with the same key. Requests without the header pass
+// through.
+func (i *Idempotency) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		db := i.DB.orDefault()
+		if len(key) > idempotencyKeyMaxLength {
+			db.ErrorJSON(c, &BadRequestError{Err: fmt.Errorf("%s is longer than %d", IdempotencyKeyHeader, idempotencyKeyMaxLength)})
+			c.Abort()
+			return
+		}
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			db.ErrorJSON(c, &BadRequestError{Err: err})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256([]byte(c.Request.Method + " " + c.Request.URL.Path + "\n" + string(body)))
+		actor := ""
+		if info, ok := c.Request.Context().Value(auditKey{}).(auditInfo); ok {
+			actor = info.actor
+		}
+
+		record, err := i.begin(c.Request.Context(), actor, key, hex.EncodeToString(sum[:]))
+		if err != nil {
+			db.ErrorJSON(c, err)
+			c.Abort()
+			return
+		}
+		if record.Status != 0 {
+			i.replay(c, record)
+			c.Abort()
+			return
+		}
+
+		w := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		hold, release := context.WithCancel(context.Background())
+		go i.hold(hold, record)
+		stored := false
+		defer func() {
+			if !stored {
+				// a failed request leaves the key free for the retry
+				db.DB.WithContext(context.Background()).Delete(record)
+			}
+		}()
+		c.Next()
+		release()
+
+		if w.Status() >= http.StatusInternalServerError {
+			return
+		}
+		header := http.Header{}
+		for name, values := range w.Header() {
+			if name != RequestIDHeader {
+				header[name] = values
+			}
+		}
+		snapshot, err := json.Marshal(header)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		res := db.DB.WithContext(context.Background()).Model(record).Where("status = 0").Updates(map[string]any{
+			"status": w.Status(), "header": JSONText(snapshot), "body": w.body.Bytes(), "expires_at": i.now.Now().Add(i.TTL),
+		})
+		if res.Error != nil {
+			c.Error(res.Error)
+			return
+		} else if res.RowsAffected == 0 {
+			c.Error(fmt.Errorf("Request with %s %s lost its lock", IdempotencyKeyHeader, record.IdempotencyKey))
+			return
+		}
+		stored = true
+	}
+}
+
+// begin claims key for a new request, or returns the record holding it when
+// it has a response to replay. The unique index settles concurrent claims.
+func (i *Idempotency) begin(ctx context.Context, actor string, key string, fingerprint string) (*IdempotencyRecord, error) {
+	db := i.DB.orDefault()
+	now := i.now.Now()
+	// an expired response, or the lock of a dead request, frees the key
+	if err := db.DB.WithContext(ctx).Where("actor = ? AND idempotency_key = ? AND expires_at < ?", actor, key, now).
+		Delete(&IdempotencyRecord{}).Error; err != nil {
+		return nil, db.TranslateError(err)
+	}
+	record := &IdempotencyRecord{Actor: actor, IdempotencyKey: key, Fingerprint: fingerprint, ExpiresAt: now.Add(i.LockTimeout)}
+	res := db.DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+	if res.Error != nil {
+		return nil, db.TranslateError(res.Error)
+	}
+	if res.RowsAffected == 1 {
+		return record, nil
+	}
+
+	record = &IdempotencyRecord{}
+	if err := db.DB.WithContext(ctx).Where("actor = ? AND idempotency_key = ?", actor, key).First(record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// the request holding the key failed meanwhile
+			return nil, &ConflictError{Err: fmt.Errorf("Request with %s %s is in progress", IdempotencyKeyHeader, key)}
+		}
+		return nil, db.TranslateError(err)
+	}
+	if record.Fingerprint != fingerprint {
+		ve := &ValidationError{Message: "INVALID INPUT"}
+		ve.add(IdempotencyKeyHeader, "", "KEY REUSED WITH A DIFFERENT REQUEST")
+		return nil, ve
+	}
+	if record.Status == 0 {
+		return nil, &ConflictError{Err: fmt.Errorf("Request with %s %s is in progress", IdempotencyKeyHeader, key)}
+	}
+	return record, nil
+}
+
+// hold extends the lock of record every half LockTimeout until ctx is done.
+func (i *Idempotency) hold(ctx context.Context, record *IdempotencyRecord) {
+	if i.LockTimeout <= 0 {
+		return
+	}
+	db := i.DB.orDefault()
+	ticker := time.NewTicker(i.LockTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := db.DB.WithContext(ctx).Model(&IdempotencyRecord{}).Where("id = ? AND status = 0", record.ID).
+			Update("expires_at", i.now.Now().Add(i.LockTimeout)).Error; err != nil && ctx.Err() == nil {
+			log.Printf("Idempotency lock error: %v\n", err)
+		}
+	}
+}
+
+// replay writes the stored response of record.
+func (i *Idempotency) replay(c *gin.Context, record *IdempotencyRecord) {
+	header := http.Header{}
+	if len(record.Header) > 0 {
+		if err := json.Unmarshal(record.Header, &header); err != nil {
+			i.DB.orDefault().ErrorJSON(c, err)
+			return
+		}
+	}
+	for name, values := range header {
+		c.Writer.Header()[name] = values
+	}
+	c.Header(IdempotentReplayedHeader, "true")
+	c.Writer.WriteHeader(record.Status)
+	c.Writer.Write(record.Body)
+}
+
+// Prune removes the expired records and returns their number.
+func (i *Idempotency) Prune(ctx context.Context) (int64, error) {
+	return i.DB.orDefault().prune(ctx, &IdempotencyRecord{}, "expires_at < ?", i.now.Now())
+}
+
+// recordingWriter keeps a copy of the body written to the response.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
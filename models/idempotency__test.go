@@ -0,0 +1,168 @@
+package models
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotency(t *testing.T) {
+	repo := newTestRepository(t)
+	if err := MigrateIdempotency(repo.DB.DB); err != nil {
+		t.Fatal("MigrateIdempotency Error", err)
+	}
+	var mu sync.Mutex
+	now := time.Now()
+	idempotency := NewIdempotency(repo.DB)
+	idempotency.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	advance := func(d time.Duration) {
+		mu.Lock()
+		now = now.Add(d)
+		mu.Unlock()
+	}
+
+	calls := 0
+	status := http.StatusCreated
+	var block chan struct{}
+	started := make(chan struct{}, 1)
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	engine.Use(AuditContext(nil))
+	engine.PUT("/books", idempotency.Handler(), func(c *gin.Context) {
+		mu.Lock()
+		calls++
+		n, wait := calls, block
+		mu.Unlock()
+		if wait != nil {
+			started <- struct{}{}
+			<-wait
+		}
+		body, _ := io.ReadAll(c.Request.Body)
+		c.Header("Location", "/books/1")
+		c.JSON(status, gin.H{"call": n, "body": string(body)})
+	})
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	put := func(key string, actor string, body string) (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodPut, server.URL+"/books", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		if actor != "" {
+			req.Header.Set(ActorHeader, actor)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("Http Error", err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp, string(b)
+	}
+
+	resp, body := put("key-1", "", `{"title":"Tintin"}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, `{"body":"{\"title\":\"Tintin\"}","call":1}`, body)
+	assert.Empty(t, resp.Header.Get(IdempotentReplayedHeader))
+
+	resp, replayed := put("key-1", "", `{"title":"Tintin"}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, body, replayed)
+	assert.Equal(t, "true", resp.Header.Get(IdempotentReplayedHeader))
+	assert.Equal(t, "/books/1", resp.Header.Get("Location"))
+	assert.Equal(t, "application/json; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.NotEmpty(t, resp.Header.Get(RequestIDHeader))
+
+	resp, body = put("key-1", "", `{"title":"Asterix"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Contains(t, body, "KEY REUSED WITH A DIFFERENT REQUEST")
+
+	// keys are per actor, requests without one are not tracked
+	resp, body = put("key-1", "herge", `{"title":"Asterix"}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Contains(t, body, `"call":2`)
+	_, body = put("", "", `{"title":"Tintin"}`)
+	assert.Contains(t, body, `"call":3`)
+	_, body = put("", "", `{"title":"Tintin"}`)
+	assert.Contains(t, body, `"call":4`)
+	resp, _ = put(strings.Repeat("k", 256), "", `{}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// server errors are not stored
+	status = http.StatusServiceUnavailable
+	resp, _ = put("key-2", "", `{}`)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	status = http.StatusCreated
+	resp, body = put("key-2", "", `{}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Contains(t, body, `"call":6`)
+
+	// a concurrent duplicate is refused while the first one runs
+	mu.Lock()
+	block = make(chan struct{})
+	mu.Unlock()
+	done := make(chan string)
+	go func() {
+		_, body := put("key-3", "", `{}`)
+		done <- body
+	}()
+	<-started
+	resp, body = put("key-3", "", `{}`)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Contains(t, body, "Request with Idempotency-Key key-3 is in progress")
+	mu.Lock()
+	close(block)
+	block = nil
+	mu.Unlock()
+	first := <-done
+	assert.Contains(t, first, `"call":7`)
+	_, body = put("key-3", "", `{}`)
+	assert.Equal(t, first, body)
+
+	// a request running longer than LockTimeout keeps its key
+	idempotency.LockTimeout = 100 * time.Millisecond
+	mu.Lock()
+	block = make(chan struct{})
+	mu.Unlock()
+	go func() {
+		_, body := put("key-4", "", `{}`)
+		done <- body
+	}()
+	<-started
+	advance(80 * time.Millisecond)
+	time.Sleep(150 * time.Millisecond)
+	advance(80 * time.Millisecond)
+	mu.Lock()
+	running := block
+	block = nil
+	mu.Unlock()
+	resp, body = put("key-4", "", `{}`)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Contains(t, body, "Request with Idempotency-Key key-4 is in progress")
+	close(running)
+	first = <-done
+	assert.Contains(t, first, `"call":8`)
+	_, body = put("key-4", "", `{}`)
+	assert.Equal(t, first, body)
+
+	// the responses expire after TTL
+	advance(25 * time.Hour)
+	_, body = put("key-1", "", `{"title":"Asterix"}`)
+	assert.Contains(t, body, `"call":9`)
+	pruned, err := idempotency.Prune(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), pruned)
+}
@@ -0,0 +1,343 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm/clause"
+)
+
+// ImportReport is the outcome of an import. Imported counts the records
+// created, or the ones that would be created in a dry run. Error is the
+// fatal error that stopped the import after some records were committed,
+// the rows after it were not read.
+type ImportReport struct {
+	DryRun   bool          `json:"dryRun,omitempty"`
+	Rows     int           `json:"rows"`
+	Imported int           `json:"imported"`
+	Errors   []ImportError `json:"errors"`
+	Error    *Problem      `json:"error,omitempty"`
+}
+
+// ImportError reports a row that was not imported. Row is the line number
+// in the file, the CSV header being line 1. DuplicateOf is the row an
+// earlier record with the same unique key came from, 0 when the key is
+// already in the database.
+type ImportError struct {
+	Row         int      `json:"row"`
+	DuplicateOf int      `json:"duplicateOf,omitempty"`
+	Error       *Problem `json:"error"`
+}
+
+type importRow[T any] struct {
+	row  int
+	data T
+	err  error
+}
+
+// Importer creates records in batches, one transaction each, skipping rows
+// that failed to decode or that duplicate the upsert key of T, in the
+// database or earlier in the import. A dry run only reports.
+type Importer[T any] struct {
+	Repository *Repository[T]
+	DryRun     bool
+	BatchSize  int
+	Report     ImportReport
+
+	keys  []string
+	seen  map[string]int
+	batch []importRow[T]
+}
+
+func (r *Repository[T]) NewImporter(dryRun bool, batchSize int) (*Importer[T], error) {
+	keys, err := r.upsertKey()
+	if err != nil {
+		return nil, err
+	}
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	return &Importer[T]{Repository: r, DryRun: dryRun, BatchSize: batchSize, Report: ImportReport{DryRun: dryRun, Errors: []ImportError{}}, keys: keys, seen: map[string]int{}}, nil
+}
+
+// Add queues the record of row, or its decoding error, and writes the batch
+// when it is full. The error returned is fatal for the import.
+func (im *Importer[T]) Add(ctx context.Context, row int, data T, err error) error {
+	im.Report.Rows++
+	im.batch = append(im.batch, importRow[T]{row: row, data: data, err: err})
+	if len(im.batch) >= im.BatchSize {
+		return im.Flush(ctx)
+	}
+	return nil
+}
+
+// Flush writes the queued records.
+func (im *Importer[T]) Flush(ctx context.Context) error {
+	r := im.Repository
+	batch := im.batch
+	im.batch = nil
+	if len(batch) == 0 {
+		return nil
+	}
+
+	values := [][]any{}
+	for _, row := range batch {
+		if row.err == nil {
+			values = append(values, r.keyValues(ctx, &row.data, im.keys))
+		}
+	}
+	existing, err := r.existingKeys(ctx, im.keys, values)
+	if err != nil {
+		return err
+	}
+
+	rows := []importRow[T]{}
+	ops := []BulkOperation[T]{}
+	for _, row := range batch {
+		if row.err != nil {
+			im.Report.Errors = append(im.Report.Errors, ImportError{Row: row.row, Error: r.db().ErrorMap(row.err)})
+			continue
+		}
+		key := importKey(r.keyValues(ctx, &row.data, im.keys))
+		if first, ok := im.seen[key]; ok || existing[key] {
+			im.Report.Errors = append(im.Report.Errors, ImportError{Row: row.row, DuplicateOf: first, Error: r.db().ErrorMap(r.duplicateError(im.keys))})
+			continue
+		}
+		im.seen[key] = row.row
+		rows = append(rows, row)
+		ops = append(ops, BulkOperation[T]{Op: BulkCreate, Data: row.data})
+	}
+	if im.DryRun || len(ops) == 0 {
+		im.Report.Imported += len(ops)
+		return nil
+	}
+
+	results, err := r.Bulk(ctx, BulkAtomic, ops)
+	if err != nil {
+		return err
+	}
+	for i, result := range results {
+		if result.Error != nil {
+			im.Report.Errors = append(im.Report.Errors, ImportError{Row: rows[i].row, Error: result.Error})
+		} else {
+			im.Report.Imported++
+		}
+	}
+	return nil
+}
+
+func (r *Repository[T]) keyValues(ctx context.Context, data *T, keys []string) []any {
+	s, _ := r.gormSchema()
+	values := []any{}
+	for _, key := range keys {
+		var value any
+		if field := s.LookUpField(key); field != nil {
+			value, _ = field.ValueOf(ctx, reflect.ValueOf(data))
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+func importKey(values []any) string {
+	parts := []string{}
+	for _, v := range values {
+		parts = append(parts, fmt.Sprint(v))
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// existingKeys returns the keys among values that are already stored.
+func (r *Repository[T]) existingKeys(ctx context.Context, keys []string, values [][]any) (map[string]bool, error) {
+	found := map[string]bool{}
+	if len(values) == 0 {
+		return found, nil
+	}
+	var model T
+	tx := r.tx(ctx).Model(&model).Select(keys)
+	if len(keys) == 1 {
+		in := clause.IN{Column: clause.Column{Name: keys[0]}}
+		for _, v := range values {
+			in.Values = append(in.Values, v[0])
+		}
+		tx = tx.Where(in)
+	} else {
+		or := []clause.Expression{}
+		for _, v := range values {
+			and := []clause.Expression{}
+			for i, key := range keys {
+				and = append(and, clause.Eq{Column: clause.Column{Name: key}, Value: v[i]})
+			}
+			or = append(or, clause.And(and...))
+		}
+		tx = tx.Where(clause.Or(or...))
+	}
+	rows := []map[string]any{}
+	if err := tx.Find(&rows).Error; err != nil {
+		return nil, r.db().TranslateError(err)
+	}
+	for _, row := range rows {
+		v := []any{}
+		for _, key := range keys {
+			v = append(v, row[key])
+		}
+		found[importKey(v)] = true
+	}
+	return found, nil
+}
+
+func (r *Repository[T]) duplicateError(keys []string) error {
+	table := ""
+	if s, err := r.gormSchema(); err == nil {
+		table = s.Table
+	}
+	c := lookupConstraint(Constraint{Kind: ConstraintUnique, Table: table, Column: keys[0]})
+	return &ConstraintError{Constraint: c, Err: errors.New("duplicate key")}
+}
+
+// importReader yields the rows of an import file as json objects, keyed by
+// the mapped field names, with the line number they start at.
+type importReader interface {
+	next() (int, []byte, error)
+}
+
+// newImportReader reads src in format, CSVContentType or NDJSONContentType.
+// mapping renames CSV headers or NDJSON keys to the json fields of input,
+// whose types decide whether CSV values are decoded as strings or as json.
+func newImportReader(format string, src io.Reader, mapping map[string]string, input reflect.Type) (importReader, error) {
+	switch format {
+	case CSVContentType:
+		r := csv.NewReader(src)
+		r.FieldsPerRecord = -1
+		r.TrimLeadingSpace = true
+		header, err := r.Read()
+		if err == io.EOF {
+			return nil, &BadRequestError{Err: errors.New("Missing CSV header")}
+		} else if err != nil {
+			return nil, &BadRequestError{Err: err}
+		}
+		for i, name := range header {
+			name = strings.TrimSpace(name)
+			if mapped, ok := mapping[name]; ok {
+				name = mapped
+			}
+			header[i] = name
+		}
+		return &csvImportReader{r: r, header: header, strings: stringFields(input)}, nil
+	case NDJSONContentType:
+		s := bufio.NewScanner(src)
+		s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		return &ndjsonImportReader{s: s, mapping: mapping}, nil
+	}
+	return nil, &BadRequestError{Err: fmt.Errorf("Import is available from %s or %s", CSVContentType, NDJSONContentType)}
+}
+
+// stringFields returns the json names of the string fields of t.
+func stringFields(t reflect.Type) map[string]bool {
+	fields := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := strings.Split(sf.Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = sf.Name
+		}
+		if sf.Type.Kind() == reflect.String {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+type csvImportReader struct {
+	r       *csv.Reader
+	header  []string
+	strings map[string]bool
+}
+
+// next leaves out empty values, so that they fail required rules. Values of
+// non string fields are taken as json when valid.
+func (cr *csvImportReader) next() (int, []byte, error) {
+	record, err := cr.r.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return parseErr.StartLine, nil, &BadRequestError{Err: err}
+	} else if err != nil {
+		return 0, nil, err
+	}
+	line, _ := cr.r.FieldPos(0)
+	obj := map[string]any{}
+	for i, value := range record {
+		if i >= len(cr.header) || cr.header[i] == "" || value == "" {
+			continue
+		}
+		if !cr.strings[cr.header[i]] && json.Valid([]byte(value)) {
+			obj[cr.header[i]] = json.RawMessage(value)
+		} else {
+			obj[cr.header[i]] = value
+		}
+	}
+	bb, err := json.Marshal(obj)
+	return line, bb, err
+}
+
+type ndjsonImportReader struct {
+	s       *bufio.Scanner
+	mapping map[string]string
+	line    int
+}
+
+// next skips blank lines.
+func (nr *ndjsonImportReader) next() (int, []byte, error) {
+	for nr.s.Scan() {
+		nr.line++
+		bb := bytes.TrimSpace(nr.s.Bytes())
+		if len(bb) == 0 {
+			continue
+		}
+		if len(nr.mapping) == 0 {
+			return nr.line, append([]byte{}, bb...), nil
+		}
+		obj := map[string]json.RawMessage{}
+		if err := json.Unmarshal(bb, &obj); err != nil {
+			return nr.line, nil, &BadRequestError{Err: err}
+		}
+		mapped := map[string]json.RawMessage{}
+		for k, v := range obj {
+			if name, ok := nr.mapping[k]; ok {
+				k = name
+			}
+			mapped[k] = v
+		}
+		bb, err := json.Marshal(mapped)
+		return nr.line, bb, err
+	}
+	if err := nr.s.Err(); err != nil {
+		return 0, nil, err
+	}
+	return 0, nil, io.EOF
+}
+
+// importFormat returns the import format of a content type or file name.
+func importFormat(contentType string, filename string) string {
+	switch strings.TrimSpace(strings.Split(contentType, ";")[0]) {
+	case CSVContentType:
+		return CSVContentType
+	case NDJSONContentType:
+		return NDJSONContentType
+	}
+	switch {
+	case strings.HasSuffix(filename, ".csv"):
+		return CSVContentType
+	case strings.HasSuffix(filename, ".ndjson"), strings.HasSuffix(filename, ".jsonl"):
+		return NDJSONContentType
+	}
+	return contentType
+}
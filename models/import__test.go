@@ -0,0 +1,150 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportReader(t *testing.T) {
+	type input struct {
+		Title string `json:"title"`
+		Pages int    `json:"pages"`
+	}
+	csv := "Book Title,pages,note\n" +
+		"Tintin in Tibet,62,\"first, \"\"best\"\"\"\n" +
+		"\n" +
+		"Tintin in America,sixty,\n" +
+		",,\n"
+	rows, err := newImportReader(CSVContentType, strings.NewReader(csv), map[string]string{"Book Title": "title"}, reflect.TypeOf(input{}))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		`2 {"note":"first, \"best\"","pages":62,"title":"Tintin in Tibet"}`,
+		`4 {"pages":"sixty","title":"Tintin in America"}`,
+		`5 {}`,
+	}, readImport(t, rows))
+
+	ndjson := `{"name": "Tintin in Tibet", "pages": 62}` + "\n\n" + `{"name": "Tintin in America"}` + "\n"
+	rows, err = newImportReader(NDJSONContentType, strings.NewReader(ndjson), map[string]string{"name": "title"}, reflect.TypeOf(input{}))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		`1 {"pages":62,"title":"Tintin in Tibet"}`,
+		`3 {"title":"Tintin in America"}`,
+	}, readImport(t, rows))
+
+	_, err = newImportReader(CSVContentType, strings.NewReader(""), nil, reflect.TypeOf(input{}))
+	assert.EqualError(t, err, "Missing CSV header")
+	_, err = newImportReader("application/xml", strings.NewReader(""), nil, reflect.TypeOf(input{}))
+	assert.EqualError(t, err, "Import is available from text/csv or application/x-ndjson")
+
+	assert.Equal(t, CSVContentType, importFormat("text/csv; charset=utf-8", ""))
+	assert.Equal(t, NDJSONContentType, importFormat("application/octet-stream", "books.jsonl"))
+	assert.Equal(t, CSVContentType, importFormat("", "books.csv"))
+}
+
+func readImport(t *testing.T, rows importReader) []string {
+	lines := []string{}
+	for {
+		row, body, err := rows.next()
+		if err == io.EOF {
+			return lines
+		}
+		assert.NoError(t, err)
+		lines = append(lines, fmt.Sprintf("%d %s", row, body))
+	}
+}
+
+func TestImporter(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	_, err := repo.Create(ctx, Book{Title: "Tintin in Tibet", Author: "Herge"})
+	assert.NoError(t, err)
+
+	rows := []importRow[Book]{
+		{row: 2, data: Book{Title: "Tintin in America", Author: "Herge"}},
+		{row: 3, data: Book{Title: "Tintin in Tibet", Author: "Herge"}},
+		{row: 4, err: &ValidationError{Message: "INVALID INPUT", Errors: []FieldError{{Path: "author", Field: "author", Message: "REQUIRED"}}}},
+		{row: 5, data: Book{Title: "Tintin in Congo", Author: "Herge"}},
+		{row: 6, data: Book{Title: "Tintin in America", Author: "Hergé"}},
+	}
+	add := func(im *Importer[Book]) {
+		for _, row := range rows {
+			assert.NoError(t, im.Add(ctx, row.row, row.data, row.err))
+		}
+		assert.NoError(t, im.Flush(ctx))
+	}
+
+	im, err := repo.NewImporter(true, 2)
+	assert.NoError(t, err)
+	add(im)
+	assert.Equal(t, 5, im.Report.Rows)
+	assert.Equal(t, 2, im.Report.Imported)
+	assert.Equal(t, []int{3, 4, 6}, importErrorRows(im.Report))
+	assert.Equal(t, 0, im.Report.Errors[0].DuplicateOf)
+	assert.Equal(t, "unique_violation", im.Report.Errors[0].Error.Code)
+	assert.Equal(t, 422, im.Report.Errors[1].Error.Status)
+	assert.Equal(t, 2, im.Report.Errors[2].DuplicateOf)
+	page, err := repo.Finds(ctx, nil, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), *page.Count)
+
+	im, err = repo.NewImporter(false, 2)
+	assert.NoError(t, err)
+	add(im)
+	assert.Equal(t, 2, im.Report.Imported)
+	assert.Equal(t, []int{3, 4, 6}, importErrorRows(im.Report))
+	page, err = repo.Finds(ctx, NewQuery(nil, nil, nil).SortBy(Asc("id")), 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Tintin in Tibet", "Tintin in America", "Tintin in Congo"}, []string{page.Data[0].Title, page.Data[1].Title, page.Data[2].Title})
+}
+
+func TestImport_FatalError(t *testing.T) {
+	repo := newTestRepository(t)
+	gin.SetMode(gin.ReleaseMode)
+	body := io.MultiReader(
+		strings.NewReader(`{"title": "Tintin in Tibet", "author": "Herge"}`+"\n"+`{"title": "Tintin in Congo", "author": "Herge"}`+"\n"),
+		iotest.ErrReader(errors.New("connection reset")),
+	)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/books/import?batch=1", body)
+	c.Request.Header.Set("Content-Type", NDJSONContentType)
+	Import(c, repo, func(input Book) Book { return input })
+
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.JSONEq(t, `{"data":{"rows":2,"imported":2,"errors":[],"error":{
+		"type":"about:blank","title":"Bad Request","status":400,"detail":"connection reset"}}}`, w.Body.String())
+	page, err := repo.Finds(context.Background(), nil, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), *page.Count)
+
+	// nothing committed yet, the error alone
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/books/import", io.MultiReader(
+		strings.NewReader(`{"title": "The Blue Lotus", "author": "Herge"}`+"\n"),
+		iotest.ErrReader(errors.New("connection reset")),
+	))
+	c.Request.Header.Set("Content-Type", NDJSONContentType)
+	Import(c, repo, func(input Book) Book { return input })
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"type":"about:blank","title":"Bad Request","status":400,"detail":"connection reset"}`, w.Body.String())
+}
+
+func importErrorRows(report ImportReport) []int {
+	rows := []int{}
+	for _, e := range report.Errors {
+		rows = append(rows, e.Row)
+	}
+	return rows
+}
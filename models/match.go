@@ -0,0 +1,286 @@
+package models
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// truth is the three valued logic of SQL, a comparison with a null field is
+// unknown and NOT unknown stays unknown.
+type truth int
+
+const (
+	truthFalse truth = iota
+	truthUnknown
+	truthTrue
+)
+
+func truthOf(b bool) truth {
+	if b {
+		return truthTrue
+	}
+	return truthFalse
+}
+
+// Match evaluates the condition against v, a struct or a map keyed by the
+// json field names, with the semantics of the SQL rendering: a row matches
+// when the condition is true, not when it is unknown because of null fields.
+// LIKE is case sensitive and ILIKE folds case, numbers compare as float64 and
+// strings bytewise.
+func (q *Condition) Match(v any) (bool, error) {
+	t, err := q.match(reflect.ValueOf(v))
+	return t == truthTrue, err
+}
+
+func (q *Condition) match(rv reflect.Value) (truth, error) {
+	result := truthTrue
+	if q.op == "OR" && len(q.entries) > 0 {
+		result = truthFalse
+	}
+	for _, e := range q.entries {
+		var t truth
+		var err error
+		switch et := e.(type) {
+		case findQueryOp:
+			t, err = et.match(rv)
+		case Condition:
+			t, err = et.match(rv)
+		default:
+			err = fmt.Errorf("UNSUPPORTED EXPRESSION %#v", e)
+		}
+		if err != nil {
+			return truthUnknown, err
+		}
+		if q.op == "OR" && t > result || q.op != "OR" && t < result {
+			result = t
+		}
+	}
+	if q.op == "NOT" {
+		return truthTrue - result, nil
+	}
+	return result, nil
+}
+
+func (q *findQueryOp) match(rv reflect.Value) (truth, error) {
+	fv, err := matchField(rv, q.field)
+	if err != nil {
+		return truthUnknown, err
+	}
+	switch q.op {
+	case "IS NULL":
+		return truthOf(fv == nil), nil
+	case "IS NOT NULL":
+		return truthOf(fv != nil), nil
+	}
+	if fv == nil {
+		return truthUnknown, nil
+	}
+	switch q.op {
+	case "LIKE", "ILIKE":
+		s, ok := fv.(string)
+		if !ok {
+			return truthUnknown, fmt.Errorf("UNSUPPORTED TYPE VALUE %v FOR %v %v", fv, q.field, q.op)
+		}
+		return truthOf(likeRegexp(q.pattern(), q.op == "ILIKE").MatchString(s)), nil
+	case "BETWEEN":
+		vs := q.value.([]any)
+		low, err := matchCompare(fv, vs[0])
+		if err != nil {
+			return truthUnknown, err
+		}
+		high, err := matchCompare(fv, vs[1])
+		if err != nil {
+			return truthUnknown, err
+		}
+		return truthOf(low >= 0 && high <= 0), nil
+	case "IN", "NOT IN":
+		found := false
+		for _, v := range q.value.([]any) {
+			c, err := matchCompare(fv, v)
+			if err != nil {
+				return truthUnknown, err
+			}
+			found = found || c == 0
+		}
+		return truthOf(found == (q.op == "IN")), nil
+	}
+	c, err := matchCompare(fv, q.value)
+	if err != nil {
+		return truthUnknown, err
+	}
+	switch q.op {
+	case "=":
+		return truthOf(c == 0), nil
+	case "!=":
+		return truthOf(c != 0), nil
+	case "<":
+		return truthOf(c < 0), nil
+	case "<=":
+		return truthOf(c <= 0), nil
+	case ">":
+		return truthOf(c > 0), nil
+	case ">=":
+		return truthOf(c >= 0), nil
+	}
+	return truthUnknown, fmt.Errorf("UNSUPPORTED EXPRESSION %v", q.op)
+}
+
+// matchField returns the value of the json field name of rv as a string,
+// float64 or bool, nil when it is null.
+func matchField(rv reflect.Value, name string) (any, error) {
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, fmt.Errorf("UNKNOWN FIELD %s", name)
+		}
+		rv = rv.Elem()
+	}
+	var fv reflect.Value
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("UNKNOWN FIELD %s", name)
+		}
+		if fv = rv.MapIndex(reflect.ValueOf(name).Convert(rv.Type().Key())); !fv.IsValid() {
+			return nil, nil
+		}
+	case reflect.Struct:
+		index, ok := matchFields(rv.Type())[name]
+		if !ok {
+			return nil, fmt.Errorf("UNKNOWN FIELD %s", name)
+		}
+		fv = rv.FieldByIndex(index)
+	default:
+		return nil, fmt.Errorf("UNKNOWN FIELD %s", name)
+	}
+	v, err := matchValue(fv)
+	if err != nil {
+		return nil, fmt.Errorf("UNSUPPORTED TYPE VALUE %v FOR %v", fv, name)
+	}
+	return v, nil
+}
+
+func matchValue(v reflect.Value) (any, error) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.Bool:
+		return v.Bool(), nil
+	}
+	return nil, fmt.Errorf("UNSUPPORTED TYPE %v", v.Type())
+}
+
+// matchCompare orders the field value fv against the query value v, bools
+// order like the 0 and 1 they are stored as.
+func matchCompare(fv any, v any) (int, error) {
+	qv, err := matchValue(reflect.ValueOf(v))
+	if err != nil || qv == nil {
+		return 0, fmt.Errorf("UNSUPPORTED TYPE VALUE %v", v)
+	}
+	switch a := fv.(type) {
+	case string:
+		if b, ok := qv.(string); ok {
+			return strings.Compare(a, b), nil
+		}
+	case float64:
+		if b, ok := qv.(float64); ok {
+			return compareFloat(a, b), nil
+		}
+	case bool:
+		if b, ok := qv.(bool); ok {
+			return compareFloat(boolFloat(a), boolFloat(b)), nil
+		}
+	}
+	return 0, fmt.Errorf("UNSUPPORTED TYPE VALUE %v FOR %v", v, fv)
+}
+
+func compareFloat(a, b float64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+var matchFieldIndexes sync.Map
+
+// matchFields maps the json field names of t to their index, the same names
+// ParseSchema exposes.
+func matchFields(t reflect.Type) map[string][]int {
+	if fields, ok := matchFieldIndexes.Load(t); ok {
+		return fields.(map[string][]int)
+	}
+	fields := map[string][]int{}
+	var parse func(t reflect.Type, index []int)
+	parse = func(t reflect.Type, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			fi := append(append([]int{}, index...), i)
+			if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+				parse(sf.Type, fi)
+				continue
+			}
+			if !sf.IsExported() {
+				continue
+			}
+			name := strings.Split(sf.Tag.Get("json"), ",")[0]
+			if name == "-" {
+				continue
+			} else if name == "" {
+				name = sf.Name
+			}
+			fields[name] = fi
+		}
+	}
+	parse(t, nil)
+	matchFieldIndexes.Store(t, fields)
+	return fields
+}
+
+// likeRegexp compiles a LIKE pattern, where \ escapes the next character.
+func likeRegexp(pattern string, insensitive bool) *regexp.Regexp {
+	sb := strings.Builder{}
+	sb.WriteString("(?s)")
+	if insensitive {
+		sb.WriteString("(?i)")
+	}
+	sb.WriteString("^")
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; {
+		case c == '\\' && i+1 < len(runes):
+			i++
+			sb.WriteString(regexp.QuoteMeta(string(runes[i])))
+		case c == '%':
+			sb.WriteString(".*")
+		case c == '_':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
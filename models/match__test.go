@@ -0,0 +1,199 @@
+package models
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type matchItem struct {
+	ID     uint     `json:"id" gorm:"primary_key"`
+	Name   string   `json:"name"`
+	Note   *string  `json:"note"`
+	Rank   int      `json:"rank"`
+	Score  *float64 `json:"score"`
+	Active bool     `json:"active"`
+}
+
+func TestCondition_Match(t *testing.T) {
+	book := Book{ID: 2, Title: "Harry Potter and the Chamber of Secrets", Author: "J. K. Rawling"}
+
+	for filter, expected := range map[string]bool{
+		`title ~ "Chamber"`:                             true,
+		`title ~ "chamber"`:                             false,
+		`title ~* "chamber"`:                            true,
+		`title like "Harry%Secrets"`:                    true,
+		`title ~ "%"`:                                   false,
+		`id between 1 and 2 and not author = "Herge"`:   true,
+		`id in (1, 3) or author like "J. K. R_wling"`:   true,
+		`not (id >= 2 or summary is null)`:              false,
+		`summary = "" and title != "Tintin in America"`: true,
+	} {
+		condition, err := ParseFilter(filter)
+		assert.NoError(t, err, filter)
+		match, err := condition.Match(&book)
+		assert.NoError(t, err, filter)
+		assert.Equal(t, expected, match, filter)
+	}
+
+	match, err := NewCondition().Equal("author", "Herge").Match(map[string]any{"author": "Herge"})
+	assert.NoError(t, err)
+	assert.True(t, match)
+
+	_, err = NewCondition().Equal("publisher", "Casterman").Match(book)
+	assert.EqualError(t, err, "UNKNOWN FIELD publisher")
+
+	_, err = NewCondition().Equal("id", "2").Match(book)
+	assert.EqualError(t, err, "UNSUPPORTED TYPE VALUE 2 FOR 2")
+}
+
+func TestCondition_MatchNull(t *testing.T) {
+	item := matchItem{ID: 1, Name: "a"}
+
+	for _, condition := range []*Condition{
+		NewCondition().Equal("note", "a"),
+		NewCondition().Not(NewCondition().Equal("note", "a")),
+		NewCondition().NotIn("score", 1.0),
+		NewCondition().Or(NewCondition().Less("score", 1.0).Equal("name", "b")),
+	} {
+		match, err := condition.Match(item)
+		assert.NoError(t, err)
+		assert.False(t, match, condition.String())
+	}
+
+	match, err := NewCondition().Or(NewCondition().Less("score", 1.0).Equal("name", "a")).Match(item)
+	assert.NoError(t, err)
+	assert.True(t, match)
+}
+
+// TestCondition_MatchSQL checks Match against the rows sqlite returns for
+// random conditions.
+func TestCondition_MatchSQL(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:match?mode=memory"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal("Init GORM Error", err)
+	}
+	if err := db.AutoMigrate(&matchItem{}); err != nil {
+		t.Fatal("AutoMigrate Error", err)
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.SetMaxOpenConns(1)
+	}
+	repo := NewRepository[matchItem](NewDatabaseModel(db))
+
+	seed := int64(20221017)
+	r := rand.New(rand.NewSource(seed))
+	items := []matchItem{}
+	for i := 0; i < 80; i++ {
+		item := matchItem{Name: randomMatchString(r, 4, "aAb_%\\"), Rank: r.Intn(4), Active: r.Intn(2) == 0}
+		if r.Intn(3) > 0 {
+			note := randomMatchString(r, 4, "aAb_%\\")
+			item.Note = &note
+		}
+		if r.Intn(3) > 0 {
+			score := []float64{-1, 0, 1.5, 2}[r.Intn(4)]
+			item.Score = &score
+		}
+		item, err := repo.Create(context.Background(), item)
+		if err != nil {
+			t.Fatal("Create Error", err)
+		}
+		items = append(items, item)
+	}
+
+	for i := 0; i < 500; i++ {
+		condition := randomMatchCondition(r, 2)
+		page, err := repo.Finds(context.Background(), NewQuery(nil, condition, nil), 0, 1000)
+		if !assert.NoError(t, err, condition.String()) {
+			continue
+		}
+		expected := []uint{}
+		for _, item := range page.Data {
+			expected = append(expected, item.ID)
+		}
+		sort.Slice(expected, func(i, j int) bool { return expected[i] < expected[j] })
+
+		actual := []uint{}
+		for _, item := range items {
+			match, err := condition.Match(&item)
+			if !assert.NoError(t, err, condition.String()) {
+				break
+			}
+			if match {
+				actual = append(actual, item.ID)
+			}
+		}
+		assert.Equal(t, expected, actual, "seed %d: %s", seed, condition.String())
+	}
+}
+
+func randomMatchString(r *rand.Rand, n int, chars string) string {
+	bb := make([]byte, r.Intn(n+1))
+	for i := range bb {
+		bb[i] = chars[r.Intn(len(chars))]
+	}
+	return string(bb)
+}
+
+func randomMatchCondition(r *rand.Rand, depth int) *Condition {
+	c := NewCondition()
+	for i := r.Intn(3) + 1; i > 0; i-- {
+		switch n := r.Intn(6); {
+		case depth > 0 && n == 0:
+			c.Not(randomMatchCondition(r, depth-1))
+		case depth > 0 && n == 1:
+			c.Or(randomMatchCondition(r, depth-1))
+		default:
+			randomMatchOp(r, c)
+		}
+	}
+	return c
+}
+
+func randomMatchOp(r *rand.Rand, c *Condition) {
+	compare := []func(string, any) *Condition{c.Equal, c.NotEqual, c.Less, c.LessEqual, c.Greater, c.GreaterEqual}
+	switch field := []string{"name", "note", "rank", "score", "active"}[r.Intn(5)]; field {
+	case "name", "note":
+		value := func() any { return randomMatchString(r, 2, "aAb_%\\") }
+		switch n := r.Intn(11); n {
+		case 0, 1, 2, 3, 4, 5:
+			compare[n](field, value())
+		case 6:
+			c.Like(field, value().(string))
+		case 7:
+			c.ILike(field, value().(string))
+		case 8:
+			c.LikePattern(field, randomMatchString(r, 3, "aAb_%"))
+		case 9:
+			c.ILikePattern(field, randomMatchString(r, 3, "aAb_%"))
+		case 10:
+			c.In(field, value(), value())
+		}
+	case "rank", "score":
+		value := func() any { return []float64{-1, 0, 1, 1.5, 2, 3}[r.Intn(6)] }
+		switch n := r.Intn(10); n {
+		case 0, 1, 2, 3, 4, 5:
+			compare[n](field, value())
+		case 6:
+			c.In(field, value(), value())
+		case 7:
+			c.NotIn(field, value(), value())
+		case 8:
+			c.Between(field, value(), value())
+		case 9:
+			c.IsNull(field)
+		}
+	case "active":
+		if r.Intn(3) == 0 {
+			c.IsNotNull(field)
+		} else {
+			compare[r.Intn(2)](field, r.Intn(2) == 0)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ODataFrontend reads the OData v4 system query options $filter, $orderby,
+// $select, $top and $skip. $filter supports eq, ne, lt, le, gt, ge, in, and,
+// or, not, comparison with null and the contains, startswith and endswith
+// functions. $top is at least 1.
+type ODataFrontend struct{}
+
+func (ODataFrontend) Name() string {
+	return "odata"
+}
+
+func (ODataFrontend) Parse(schema *Schema, params url.Values, query *Query, opts *PageOptions) error {
+	if str := params.Get("$filter"); str != "" {
+		filter, err := ParseODataFilter(str)
+		if err != nil {
+			return err
+		}
+		query.Condition.and(filter)
+	}
+	if str := params.Get("$orderby"); str != "" {
+		keys := []QueryOrderBy{}
+		for _, item := range strings.Split(str, ",") {
+			parts := strings.Fields(item)
+			if len(parts) == 0 || len(parts) > 2 {
+				return &BadRequestError{Err: fmt.Errorf("Invalid $orderby %s", str)}
+			}
+			key := QueryOrderBy{Field: parts[0]}
+			if len(parts) == 2 {
+				switch strings.ToLower(parts[1]) {
+				case "asc":
+				case "desc":
+					key.Desc = true
+				default:
+					return &BadRequestError{Err: fmt.Errorf("Invalid $orderby direction %s", parts[1])}
+				}
+			}
+			keys = append(keys, key)
+		}
+		query.SortBy(keys...)
+	}
+	if str := params.Get("$select"); str != "" {
+		query.Select = []string{}
+		for _, name := range strings.Split(str, ",") {
+			query.Select = append(query.Select, strings.TrimSpace(name))
+		}
+	}
+	if err := paramInt(params, "$top", &opts.Limit); err != nil {
+		return err
+	} else if params.Get("$top") != "" && opts.Limit == 0 {
+		// a zero Limit is no limit at all, not an empty page
+		return &BadRequestError{Err: fmt.Errorf("Invalid $top %s, it must be at least 1", params.Get("$top"))}
+	}
+	return paramInt(params, "$skip", &opts.Offset)
+}
+
+var odataOperators = map[string]string{"eq": "=", "ne": "!=", "lt": "<", "le": "<=", "gt": ">", "ge": ">="}
+
+var odataFunctions = map[string]string{"contains": MatchContains, "startswith": MatchPrefix, "endswith": MatchSuffix}
+
+type odataParser struct {
+	filterParser
+}
+
+// ParseODataFilter parses an OData $filter expression into a Condition.
+func ParseODataFilter(input string) (*Condition, error) {
+	tokens, err := lexOData(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &odataParser{filterParser{tokens: tokens}}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.errorf(t, "expected and, or")
+	}
+	return rootCondition(e), nil
+}
+
+func lexOData(input string) ([]filterToken, error) {
+	tokens := []filterToken{}
+	for i := 0; i < len(input); {
+		c := input[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(':
+			tokens = append(tokens, filterToken{kind: tokenLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, filterToken{kind: tokenRParen, text: ")", pos: i})
+			i++
+		case c == ',':
+			tokens = append(tokens, filterToken{kind: tokenComma, text: ",", pos: i})
+			i++
+		case c == '\'':
+			// quotes inside a string are doubled
+			j, sb := i+1, strings.Builder{}
+			for ; j < len(input); j++ {
+				if input[j] == '\'' {
+					if j+1 < len(input) && input[j+1] == '\'' {
+						j++
+					} else {
+						break
+					}
+				}
+				sb.WriteByte(input[j])
+			}
+			if j >= len(input) {
+				return nil, &FilterError{Pos: i, Message: "unterminated string"}
+			}
+			tokens = append(tokens, filterToken{kind: tokenString, text: input[i : j+1], value: sb.String(), pos: i})
+			i = j + 1
+		case c == '-' || c >= '0' && c <= '9':
+			j := i + 1
+			for ; j < len(input) && strings.ContainsRune("0123456789.eE", rune(input[j])); j++ {
+			}
+			f, err := strconv.ParseFloat(input[i:j], 64)
+			if err != nil {
+				return nil, &FilterError{Pos: i, Message: "invalid number " + input[i:j]}
+			}
+			tokens = append(tokens, filterToken{kind: tokenNumber, text: input[i:j], value: f, pos: i})
+			i = j
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i + 1
+			for ; j < len(input) && (input[j] == '_' || input[j] == '/' || input[j] >= 'a' && input[j] <= 'z' || input[j] >= 'A' && input[j] <= 'Z' || input[j] >= '0' && input[j] <= '9'); j++ {
+			}
+			tokens = append(tokens, filterToken{kind: tokenIdent, text: input[i:j], pos: i})
+			i = j
+		default:
+			return nil, &FilterError{Pos: i, Message: fmt.Sprintf("unexpected character %q", c)}
+		}
+	}
+	return append(tokens, filterToken{kind: tokenEOF, pos: len(input)}), nil
+}
+
+func (p *odataParser) parseOr() (any, error) {
+	return p.parseList("OR", p.parseAnd)
+}
+
+func (p *odataParser) parseAnd() (any, error) {
+	return p.parseList("AND", p.parseUnary)
+}
+
+func (p *odataParser) parseUnary() (any, error) {
+	t := p.peek()
+	switch {
+	case t.keyword("not"):
+		p.next()
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notCondition(e), nil
+	case t.kind == tokenLParen:
+		p.next()
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenRParen {
+			return nil, p.errorf(t, "expected )")
+		}
+		return e, nil
+	case t.kind == tokenIdent && p.tokens[p.pos+1].kind == tokenLParen:
+		return p.parseFunction()
+	}
+	return p.parseComparison()
+}
+
+// parseFunction parses contains, startswith and endswith into LIKE.
+func (p *odataParser) parseFunction() (any, error) {
+	name := p.next()
+	mode, ok := odataFunctions[strings.ToLower(name.text)]
+	if !ok {
+		return nil, p.errorf(name, "expected contains, startswith, endswith")
+	}
+	p.next()
+	field := p.next()
+	if field.kind != tokenIdent {
+		return nil, p.errorf(field, "expected field")
+	}
+	if t := p.next(); t.kind != tokenComma {
+		return nil, p.errorf(t, "expected ,")
+	}
+	value := p.next()
+	if value.kind != tokenString {
+		return nil, p.errorf(value, "expected string")
+	}
+	if t := p.next(); t.kind != tokenRParen {
+		return nil, p.errorf(t, "expected )")
+	}
+	return findQueryOp{op: "LIKE", field: field.text, value: value.value, mode: mode}, nil
+}
+
+func (p *odataParser) parseComparison() (any, error) {
+	field := p.next()
+	if field.kind != tokenIdent || field.keyword("and") || field.keyword("or") {
+		return nil, p.errorf(field, "expected field")
+	}
+	t := p.next()
+	if t.keyword("in") {
+		vs, err := p.parseValues()
+		if err != nil {
+			return nil, err
+		}
+		return findQueryOp{op: "IN", field: field.text, value: vs}, nil
+	}
+	op, ok := odataOperators[strings.ToLower(t.text)]
+	if t.kind != tokenIdent || !ok {
+		return nil, p.errorf(t, "expected eq, ne, lt, le, gt, ge, in")
+	}
+	if v := p.peek(); v.keyword("null") {
+		p.next()
+		switch op {
+		case "=":
+			return findQueryOp{op: "IS NULL", field: field.text}, nil
+		case "!=":
+			return findQueryOp{op: "IS NOT NULL", field: field.text}, nil
+		}
+		return nil, p.errorf(v, "expected value")
+	}
+	v, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	return findQueryOp{op: op, field: field.text, value: v}, nil
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	test_lib "github.com/senomas/go-api/test/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOData_Filter(t *testing.T) {
+	for filter, expected := range map[string]string{
+		`title eq 'Tintin in Tibet'`:                                         `title = "Tintin in Tibet"`,
+		`id ne 1 and id lt 2 and id le 3 and id gt 4 and id ge 5`:            `id != 1 and id < 2 and id <= 3 and id > 4 and id >= 5`,
+		`contains(title,'Potter') or startswith(title, 'Tin')`:               `title ~ "Potter" or title like "Tin%"`,
+		`not endswith(title,'Stone') and summary eq null and author ne null`: `not title like "%Stone" and summary is null and author is not null`,
+		`id in (1, 4) and (author eq 'O''Brien' or published eq true)`:       `id in (1, 4) and (author = "O'Brien" or published = true)`,
+		`NOT (id eq 1 and id eq 2)`:                                          `not (id = 1 and id = 2)`,
+	} {
+		condition, err := ParseODataFilter(filter)
+		if assert.NoError(t, err, filter) {
+			assert.Equal(t, expected, condition.String(), filter)
+		}
+	}
+
+	condition, err := ParseODataFilter(`contains(title,'Potter') and id gt 1`)
+	assert.NoError(t, err)
+	expected := NewCondition().Like("title", "Potter").Greater("id", 1.0)
+	assert.Equal(t, test_lib.Marshal(t, expected), test_lib.Marshal(t, condition))
+}
+
+func TestOData_FilterErrors(t *testing.T) {
+	for filter, expected := range map[string]FilterError{
+		`title eq`:               {Pos: 8, Message: "expected value, found end of filter"},
+		`title equals 'a'`:       {Pos: 6, Message: `expected eq, ne, lt, le, gt, ge, in, found "equals"`},
+		`title eq 'a`:            {Pos: 9, Message: "unterminated string"},
+		`id lt null`:             {Pos: 6, Message: `expected value, found "null"`},
+		`substringof('a',title)`: {Pos: 0, Message: `expected contains, startswith, endswith, found "substringof"`},
+		`contains(title 'a')`:    {Pos: 15, Message: `expected ,, found "'a'"`},
+		`title eq 'a' title`:     {Pos: 13, Message: `expected and, or, found "title"`},
+	} {
+		_, err := ParseODataFilter(filter)
+		var fe *FilterError
+		if assert.True(t, errors.As(err, &fe), filter) {
+			assert.Equal(t, expected, *fe, filter)
+		}
+	}
+}
+
+func TestOData_Options(t *testing.T) {
+	params, _ := url.ParseQuery("$filter=id gt 1&$orderby=author, title desc&$select=id,title&$top=10&$skip=20")
+	query := SchemaOf(&Book{}).NewQuery()
+	opts := PageOptions{Limit: 1000}
+	assert.NoError(t, ODataFrontend{}.Parse(SchemaOf(&Book{}), params, query, &opts))
+	assert.Equal(t, []string{"id", "title"}, query.Select)
+	assert.Equal(t, []QueryOrderBy{Asc("author"), Desc("title")}, query.OrderBy.Keys)
+	assert.Equal(t, PageOptions{Limit: 10, Offset: 20}, opts)
+	where, _ := query.Condition.Apply("", []any{})
+	assert.Equal(t, "id > ?", where)
+
+	var badRequest *BadRequestError
+	for _, param := range []string{"$orderby=title up", "$top=-1", "$top=0", "$skip=x"} {
+		params, _ := url.ParseQuery(param)
+		err := ODataFrontend{}.Parse(SchemaOf(&Book{}), params, SchemaOf(&Book{}).NewQuery(), &opts)
+		assert.True(t, errors.As(err, &badRequest), param)
+	}
+}
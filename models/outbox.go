@@ -0,0 +1,281 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OutboxEvent is a change of a record, written to the outbox in the
+// transaction of the write and published later by an OutboxRelay. Action is
+// one of the Audit actions and Data the json form of the record after the
+// write, before it for a hard delete. Consumers tell redeliveries apart by
+// ID. ParkedAt is set on an event the relay gave up on, see
+// OutboxRelay.MaxAttempts.
+type OutboxEvent struct {
+	ID          uint       `json:"id" gorm:"primary_key"`
+	RecordType  string     `json:"type"`
+	RecordID    string     `json:"recordId"`
+	Action      string     `json:"action"`
+	RequestID   string     `json:"requestId,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	Data        JSONText   `json:"data"`
+	PublishedAt *time.Time `json:"-" gorm:"index:idx_outbox_events_published_at,priority:1"`
+	Attempts    int        `json:"-"`
+	LastError   string     `json:"-"`
+	ParkedAt    *time.Time `json:"-" gorm:"index:idx_outbox_events_published_at,priority:2"`
+}
+
+// MigrateOutbox creates the outbox_events table.
+func MigrateOutbox(db *gorm.DB) error {
+	return db.AutoMigrate(&OutboxEvent{})
+}
+
+// enqueue writes the outbox event of a write in tx, before is nil for a
+// create and after for a hard delete.
+func (r *Repository[T]) enqueue(tx *gorm.DB, action string, before *T, after *T) error {
+	if !r.Outbox {
+		return nil
+	}
+	s, err := r.gormSchema()
+	if err != nil {
+		return err
+	}
+	record := after
+	if record == nil {
+		record = before
+	}
+	id, err := r.PrimaryKey(tx.Statement.Context, *record)
+	if err != nil {
+		return err
+	}
+	event := OutboxEvent{RecordType: s.Table, RecordID: fmt.Sprint(id), Action: action}
+	if info, ok := tx.Statement.Context.Value(auditKey{}).(auditInfo); ok {
+		event.RequestID = info.requestID
+	}
+	if event.Data, err = snapshot(record); err != nil {
+		return err
+	}
+	if err := tx.Create(&event).Error; err != nil {
+		return r.db().TranslateError(err)
+	}
+	return nil
+}
+
+// Sink receives the events published by an OutboxRelay. An error leaves the
+// event in the outbox, it is published again by the next flush until the
+// relay parks it.
+type Sink interface {
+	Publish(ctx context.Context, event OutboxEvent) error
+}
+
+// SinkFunc adapts a function to a Sink.
+type SinkFunc func(ctx context.Context, event OutboxEvent) error
+
+func (f SinkFunc) Publish(ctx context.Context, event OutboxEvent) error {
+	return f(ctx, event)
+}
+
+// MultiSink publishes each event to all of its sinks in turn. An event that
+// failed in one of them is published again to all of them, the sinks must
+// tolerate redeliveries.
+type MultiSink []Sink
+
+func (s MultiSink) Publish(ctx context.Context, event OutboxEvent) error {
+	for _, sink := range s {
+		if err := sink.Publish(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ChannelSink hands the events to in-process consumers, a full channel
+// blocks the relay.
+type ChannelSink chan OutboxEvent
+
+func (s ChannelSink) Publish(ctx context.Context, event OutboxEvent) error {
+	select {
+	case s <- event:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FileSink appends the events as NDJSON lines to the file at Path, synced
+// before the event counts as published.
+type FileSink struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func NewFileSink(path string) *FileSink {
+	return &FileSink{Path: path}
+}
+
+func (s *FileSink) Publish(ctx context.Context, event OutboxEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// WebhookSink POSTs each event as json to URL, with its ID in the
+// X-Event-ID header. Any 2xx status is a delivery. A nil Client is
+// http.DefaultClient.
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{URL: url}
+}
+
+func (s *WebhookSink) Publish(ctx context.Context, event OutboxEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-ID", strconv.FormatUint(uint64(event.ID), 10))
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Webhook %s responded %s", s.URL, resp.Status)
+	}
+	return nil
+}
+
+// OutboxRelay publishes the outbox events to Sink in ID order, marking each
+// one published once the sink accepted it. Delivery is at least once: an
+// event whose sink failed, or whose mark was lost, is published again. The
+// later events of a record wait for its failed one so that each record's
+// events arrive in order, until it failed MaxAttempts times: it is then
+// parked, kept in the outbox for inspection but no longer published, and the
+// record's later events go on without it. Run one relay per database.
+//
+// Run prunes the events published more than Retention ago, none when it is
+// zero.
+type OutboxRelay struct {
+	DB          *DatabaseModel
+	Sink        Sink
+	Interval    time.Duration
+	BatchSize   int
+	MaxAttempts int
+	Retention   time.Duration
+}
+
+func NewOutboxRelay(db *DatabaseModel, sink Sink) *OutboxRelay {
+	return &OutboxRelay{DB: db, Sink: sink, Interval: time.Second, BatchSize: 100, MaxAttempts: 10, Retention: 7 * 24 * time.Hour}
+}
+
+// Run flushes the outbox every Interval and prunes it every hour until ctx
+// is done.
+func (o *OutboxRelay) Run(ctx context.Context) error {
+	if o.Retention > 0 {
+		go runEvery(ctx, time.Hour, "Outbox prune", func(ctx context.Context) error {
+			_, err := o.Prune(ctx, time.Now().Add(-o.Retention))
+			return err
+		})
+	}
+	return runEvery(ctx, o.Interval, "Outbox relay", func(ctx context.Context) error {
+		_, err := o.Flush(ctx)
+		return err
+	})
+}
+
+// Flush publishes the pending events once and returns how many the sink
+// accepted. The sink errors are recorded on their events, the returned error
+// is a database one. Each flush reads the pending events from the first one:
+// ids are allocated before commit, an event committed late may have a lower
+// id than the ones published already. Published and parked events are not
+// read again.
+func (o *OutboxRelay) Flush(ctx context.Context) (int, error) {
+	db := o.DB.orDefault()
+	size := o.BatchSize
+	if size <= 0 {
+		size = 100
+	}
+	published := 0
+	blocked := map[string]bool{}
+	var last uint
+	for {
+		events := []OutboxEvent{}
+		if err := db.DB.WithContext(ctx).Where("published_at IS NULL AND parked_at IS NULL AND id > ?", last).
+			Order("id").Limit(size).Find(&events).Error; err != nil {
+			return published, db.TranslateError(err)
+		}
+		for _, event := range events {
+			last = event.ID
+			key := event.RecordType + "/" + event.RecordID
+			if blocked[key] {
+				continue
+			}
+			if err := o.Sink.Publish(ctx, event); err != nil {
+				updates := map[string]any{"attempts": gorm.Expr("attempts + 1"), "last_error": err.Error()}
+				if o.MaxAttempts > 0 && event.Attempts+1 >= o.MaxAttempts {
+					log.Printf("Outbox event %d parked after %d attempts: %v\n", event.ID, event.Attempts+1, err)
+					updates["parked_at"] = time.Now()
+				} else {
+					blocked[key] = true
+				}
+				if err := db.DB.WithContext(ctx).Model(&event).Updates(updates).Error; err != nil {
+					return published, db.TranslateError(err)
+				}
+				continue
+			}
+			if err := db.DB.WithContext(ctx).Model(&event).Update("published_at", time.Now()).Error; err != nil {
+				return published, db.TranslateError(err)
+			}
+			published++
+		}
+		if len(events) < size {
+			return published, nil
+		}
+	}
+}
+
+// Prune removes the events published before the given time and returns their
+// number.
+func (o *OutboxRelay) Prune(ctx context.Context, before time.Time) (int64, error) {
+	return o.DB.orDefault().prune(ctx, &OutboxEvent{}, "published_at < ?", before)
+}
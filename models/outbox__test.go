@@ -0,0 +1,172 @@
+package models
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepository_Outbox(t *testing.T) {
+	ctx := WithAudit(context.Background(), "", "req-1")
+	repo := newTestRepository(t)
+	if err := MigrateOutbox(repo.DB.DB); err != nil {
+		t.Fatal("MigrateOutbox Error", err)
+	}
+	repo.Outbox = true
+
+	_, err := repo.Create(ctx, Book{Title: "Tintin in Tibet", Author: "Herge"})
+	assert.NoError(t, err)
+	_, err = repo.Create(ctx, Book{Title: "Tintin in Congo", Author: "Herge"})
+	assert.NoError(t, err)
+	_, err = repo.Update(ctx, 1, "", func(book *Book) error {
+		book.Summary = "Chang is alive"
+		return nil
+	})
+	assert.NoError(t, err)
+	_, err = repo.Delete(ctx, 2, "")
+	assert.NoError(t, err)
+	// a failed write leaves no event
+	_, err = repo.Create(ctx, Book{Title: "Tintin in Tibet", Author: "Herge"})
+	assert.Error(t, err)
+
+	// the sink fails the first delivery of book 1, its update waits for it
+	published := []OutboxEvent{}
+	failed := false
+	relay := NewOutboxRelay(repo.DB, SinkFunc(func(ctx context.Context, event OutboxEvent) error {
+		if event.RecordID == "1" && !failed {
+			failed = true
+			return errors.New("sink is down")
+		}
+		published = append(published, event)
+		return nil
+	}))
+	relay.BatchSize = 2
+	n, err := relay.Flush(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	var pending []OutboxEvent
+	assert.NoError(t, repo.DB.DB.Where("published_at IS NULL").Order("id").Find(&pending).Error)
+	if assert.Len(t, pending, 2) {
+		assert.Equal(t, 1, pending[0].Attempts)
+		assert.Equal(t, "sink is down", pending[0].LastError)
+		assert.Equal(t, 0, pending[1].Attempts)
+	}
+
+	n, err = relay.Flush(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = relay.Flush(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	events := []string{}
+	for _, event := range published {
+		events = append(events, event.RecordID+" "+event.Action)
+		assert.Equal(t, "books", event.RecordType)
+		assert.Equal(t, "req-1", event.RequestID)
+	}
+	assert.Equal(t, []string{"2 create", "2 delete", "1 create", "1 update"}, events)
+	assert.JSONEq(t, `{"id":1,"title":"Tintin in Tibet","author":"Herge","summary":"Chang is alive","deletedAt":null}`, string(published[3].Data))
+
+	pruned, err := relay.Prune(ctx, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), pruned)
+}
+
+func TestOutboxRelay_Park(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	if err := MigrateOutbox(repo.DB.DB); err != nil {
+		t.Fatal("MigrateOutbox Error", err)
+	}
+	repo.Outbox = true
+
+	_, err := repo.Create(ctx, Book{Title: "Tintin in Tibet", Author: "Herge"})
+	assert.NoError(t, err)
+	_, err = repo.Update(ctx, 1, "", func(book *Book) error {
+		book.Summary = "Chang is alive"
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// the create of book 1 always fails, its update waits until it is parked
+	reads := 0
+	published := []string{}
+	relay := NewOutboxRelay(repo.DB, SinkFunc(func(ctx context.Context, event OutboxEvent) error {
+		reads++
+		if event.Action == AuditCreate {
+			return errors.New("poisoned")
+		}
+		published = append(published, event.Action)
+		return nil
+	}))
+	relay.MaxAttempts = 2
+	for _, expected := range []int{0, 1, 0} {
+		n, err := relay.Flush(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, n)
+	}
+	assert.Equal(t, []string{AuditUpdate}, published)
+	assert.Equal(t, 3, reads)
+
+	var parked OutboxEvent
+	assert.NoError(t, repo.DB.DB.Where("parked_at IS NOT NULL").First(&parked).Error)
+	assert.Equal(t, AuditCreate, parked.Action)
+	assert.Equal(t, 2, parked.Attempts)
+	assert.Equal(t, "poisoned", parked.LastError)
+	pruned, err := relay.Prune(ctx, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), pruned)
+}
+
+func TestOutboxSinks(t *testing.T) {
+	ctx := context.Background()
+	event := OutboxEvent{ID: 7, RecordType: "books", RecordID: "1", Action: AuditCreate, Data: JSONText(`{"id":1}`)}
+
+	ch := make(ChannelSink, 1)
+	assert.NoError(t, ch.Publish(ctx, event))
+	assert.Equal(t, event, <-ch)
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	ch <- event
+	assert.ErrorIs(t, ch.Publish(canceled, event), context.Canceled)
+
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	file := NewFileSink(path)
+	assert.NoError(t, file.Publish(ctx, event))
+	assert.NoError(t, file.Publish(ctx, event))
+	if f, err := os.Open(path); assert.NoError(t, err) {
+		defer f.Close()
+		lines := 0
+		for scanner := bufio.NewScanner(f); scanner.Scan(); lines++ {
+			var e OutboxEvent
+			assert.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+			assert.Equal(t, event.ID, e.ID)
+		}
+		assert.Equal(t, 2, lines)
+	}
+
+	status := http.StatusAccepted
+	var received []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "7", r.Header.Get("X-Event-ID"))
+		received, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	webhook := NewWebhookSink(server.URL)
+	assert.NoError(t, webhook.Publish(ctx, event))
+	assert.JSONEq(t, `{"id":7,"type":"books","recordId":"1","action":"create","createdAt":"0001-01-01T00:00:00Z","data":{"id":1}}`, string(received))
+	status = http.StatusServiceUnavailable
+	assert.EqualError(t, webhook.Publish(ctx, event), "Webhook "+server.URL+" responded 503 Service Unavailable")
+}
@@ -0,0 +1,269 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JSONPatchContentType  = "application/json-patch+json"
+)
+
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// MergePatch applies an RFC 7396 merge patch to doc.
+func MergePatch(doc []byte, patch []byte) ([]byte, error) {
+	var target, p any
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, &BadRequestError{Err: err}
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, &BadRequestError{Err: err}
+	}
+	return json.Marshal(mergePatch(target, p))
+}
+
+func mergePatch(target any, patch any) any {
+	pm, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	tm, ok := target.(map[string]any)
+	if !ok {
+		tm = map[string]any{}
+	}
+	for k, v := range pm {
+		if v == nil {
+			delete(tm, k)
+		} else {
+			tm[k] = mergePatch(tm[k], v)
+		}
+	}
+	return tm
+}
+
+// JSONPatch applies an RFC 6902 patch document to doc. A failing "test"
+// operation is reported as a ConflictError.
+func JSONPatch(doc []byte, patch []byte) ([]byte, error) {
+	var ops []PatchOperation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, &BadRequestError{Err: err}
+	}
+	var root any
+	if err := json.Unmarshal(doc, &root); err != nil {
+		return nil, &BadRequestError{Err: err}
+	}
+	for i, op := range ops {
+		var value any
+		if op.Value != nil {
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return nil, &BadRequestError{Err: err}
+			}
+		}
+		var err error
+		switch op.Op {
+		case "add":
+			root, err = pointerSet(root, op.Path, value, true)
+		case "remove":
+			root, _, err = pointerRemove(root, op.Path)
+		case "replace":
+			if _, err = pointerGet(root, op.Path); err == nil {
+				root, err = pointerSet(root, op.Path, value, false)
+			}
+		case "move", "copy":
+			var v any
+			if v, err = pointerGet(root, op.From); err == nil {
+				if op.Op == "move" {
+					root, _, err = pointerRemove(root, op.From)
+				}
+				if err == nil {
+					root, err = pointerSet(root, op.Path, v, true)
+				}
+			}
+		case "test":
+			var v any
+			if v, err = pointerGet(root, op.Path); err == nil && !reflect.DeepEqual(v, value) {
+				return nil, &ConflictError{Err: fmt.Errorf("Patch test failed at %s", op.Path)}
+			}
+		default:
+			err = fmt.Errorf("Unsupported patch operation %q", op.Op)
+		}
+		if err != nil {
+			return nil, &BadRequestError{Err: fmt.Errorf("Patch operation %d: %w", i, err)}
+		}
+	}
+	return json.Marshal(root)
+}
+
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("Invalid pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func arrayIndex(token string, size int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i >= size {
+		return 0, fmt.Errorf("Invalid index %q", token)
+	}
+	return i, nil
+}
+
+func pointerGet(root any, pointer string) (any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	node := root
+	for _, t := range tokens {
+		switch n := node.(type) {
+		case map[string]any:
+			v, ok := n[t]
+			if !ok {
+				return nil, fmt.Errorf("Path not found %q", pointer)
+			}
+			node = v
+		case []any:
+			i, err := arrayIndex(t, len(n))
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, fmt.Errorf("Path not found %q", pointer)
+		}
+	}
+	return node, nil
+}
+
+// pointerSet stores value at pointer, inserting into arrays when insert is
+// set, and returns the new root.
+func pointerSet(root any, pointer string, value any, insert bool) (any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	parent, err := pointerGet(root, pointer[:strings.LastIndex(pointer, "/")])
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch p := parent.(type) {
+	case map[string]any:
+		p[last] = value
+	case []any:
+		i := len(p)
+		if last != "-" {
+			if i, err = arrayIndex(last, len(p)+1); err != nil {
+				return nil, err
+			}
+		}
+		if insert {
+			p = append(p[:i], append([]any{value}, p[i:]...)...)
+		} else if i < len(p) {
+			p[i] = value
+		}
+		return pointerSet(root, pointer[:strings.LastIndex(pointer, "/")], p, false)
+	default:
+		return nil, fmt.Errorf("Path not found %q", pointer)
+	}
+	return root, nil
+}
+
+func pointerRemove(root any, pointer string) (any, any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, root, nil
+	}
+	parentPointer := pointer[:strings.LastIndex(pointer, "/")]
+	parent, err := pointerGet(root, parentPointer)
+	if err != nil {
+		return nil, nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch p := parent.(type) {
+	case map[string]any:
+		v, ok := p[last]
+		if !ok {
+			return nil, nil, fmt.Errorf("Path not found %q", pointer)
+		}
+		delete(p, last)
+		return root, v, nil
+	case []any:
+		i, err := arrayIndex(last, len(p))
+		if err != nil {
+			return nil, nil, err
+		}
+		v := p[i]
+		root, err = pointerSet(root, parentPointer, append(p[:i:i], p[i+1:]...), false)
+		return root, v, err
+	}
+	return nil, nil, fmt.Errorf("Path not found %q", pointer)
+}
+
+// applyJSON replaces the json visible fields of data with doc, fields missing
+// from doc are reset to their zero value.
+func applyJSON(data any, doc []byte) error {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &keys); err != nil {
+		return &BadRequestError{Err: err}
+	}
+	ve := &ValidationError{Message: "INVALID PATCH"}
+	known := map[string]bool{}
+	resetJSONFields(reflect.ValueOf(data).Elem(), keys, known)
+	for k := range keys {
+		if !known[k] {
+			ve.add("/"+k, k, "UNKNOWN FIELD %s", k)
+		}
+	}
+	if len(ve.Errors) > 0 {
+		return ve
+	}
+	if err := json.Unmarshal(doc, data); err != nil {
+		return &ValidationError{Message: "INVALID PATCH", Errors: []FieldError{{Path: "/", Message: err.Error()}}}
+	}
+	return nil
+}
+
+func resetJSONFields(v reflect.Value, keys map[string]json.RawMessage, known map[string]bool) {
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			resetJSONFields(v.Field(i), keys, known)
+			continue
+		}
+		name := strings.Split(sf.Tag.Get("json"), ",")[0]
+		if !sf.IsExported() || name == "-" {
+			continue
+		} else if name == "" {
+			name = sf.Name
+		}
+		known[name] = true
+		if _, ok := keys[name]; !ok {
+			v.Field(i).Set(reflect.Zero(sf.Type))
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergePatch(t *testing.T) {
+	// RFC 7396 appendix A
+	cases := [][3]string{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, c := range cases {
+		doc, err := MergePatch([]byte(c[0]), []byte(c[1]))
+		if assert.NoError(t, err, c[1]) {
+			assert.JSONEq(t, c[2], string(doc), c[1])
+		}
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	// RFC 6902 appendix A
+	cases := [][3]string{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{`{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+		{`{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10},{"op":"copy","from":"/~1","path":"/a"}]`, `{"/":9,"~1":10,"a":9}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"replace","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux"]}`},
+	}
+	for _, c := range cases {
+		doc, err := JSONPatch([]byte(c[0]), []byte(c[1]))
+		if assert.NoError(t, err, c[1]) {
+			assert.JSONEq(t, c[2], string(doc), c[1])
+		}
+	}
+}
+
+func TestJSONPatch_Errors(t *testing.T) {
+	var conflict *ConflictError
+	_, err := JSONPatch([]byte(`{"baz":"qux"}`), []byte(`[{"op":"test","path":"/baz","value":"bar"}]`))
+	assert.True(t, errors.As(err, &conflict), "%v", err)
+
+	var badRequest *BadRequestError
+	for _, patch := range []string{
+		`[{"op":"add","path":"/baz/bat","value":"qux"}]`,
+		`[{"op":"remove","path":"/missing"}]`,
+		`[{"op":"replace","path":"/missing","value":1}]`,
+		`[{"op":"rename","path":"/baz"}]`,
+		`{"op":"add"}`,
+	} {
+		_, err := JSONPatch([]byte(`{"foo":"bar"}`), []byte(patch))
+		assert.True(t, errors.As(err, &badRequest), "%s %v", patch, err)
+	}
+}
+
+func TestApplyJSON(t *testing.T) {
+	book := Book{ID: 1, Title: "Tintin", Author: "Herge", Summary: "Reporter"}
+	assert.NoError(t, applyJSON(&book, []byte(`{"id":1,"title":"Tintin in Tibet","author":"Herge"}`)))
+	assert.Equal(t, Book{ID: 1, Title: "Tintin in Tibet", Author: "Herge"}, book)
+
+	var ve *ValidationError
+	err := applyJSON(&book, []byte(`{"id":1,"publisher":"Casterman"}`))
+	if assert.True(t, errors.As(err, &ve), "%v", err) {
+		assert.Equal(t, []FieldError{{Path: "/publisher", Field: "publisher", Message: "UNKNOWN FIELD publisher"}}, ve.Errors)
+	}
+}
+
+func TestRepository_PatchDeletedAt(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	_, err := repo.Create(ctx, Book{Title: "Tintin in Tibet", Author: "Herge"})
+	assert.NoError(t, err)
+
+	var ve *ValidationError
+	_, err = repo.Update(ctx, 1, "", func(book *Book) error {
+		doc, err := json.Marshal(book)
+		if err != nil {
+			return err
+		}
+		if doc, err = MergePatch(doc, []byte(`{"deletedAt":"2020-01-01T00:00:00Z"}`)); err != nil {
+			return err
+		}
+		return applyJSON(book, doc)
+	})
+	if assert.True(t, errors.As(err, &ve), "%v", err) {
+		assert.Equal(t, []FieldError{{Path: "deleted_at", Field: "deleted_at", Message: "READ ONLY"}}, ve.Errors)
+	}
+	_, err = repo.Find(ctx, 1)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"errors"
+	"net/http"
+)
+
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details body.
+type Problem struct {
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Detail string       `json:"detail,omitempty"`
+	Code   string       `json:"code,omitempty"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{Type: "about:blank", Title: http.StatusText(status), Status: status, Detail: detail}
+}
+
+// ProblemOf maps the domain errors to their HTTP status, anything else is
+// reported as an internal server error.
+func ProblemOf(err error) *Problem {
+	var validation *ValidationError
+	var notFound *NotFoundError
+	var constraint *ConstraintError
+	var conflict *ConflictError
+	var preconditionFailed *PreconditionFailedError
+	var preconditionRequired *PreconditionRequiredError
+	var badRequest *BadRequestError
+	var forbidden *ForbiddenError
+	var notAcceptable *NotAcceptableError
+	switch {
+	case errors.As(err, &validation):
+		p := NewProblem(http.StatusUnprocessableEntity, validation.Error())
+		p.Errors = validation.Errors
+		return p
+	case errors.As(err, &notFound):
+		return NewProblem(http.StatusNotFound, notFound.Error())
+	case errors.As(err, &constraint):
+		status := http.StatusUnprocessableEntity
+		if constraint.Constraint.Kind == ConstraintUnique {
+			status = http.StatusConflict
+		}
+		p := NewProblem(status, constraint.Error())
+		p.Code = constraint.Constraint.Code
+		if field := constraint.Constraint.Field; field != "" {
+			p.Errors = []FieldError{{Path: field, Field: field, Message: p.Code}}
+		}
+		return p
+	case errors.As(err, &conflict):
+		return NewProblem(http.StatusConflict, conflict.Error())
+	case errors.As(err, &preconditionFailed):
+		return NewProblem(http.StatusPreconditionFailed, preconditionFailed.Error())
+	case errors.As(err, &preconditionRequired):
+		return NewProblem(http.StatusPreconditionRequired, preconditionRequired.Error())
+	case errors.As(err, &badRequest):
+		return NewProblem(http.StatusBadRequest, badRequest.Error())
+	case errors.As(err, &forbidden):
+		return NewProblem(http.StatusForbidden, forbidden.Error())
+	case errors.As(err, &notAcceptable):
+		return NewProblem(http.StatusNotAcceptable, notAcceptable.Error())
+	}
+	return NewProblem(http.StatusInternalServerError, err.Error())
+}
@@ -7,28 +7,69 @@ import (
 	"net/url"
 )
 
+// Query selects rows, or with GroupBy or Aggregates the aggregate rows of
+// Repository.Aggregate, see QueryAggregate. IncludeDeleted adds the soft
+// deleted rows, OnlyDeleted selects nothing but them.
 type Query struct {
-	Select    []string     `json:"select"`
-	Condition Condition    `json:"condition"`
-	OrderBy   QueryOrderBy `json:"orderBy"`
+	Select         []string         `json:"select"`
+	Condition      Condition        `json:"condition"`
+	OrderBy        OrderBy          `json:"orderBy"`
+	GroupBy        []string         `json:"groupBy,omitempty"`
+	Aggregates     []QueryAggregate `json:"aggregates,omitempty"`
+	Having         *Condition       `json:"having,omitempty"`
+	IncludeDeleted bool             `json:"includeDeleted,omitempty"`
+	OnlyDeleted    bool             `json:"onlyDeleted,omitempty"`
 }
 
+const (
+	NullsFirst = "first"
+	NullsLast  = "last"
+)
+
+// QueryOrderBy is one sort key, Nulls is NullsFirst, NullsLast or empty for
+// the database default.
 type QueryOrderBy struct {
 	Field string `json:"f"`
 	Desc  bool   `json:"desc"`
+	Nulls string `json:"nulls,omitempty"`
+}
+
+// OrderBy is the ordered list of sort keys. On the wire it is an array, the
+// single object of older clients is still accepted and marshalled back as
+// such.
+type OrderBy struct {
+	Keys   []QueryOrderBy
+	single bool
 }
 
 type Condition struct {
 	op      string
 	entries []any
+	schema  *Schema
+	path    string
 }
 
 type findQueryOp struct {
-	op    string
-	field string
-	value any
+	op     string
+	field  string
+	column string
+	value  any
+	mode   string
 }
 
+// Match modes of LIKE and ILIKE. The value of MatchExact, the default, is
+// matched whole and the value of MatchContains, MatchPrefix and MatchSuffix
+// part of the field, all of them literally: their % and _ are escaped. Only
+// the value of MatchPattern is a pattern with the % and _ wildcards, escaped
+// with \.
+const (
+	MatchExact    = ""
+	MatchContains = "contains"
+	MatchPrefix   = "prefix"
+	MatchSuffix   = "suffix"
+	MatchPattern  = "pattern"
+)
+
 func Fields(fields ...string) []string {
 	return fields
 }
@@ -37,10 +78,72 @@ func NewQuery(Select []string, condition *Condition, orderBy *QueryOrderBy) *Que
 	if condition == nil {
 		condition = &Condition{}
 	}
-	if orderBy == nil {
-		orderBy = &QueryOrderBy{}
+	q := &Query{Select: Select, Condition: *condition}
+	if orderBy != nil && orderBy.Field != "" {
+		q.OrderBy = OrderBy{Keys: []QueryOrderBy{*orderBy}, single: true}
+	}
+	return q
+}
+
+// SortBy replaces the sort keys of the query.
+func (q *Query) SortBy(keys ...QueryOrderBy) *Query {
+	q.OrderBy = OrderBy{Keys: keys}
+	return q
+}
+
+func Asc(field string) QueryOrderBy {
+	return QueryOrderBy{Field: field}
+}
+
+func Desc(field string) QueryOrderBy {
+	return QueryOrderBy{Field: field, Desc: true}
+}
+
+// NullsFirst returns the key sorting null values before the others.
+func (o QueryOrderBy) NullsFirst() QueryOrderBy {
+	o.Nulls = NullsFirst
+	return o
+}
+
+// NullsLast returns the key sorting null values after the others.
+func (o QueryOrderBy) NullsLast() QueryOrderBy {
+	o.Nulls = NullsLast
+	return o
+}
+
+func (o OrderBy) MarshalJSON() ([]byte, error) {
+	if o.single && len(o.Keys) == 1 {
+		return json.Marshal(o.Keys[0])
+	}
+	if o.Keys == nil {
+		return []byte("[]"), nil
 	}
-	return &Query{Select: Select, Condition: *condition, OrderBy: *orderBy}
+	return json.Marshal(o.Keys)
+}
+
+func (o *OrderBy) UnmarshalJSON(b []byte) error {
+	var keys []QueryOrderBy
+	if err := json.Unmarshal(b, &keys); err == nil {
+		*o = OrderBy{Keys: keys}
+		return nil
+	}
+	var key QueryOrderBy
+	if err := json.Unmarshal(b, &key); err != nil {
+		return fmt.Errorf("INVALID ORDER BY %s", string(b))
+	}
+	*o = OrderBy{single: true}
+	if key.Field != "" {
+		o.Keys = []QueryOrderBy{key}
+	}
+	return nil
+}
+
+// path returns the JSON path of key i, orderBy for the single object form.
+func (o OrderBy) path(i int) string {
+	if o.single {
+		return "orderBy"
+	}
+	return fmt.Sprintf("orderBy[%d]", i)
 }
 
 func NewCondition() *Condition {
@@ -56,7 +159,12 @@ func (q *Query) String() string {
 	return ""
 }
 
+// Apply renders the condition with the postgres dialect.
 func (q *Condition) Apply(where string, params []any) (string, []any) {
+	return q.ApplyDialect(DialectOf("postgres"), where, params)
+}
+
+func (q *Condition) ApplyDialect(d Dialect, where string, params []any) (string, []any) {
 	op := " " + q.op + " "
 	for index, c := range q.entries {
 		switch ct := c.(type) {
@@ -65,7 +173,7 @@ func (q *Condition) Apply(where string, params []any) (string, []any) {
 			if index > 0 {
 				where += op
 			}
-			where, params = e.Apply(where, params)
+			where, params = e.ApplyDialect(d, where, params)
 		case Condition:
 			e := c.(Condition)
 			if index > 0 {
@@ -73,10 +181,10 @@ func (q *Condition) Apply(where string, params []any) (string, []any) {
 			}
 			if e.op == "NOT" {
 				where += "NOT "
-				where, params = e.Apply(where, params)
+				where, params = e.ApplyDialect(d, where, params)
 			} else {
 				where += "("
-				where, params = e.Apply(where, params)
+				where, params = e.ApplyDialect(d, where, params)
 				where += ")"
 			}
 		default:
@@ -86,6 +194,18 @@ func (q *Condition) Apply(where string, params []any) (string, []any) {
 	return where, params
 }
 
+// and appends the entries of the AND condition sub.
+func (q *Condition) and(sub *Condition) {
+	if q.op == "" {
+		q.op = "AND"
+	}
+	if q.op == "AND" {
+		q.entries = append(q.entries, sub.entries...)
+	} else {
+		*q = Condition{op: "AND", entries: []any{*q, *sub}, schema: q.schema, path: q.path}
+	}
+}
+
 func (q *Condition) Not(sub *Condition) *Condition {
 	nq := Condition{op: "NOT", entries: []any{*sub}}
 	q.entries = append(q.entries, nq)
@@ -103,13 +223,73 @@ func (q *Condition) Equal(field string, value any) *Condition {
 	return q
 }
 
+func (q *Condition) NotEqual(field string, value any) *Condition {
+	q.entries = append(q.entries, findQueryOp{op: "!=", field: field, value: value})
+	return q
+}
+
+func (q *Condition) Less(field string, value any) *Condition {
+	q.entries = append(q.entries, findQueryOp{op: "<", field: field, value: value})
+	return q
+}
+
+func (q *Condition) LessEqual(field string, value any) *Condition {
+	q.entries = append(q.entries, findQueryOp{op: "<=", field: field, value: value})
+	return q
+}
+
+func (q *Condition) Greater(field string, value any) *Condition {
+	q.entries = append(q.entries, findQueryOp{op: ">", field: field, value: value})
+	return q
+}
+
+func (q *Condition) GreaterEqual(field string, value any) *Condition {
+	q.entries = append(q.entries, findQueryOp{op: ">=", field: field, value: value})
+	return q
+}
+
+func (q *Condition) Between(field string, low any, high any) *Condition {
+	q.entries = append(q.entries, findQueryOp{op: "BETWEEN", field: field, value: []any{low, high}})
+	return q
+}
+
+func (q *Condition) In(field string, values ...any) *Condition {
+	q.entries = append(q.entries, findQueryOp{op: "IN", field: field, value: values})
+	return q
+}
+
+func (q *Condition) NotIn(field string, values ...any) *Condition {
+	q.entries = append(q.entries, findQueryOp{op: "NOT IN", field: field, value: values})
+	return q
+}
+
+func (q *Condition) IsNull(field string) *Condition {
+	q.entries = append(q.entries, findQueryOp{op: "IS NULL", field: field})
+	return q
+}
+
+func (q *Condition) IsNotNull(field string) *Condition {
+	q.entries = append(q.entries, findQueryOp{op: "IS NOT NULL", field: field})
+	return q
+}
+
 func (q *Condition) Like(field string, value string) *Condition {
-	q.entries = append(q.entries, findQueryOp{op: "LIKE", field: field, value: "%" + value + "%"})
+	q.entries = append(q.entries, findQueryOp{op: "LIKE", field: field, value: value, mode: MatchContains})
+	return q
+}
+
+func (q *Condition) LikePattern(field string, pattern string) *Condition {
+	q.entries = append(q.entries, findQueryOp{op: "LIKE", field: field, value: pattern, mode: MatchPattern})
 	return q
 }
 
 func (q *Condition) ILike(field string, value string) *Condition {
-	q.entries = append(q.entries, findQueryOp{op: "ILIKE", field: field, value: "%" + value + "%"})
+	q.entries = append(q.entries, findQueryOp{op: "ILIKE", field: field, value: value, mode: MatchContains})
+	return q
+}
+
+func (q *Condition) ILikePattern(field string, pattern string) *Condition {
+	q.entries = append(q.entries, findQueryOp{op: "ILIKE", field: field, value: pattern, mode: MatchPattern})
 	return q
 }
 
@@ -154,18 +334,20 @@ func (q *Condition) UnmarshalJSON(data []byte) error {
 	}
 	q.op = aux.Operator
 	q.entries = []any{}
-	for _, e := range aux.Entries {
+	for index, e := range aux.Entries {
+		path := joinPath(q.path, fmt.Sprintf("e[%d]", index))
 		ev := &struct {
 			Operator string            `json:"o"`
 			Field    string            `json:"f"`
 			Value    any               `json:"v"`
+			Mode     string            `json:"m"`
 			Entries  []json.RawMessage `json:"e"`
 		}{}
 		if err := json.Unmarshal(e, &ev); err != nil {
 			return err
 		}
 		if ev.Entries != nil {
-			val := Condition{}
+			val := Condition{schema: q.schema, path: path}
 			if err := json.Unmarshal(e, &val); err != nil {
 				return err
 			}
@@ -179,43 +361,115 @@ func (q *Condition) UnmarshalJSON(data []byte) error {
 				return fmt.Errorf("UNSUPPORTED EXPRESSION %v: %#v", val.op, val)
 			}
 		} else {
-			if ev.Operator == "=" {
+			switch ev.Operator {
+			case "=", "!=", "<", "<=", ">", ">=":
 				switch vt := ev.Value.(type) {
-				case string, float64:
+				case string, float64, bool:
 					q.entries = append(q.entries, findQueryOp{op: ev.Operator, field: ev.Field, value: vt})
 				default:
 					return fmt.Errorf("UNSUPPORTED TYPE VALUE %v: %#v", vt, ev)
 				}
-			} else if ev.Operator == "LIKE" || ev.Operator == "ILIKE" {
+			case "LIKE", "ILIKE":
+				switch ev.Mode {
+				case MatchExact, MatchContains, MatchPrefix, MatchSuffix, MatchPattern:
+				default:
+					return fmt.Errorf("UNSUPPORTED MATCH MODE %v: %#v", ev.Mode, ev)
+				}
 				switch vt := ev.Value.(type) {
 				case string:
-					q.entries = append(q.entries, findQueryOp{op: ev.Operator, field: ev.Field, value: vt})
+					q.entries = append(q.entries, findQueryOp{op: ev.Operator, field: ev.Field, value: vt, mode: ev.Mode})
 				default:
 					return fmt.Errorf("UNSUPPORTED TYPE VALUE %v: %#v", vt, ev)
 				}
-			} else {
+			case "IN", "NOT IN", "BETWEEN":
+				vs, ok := ev.Value.([]any)
+				if !ok {
+					return fmt.Errorf("UNSUPPORTED TYPE VALUE %v: %#v", ev.Value, ev)
+				} else if ev.Operator == "BETWEEN" && len(vs) != 2 {
+					return fmt.Errorf("INVALID VALUE COUNT %v FOR BETWEEN: %#v", len(vs), ev)
+				} else if len(vs) == 0 {
+					return fmt.Errorf("INVALID VALUE COUNT 0 FOR %v: %#v", ev.Operator, ev)
+				}
+				for _, v := range vs {
+					switch vt := v.(type) {
+					case string, float64, bool:
+					default:
+						return fmt.Errorf("UNSUPPORTED TYPE VALUE %v: %#v", vt, ev)
+					}
+				}
+				q.entries = append(q.entries, findQueryOp{op: ev.Operator, field: ev.Field, value: vs})
+			case "IS NULL", "IS NOT NULL":
+				if ev.Value != nil {
+					return fmt.Errorf("UNSUPPORTED TYPE VALUE %v: %#v", ev.Value, ev)
+				}
+				q.entries = append(q.entries, findQueryOp{op: ev.Operator, field: ev.Field})
+			default:
 				return fmt.Errorf("UNSUPPORTED EXPRESSION %v: %#v", ev.Operator, ev)
 			}
+			if q.schema != nil {
+				qe := &ValidationError{Message: "INVALID QUERY"}
+				op := q.entries[len(q.entries)-1].(findQueryOp)
+				if q.schema.checkFilter(qe, path, &op); len(qe.Errors) > 0 {
+					return qe
+				}
+				q.entries[len(q.entries)-1] = op
+			}
 		}
 	}
 	// q.entries = aux.Entries
 	return nil
 }
 
-func (q *findQueryOp) Apply(where string, params []any) (string, []any) {
-	where += q.field + " " + q.op + " ?"
-	params = append(params, q.value)
+func (q *findQueryOp) ApplyDialect(d Dialect, where string, params []any) (string, []any) {
+	column := q.column
+	if column == "" {
+		column = q.field
+	}
+	switch q.op {
+	case "IS NULL", "IS NOT NULL":
+		where += column + " " + q.op
+	case "BETWEEN":
+		vs := q.value.([]any)
+		where += column + " BETWEEN ? AND ?"
+		params = append(params, vs[0], vs[1])
+	case "LIKE", "ILIKE":
+		sql, param := d.Like(column, q.pattern(), q.op == "ILIKE")
+		where += sql
+		params = append(params, param)
+	default:
+		where += column + " " + q.op + " ?"
+		params = append(params, q.value)
+	}
 	return where, params
 }
 
+// pattern is the LIKE pattern of the value, escaping the wildcards of the
+// literal match modes.
+func (q *findQueryOp) pattern() string {
+	s, _ := q.value.(string)
+	switch q.mode {
+	case MatchPattern:
+		return s
+	case MatchContains:
+		return "%" + EscapeLike(s) + "%"
+	case MatchPrefix:
+		return EscapeLike(s) + "%"
+	case MatchSuffix:
+		return "%" + EscapeLike(s)
+	}
+	return EscapeLike(s)
+}
+
 func (q *findQueryOp) MarshalJSON() ([]byte, error) {
 	return json.Marshal(&struct {
 		Operator string `json:"o"`
 		Field    string `json:"f"`
-		Value    any    `json:"v"`
+		Value    any    `json:"v,omitempty"`
+		Mode     string `json:"m,omitempty"`
 	}{
 		Operator: q.op,
 		Field:    q.field,
 		Value:    q.value,
+		Mode:     q.mode,
 	})
 }
@@ -183,3 +183,129 @@ func TestBook_Fail_Not(t *testing.T) {
 	value := NewCondition()
 	assert.ErrorContains(t, json.Unmarshal(bytes, &value), "UNSUPPORTED EXPRESSION NOT")
 }
+
+func TestBook_Operators(t *testing.T) {
+	query := NewQuery(nil, NewCondition().
+		NotEqual("author", "Lord Voldermort").
+		Greater("id", 10).
+		GreaterEqual("id", 11).
+		Less("id", 100).
+		LessEqual("id", 99).
+		Between("id", 20, 30).
+		In("author", "J. K. Rawling", "Herge").
+		NotIn("title", "Tintin in Tibet").
+		IsNull("summary").
+		IsNotNull("title"), nil)
+
+	var bytes []byte
+	if bb, err := json.MarshalIndent(query, "", "  "); err != nil {
+		log.Fatal(err)
+	} else {
+		bytes = bb
+	}
+
+	value := NewQuery(nil, nil, nil)
+	if err := json.Unmarshal(bytes, &value); err != nil {
+		log.Fatal(err)
+	}
+
+	assert.Equal(t, test_lib.Marshal(t, query), test_lib.Marshal(t, value))
+
+	where, params := value.Condition.Apply("", []any{})
+	assert.Equal(t, "author != ? AND id > ? AND id >= ? AND id < ? AND id <= ? AND id BETWEEN ? AND ? AND author IN ? AND title NOT IN ? AND summary IS NULL AND title IS NOT NULL", where, string(bytes))
+	assert.Equal(t, test_lib.Marshal(t, []any{"Lord Voldermort", 10, 11, 100, 99, 20, 30, []any{"J. K. Rawling", "Herge"}, []any{"Tintin in Tibet"}}), test_lib.Marshal(t, params))
+}
+
+func TestBook_Fail_InNotArray(t *testing.T) {
+	bytes := []byte(`{
+		"o": "AND",
+		"e": [
+			{
+				"o": "IN",
+				"f": "author",
+				"v": "Herge"
+			}
+		]
+	}`)
+
+	value := NewCondition()
+	assert.ErrorContains(t, json.Unmarshal(bytes, &value), "UNSUPPORTED TYPE VALUE Herge")
+}
+
+func TestBook_Fail_InEmpty(t *testing.T) {
+	bytes := []byte(`{
+		"o": "AND",
+		"e": [
+			{
+				"o": "NOT IN",
+				"f": "author",
+				"v": []
+			}
+		]
+	}`)
+
+	value := NewCondition()
+	assert.ErrorContains(t, json.Unmarshal(bytes, &value), "INVALID VALUE COUNT 0 FOR NOT IN")
+}
+
+func TestBook_Fail_BetweenCount(t *testing.T) {
+	bytes := []byte(`{
+		"o": "AND",
+		"e": [
+			{
+				"o": "BETWEEN",
+				"f": "id",
+				"v": [1, 2, 3]
+			}
+		]
+	}`)
+
+	value := NewCondition()
+	assert.ErrorContains(t, json.Unmarshal(bytes, &value), "INVALID VALUE COUNT 3 FOR BETWEEN")
+}
+
+func TestBook_Fail_IsNullValue(t *testing.T) {
+	bytes := []byte(`{
+		"o": "AND",
+		"e": [
+			{
+				"o": "IS NULL",
+				"f": "summary",
+				"v": "x"
+			}
+		]
+	}`)
+
+	value := NewCondition()
+	assert.ErrorContains(t, json.Unmarshal(bytes, &value), "UNSUPPORTED TYPE VALUE x")
+}
+
+func TestBook_OrderBy(t *testing.T) {
+	value := NewQuery(nil, nil, nil)
+	assert.NoError(t, json.Unmarshal([]byte(`{
+		"orderBy": [
+			{ "f": "author", "nulls": "last" },
+			{ "f": "title", "desc": true }
+		]
+	}`), &value))
+	assert.Equal(t, []QueryOrderBy{Asc("author").NullsLast(), Desc("title")}, value.OrderBy.Keys)
+
+	query := NewQuery(nil, nil, nil).SortBy(Asc("author").NullsLast(), Desc("title"))
+	bytes, err := json.Marshal(query.OrderBy)
+	assert.NoError(t, err)
+	assert.Equal(t, `[{"f":"author","desc":false,"nulls":"last"},{"f":"title","desc":true}]`, string(bytes))
+}
+
+func TestBook_OrderBy_Single(t *testing.T) {
+	value := NewQuery(nil, nil, nil)
+	assert.NoError(t, json.Unmarshal([]byte(`{ "orderBy": { "f": "title", "desc": true } }`), &value))
+	assert.Equal(t, []QueryOrderBy{Desc("title")}, value.OrderBy.Keys)
+	bytes, err := json.Marshal(value.OrderBy)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"f":"title","desc":true}`, string(bytes))
+
+	assert.NoError(t, json.Unmarshal([]byte(`{ "orderBy": { "f": "" } }`), &value))
+	assert.Empty(t, value.OrderBy.Keys)
+
+	assert.ErrorContains(t, json.Unmarshal([]byte(`{ "orderBy": "title" }`), &value), "INVALID ORDER BY")
+}
@@ -0,0 +1,439 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// Page is one page of Finds results. Count is nil when the count was
+// skipped and Estimated when it comes from the database statistics. Next and
+// Prev are the cursors of the adjacent pages in keyset pagination.
+type Page[T any] struct {
+	Count     *int64 `json:"count,omitempty"`
+	Estimated bool   `json:"estimated,omitempty"`
+	Data      []T    `json:"data"`
+	Next      string `json:"next,omitempty"`
+	Prev      string `json:"prev,omitempty"`
+}
+
+// Repository gives typed CRUD access to model T. RequireIfMatch makes Update
+// and Delete refuse requests without an If-Match precondition. Frontends are
+// the query syntaxes accepted by Finds, DefaultQueryFrontends when nil.
+// UpsertKey are the ON CONFLICT columns of Upsert, the first unique index of
+// T when nil. Audit records a Revision of every write, see History. Outbox
+// writes an OutboxEvent of every write for an OutboxRelay to publish.
+type Repository[T any] struct {
+	DB             *DatabaseModel
+	Schema         *Schema
+	RequireIfMatch bool
+	Frontends      []QueryFrontend
+	UpsertKey      []string
+	Audit          bool
+	Outbox         bool
+}
+
+func NewRepository[T any](db *DatabaseModel) *Repository[T] {
+	var model T
+	RegisterConstraints(&model)
+	return &Repository[T]{DB: db, Schema: SchemaOf(&model)}
+}
+
+func (r *Repository[T]) db() *DatabaseModel {
+	return r.DB.orDefault()
+}
+
+func (r *Repository[T]) tx(ctx context.Context) *gorm.DB {
+	return r.db().DB.WithContext(ctx)
+}
+
+func (r *Repository[T]) Finds(ctx context.Context, query *Query, offset int, limit int) (Page[T], error) {
+	return r.Paginate(ctx, query, PageOptions{Offset: offset, Limit: limit})
+}
+
+// Paginate returns the page of query selected by opts. Keyset pagination
+// orders by the OrderBy keys followed by the primary key, their columns are
+// added to the selected ones. Offset pages of a query without OrderBy are
+// ordered by the primary key.
+func (r *Repository[T]) Paginate(ctx context.Context, query *Query, opts PageOptions) (Page[T], error) {
+	page := Page[T]{Data: []T{}}
+	if query == nil {
+		query = NewQuery(nil, nil, nil)
+	}
+	if err := r.Schema.ValidateQuery(query); err != nil {
+		return page, err
+	}
+	if opts.Keyset && opts.Offset > 0 {
+		return page, &BadRequestError{Err: errors.New("Offset can not be combined with cursor")}
+	}
+
+	var model T
+	tx, err := r.scopeDeleted(r.tx(ctx).Model(&model), query)
+	if err != nil {
+		return page, err
+	}
+	where, params := query.Condition.ApplyDialect(r.db().SQLDialect(), "", []any{})
+	tx.Where(where, params...)
+
+	var keys []keysetKey
+	cursor := &pageCursor{}
+	if opts.Keyset {
+		var err error
+		if keys, err = r.keysetKeys(query); err != nil {
+			return page, err
+		}
+		if opts.Cursor != "" {
+			if cursor, err = r.db().decodeCursor(opts.Cursor, keys); err != nil {
+				return page, err
+			}
+		}
+		for _, k := range keys {
+			tx.Order(clause.OrderByColumn{Column: clause.Column{Name: k.field.DBName}, Desc: k.desc != cursor.Prev})
+		}
+	} else if len(query.OrderBy.Keys) > 0 {
+		r.orderBy(tx, query)
+	} else if s, err := r.gormSchema(); err != nil {
+		return page, err
+	} else if pk := s.PrioritizedPrimaryField; pk != nil {
+		// offset pages are only stable in a defined order
+		tx.Order(clause.OrderByColumn{Column: clause.Column{Name: pk.DBName}})
+	}
+
+	if err := r.count(ctx, tx, where, opts.Count, &page); err != nil {
+		return page, err
+	}
+
+	if query.Select != nil {
+		columns := []string{}
+		for _, name := range query.Select {
+			columns = append(columns, r.Schema.Column(name))
+		}
+		for _, k := range keys {
+			if !containsString(columns, k.field.DBName) {
+				columns = append(columns, k.field.DBName)
+			}
+		}
+		tx = tx.Select(columns)
+	}
+	if cursor.values != nil {
+		kw, kp := keysetWhere(keys, cursor)
+		tx = tx.Where(kw, kp...)
+	}
+	if opts.Offset > 0 {
+		tx = tx.Offset(opts.Offset)
+	}
+	if opts.Limit > 0 && opts.Keyset {
+		// one extra row tells whether there is a further page
+		tx = tx.Limit(opts.Limit + 1)
+	} else if opts.Limit > 0 {
+		tx = tx.Limit(opts.Limit)
+	}
+
+	if err := tx.Find(&page.Data).Error; err != nil {
+		return page, r.db().TranslateError(err)
+	}
+	if opts.Keyset {
+		return page, r.keysetPage(&page, keys, cursor, opts.Limit)
+	}
+	return page, nil
+}
+
+// orderBy adds the OrderBy keys of query to tx.
+func (r *Repository[T]) orderBy(tx *gorm.DB, query *Query) {
+	for _, key := range query.OrderBy.Keys {
+		for _, column := range r.db().orderColumns(tx, r.Schema.Column(key.Field), key.Desc, key.Nulls) {
+			tx.Order(column)
+		}
+	}
+}
+
+func (r *Repository[T]) count(ctx context.Context, tx *gorm.DB, where string, mode CountMode, page *Page[T]) error {
+	if mode == CountNone {
+		return nil
+	}
+	var count int64
+	if mode == CountEstimate && where == "" {
+		if s, err := r.gormSchema(); err != nil {
+			return err
+		} else if estimate, ok := r.db().EstimateCount(ctx, s.Table); ok {
+			page.Count, page.Estimated = &estimate, true
+			return nil
+		}
+	}
+	if err := tx.Count(&count).Error; err != nil {
+		return r.db().TranslateError(err)
+	}
+	page.Count = &count
+	return nil
+}
+
+// keysetPage trims the extra row, restores the order of a prev page and sets
+// the cursors of the adjacent pages.
+func (r *Repository[T]) keysetPage(page *Page[T], keys []keysetKey, cursor *pageCursor, limit int) error {
+	more := limit > 0 && len(page.Data) > limit
+	if more {
+		page.Data = page.Data[:limit]
+	}
+	if cursor.Prev {
+		for i, j := 0, len(page.Data)-1; i < j; i, j = i+1, j-1 {
+			page.Data[i], page.Data[j] = page.Data[j], page.Data[i]
+		}
+	}
+	if len(page.Data) == 0 {
+		return nil
+	}
+	db := r.db()
+	var err error
+	if more || cursor.Prev {
+		if page.Next, err = db.encodeCursor(reflect.ValueOf(page.Data[len(page.Data)-1]), keys, false); err != nil {
+			return err
+		}
+	}
+	if (more && cursor.Prev) || (cursor.values != nil && !cursor.Prev) {
+		if page.Prev, err = db.encodeCursor(reflect.ValueOf(page.Data[0]), keys, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Repository[T]) Find(ctx context.Context, id any) (T, error) {
+	var data T
+	if err := r.tx(ctx).Where("id = ?", id).First(&data).Error; err != nil {
+		return data, r.db().TranslateError(err)
+	}
+	return data, nil
+}
+
+// Create stores data, starting the version column at 1.
+func (r *Repository[T]) Create(ctx context.Context, data T) (T, error) {
+	if field, err := r.versionField(); err != nil {
+		return data, err
+	} else if field != nil {
+		if v := reflect.ValueOf(&data).Elem().FieldByIndex(field.StructField.Index); v.IsZero() {
+			bumpVersion(v)
+		}
+	}
+	err := r.write(ctx, func(tx *gorm.DB) error {
+		if err := tx.Create(&data).Error; err != nil {
+			return r.db().TranslateError(err)
+		}
+		if err := r.indexSearch(tx, &data); err != nil {
+			return err
+		}
+		return r.changed(tx, AuditCreate, nil, &data)
+	})
+	return data, err
+}
+
+// write runs fn in a transaction when the write also updates the search
+// index, the revisions or the outbox, and on the plain connection otherwise.
+// A repository already bound to a transaction, see withTx, runs fn in it.
+func (r *Repository[T]) write(ctx context.Context, fn func(tx *gorm.DB) error) error {
+	tx := r.tx(ctx)
+	if _, ok := tx.Statement.ConnPool.(gorm.TxCommitter); ok || (!r.searchable() && !r.Audit && !r.Outbox) {
+		return fn(tx)
+	}
+	return tx.Transaction(fn)
+}
+
+// changed records a write in tx as a revision and an outbox event, before is
+// nil for a create and after for a hard delete.
+func (r *Repository[T]) changed(tx *gorm.DB, action string, before *T, after *T) error {
+	if err := r.audit(tx, action, before, after); err != nil {
+		return err
+	}
+	return r.enqueue(tx, action, before, after)
+}
+
+// Update loads the record identified by id, lets apply modify it and writes
+// back the columns that changed, including the ones cleared to zero. A non
+// empty ifMatch must match the ETag of the stored record, also when writing:
+// versioned models bump their version and only write when it is unchanged in
+// the database, the others only write when the record still holds the values
+// the ETag was computed from.
+func (r *Repository[T]) Update(ctx context.Context, id any, ifMatch string, apply func(*T) error) (T, error) {
+	data, err := r.Find(ctx, id)
+	if err != nil {
+		return data, err
+	}
+	if err := r.checkMatch(data, ifMatch); err != nil {
+		return data, err
+	}
+	before := data
+	if err := apply(&data); err != nil {
+		return data, err
+	}
+
+	columns, err := r.changedColumns(ctx, &before, &data)
+	if err != nil {
+		return data, err
+	} else if len(columns) == 0 {
+		return data, nil
+	}
+
+	field, err := r.versionField()
+	if err != nil {
+		return data, err
+	} else if field == nil {
+		err := r.write(ctx, func(tx *gorm.DB) error {
+			res := tx.Model(&data)
+			if ifMatch != "" {
+				// the ETag checked above must still hold when writing
+				unchanged, err := r.unchanged(ctx, &before)
+				if err != nil {
+					return err
+				}
+				res = res.Where(unchanged)
+			}
+			if res = res.Select(columns).Updates(&data); res.Error != nil {
+				return r.db().TranslateError(res.Error)
+			} else if ifMatch != "" && res.RowsAffected == 0 {
+				return &PreconditionFailedError{Err: fmt.Errorf("ETag mismatch")}
+			}
+			if err := r.indexSearch(tx, &data); err != nil {
+				return err
+			}
+			return r.changed(tx, AuditUpdate, &before, &data)
+		})
+		return data, err
+	}
+
+	version, _ := field.ValueOf(ctx, reflect.ValueOf(&data))
+	bumpVersion(reflect.ValueOf(&data).Elem().FieldByIndex(field.StructField.Index))
+	err = r.write(ctx, func(tx *gorm.DB) error {
+		res := tx.Model(&data).Where(clause.Eq{Column: clause.Column{Name: field.DBName}, Value: version}).
+			Select(append(columns, field.DBName)).Updates(&data)
+		if res.Error != nil {
+			return r.db().TranslateError(res.Error)
+		} else if res.RowsAffected == 0 {
+			return &PreconditionFailedError{Err: fmt.Errorf("Version %v is outdated", version)}
+		}
+		if err := r.indexSearch(tx, &data); err != nil {
+			return err
+		}
+		return r.changed(tx, AuditUpdate, &before, &data)
+	})
+	return data, err
+}
+
+func (r *Repository[T]) changedColumns(ctx context.Context, before *T, after *T) ([]string, error) {
+	s, err := r.gormSchema()
+	if err != nil {
+		return nil, err
+	}
+	columns := []string{}
+	for _, field := range s.Fields {
+		if field.DBName == "" {
+			continue
+		}
+		bv, _ := field.ValueOf(ctx, reflect.ValueOf(before))
+		av, _ := field.ValueOf(ctx, reflect.ValueOf(after))
+		if reflect.DeepEqual(bv, av) {
+			continue
+		}
+		// deleting goes through Delete, restoring through Restore
+		if field.PrimaryKey || isVersionField(field) || field.FieldType == deletedAtType {
+			return nil, &ValidationError{Message: "INVALID UPDATE", Errors: []FieldError{{Path: field.DBName, Field: field.DBName, Message: "READ ONLY"}}}
+		}
+		columns = append(columns, field.DBName)
+	}
+	return columns, nil
+}
+
+// Delete removes the record identified by id, ifMatch is checked as in
+// Update. Soft deleting a record of a versioned model bumps its version.
+func (r *Repository[T]) Delete(ctx context.Context, id any, ifMatch string) (T, error) {
+	var data T
+	if tx := r.tx(ctx).Where("id = ?", id).First(&data); tx.Error != nil {
+		return data, r.db().TranslateError(tx.Error)
+	} else if tx.RowsAffected != 1 {
+		return data, fmt.Errorf("Invalid RowsAffected %v", tx.RowsAffected)
+	}
+	if err := r.checkMatch(data, ifMatch); err != nil {
+		return data, err
+	}
+
+	field, err := r.versionField()
+	if err != nil {
+		return data, err
+	}
+	soft, err := r.deletedField()
+	if err != nil {
+		return data, err
+	}
+	before := data
+	err = r.write(ctx, func(tx *gorm.DB) error {
+		res := tx
+		if field != nil {
+			version, _ := field.ValueOf(ctx, reflect.ValueOf(&data))
+			res = res.Where(clause.Eq{Column: clause.Column{Name: field.DBName}, Value: version})
+		} else if ifMatch != "" {
+			unchanged, err := r.unchanged(ctx, &data)
+			if err != nil {
+				return err
+			}
+			res = res.Where(unchanged)
+		}
+		if soft != nil && field != nil {
+			// a soft delete is a change of the record, it bumps the version
+			reflect.ValueOf(&data).Elem().FieldByIndex(soft.StructField.Index).Set(reflect.ValueOf(gorm.DeletedAt{Time: tx.NowFunc(), Valid: true}))
+			bumpVersion(reflect.ValueOf(&data).Elem().FieldByIndex(field.StructField.Index))
+			res = res.Model(&data).Select(soft.DBName, field.DBName).Updates(&data)
+		} else {
+			res = res.Delete(&data)
+		}
+		if res.Error != nil {
+			return r.db().TranslateError(res.Error)
+		} else if res.RowsAffected == 0 {
+			return &PreconditionFailedError{Err: fmt.Errorf("Record %v was modified", id)}
+		}
+		if err := r.removeSearch(tx, &data); err != nil {
+			return err
+		}
+		// a soft deleted record is still there, with its deleted time
+		after := &data
+		if soft == nil {
+			after = nil
+		}
+		return r.changed(tx, AuditDelete, &before, after)
+	})
+	return data, err
+}
+
+// PrimaryKey returns the primary key value of data.
+func (r *Repository[T]) PrimaryKey(ctx context.Context, data T) (any, error) {
+	s, err := r.gormSchema()
+	if err != nil {
+		return nil, err
+	}
+	field := s.PrioritizedPrimaryField
+	if field == nil {
+		return nil, fmt.Errorf("No primary key %s", s.Name)
+	}
+	value, _ := field.ValueOf(ctx, reflect.ValueOf(&data))
+	return value, nil
+}
+
+func (r *Repository[T]) gormSchema() (*schema.Schema, error) {
+	var model T
+	stmt := &gorm.Statement{DB: r.db().DB}
+	if err := stmt.Parse(&model); err != nil {
+		return nil, err
+	}
+	return stmt.Schema, nil
+}
@@ -0,0 +1,246 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	test_lib "github.com/senomas/go-api/test/lib"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestRepository(t *testing.T) *Repository[Book] {
+	db, err := gorm.Open(sqlite.Open("file:repository?mode=memory"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal("Init GORM Error", err)
+	}
+	if err := db.AutoMigrate(&Book{}); err != nil {
+		t.Fatal("AutoMigrate Error", err)
+	}
+	if err := MigrateSearch(db, &Book{}); err != nil {
+		t.Fatal("MigrateSearch Error", err)
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.SetMaxOpenConns(1)
+	}
+	return NewRepository[Book](NewDatabaseModel(db))
+}
+
+func TestRepository_CRUD(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+
+	book, err := repo.Create(ctx, Book{Title: "Tintin in Tibet", Author: "Herge"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), book.ID)
+
+	_, err = repo.Create(ctx, Book{Title: "Tintin in Jakarta", Author: "Herge"})
+	assert.NoError(t, err)
+
+	book, err = repo.Update(ctx, 2, "", func(book *Book) error {
+		book.Title = "Tintin in America"
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "Tintin in America", book.Title)
+
+	book, err = repo.Find(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "Tintin in America", book.Title)
+
+	page, err := repo.Finds(ctx, NewQuery(Fields("id", "title"), NewCondition().Like("title", "America"), nil), 0, 10)
+	assert.NoError(t, err)
+	count := int64(1)
+	assert.Equal(t, test_lib.Marshal(t, Page[Book]{Count: &count, Data: []Book{{ID: 2, Title: "Tintin in America"}}}), test_lib.Marshal(t, page))
+
+	_, err = repo.Delete(ctx, 1, "")
+	assert.NoError(t, err)
+
+	page, err = repo.Finds(ctx, nil, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), *page.Count)
+}
+
+func TestRepository_Errors(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+
+	var notFound *NotFoundError
+	_, err := repo.Find(ctx, 9999)
+	assert.True(t, errors.As(err, &notFound), err)
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound), err)
+
+	_, err = repo.Delete(ctx, 9999, "")
+	assert.True(t, errors.As(err, &notFound), err)
+
+	var constraint *ConstraintError
+	_, err = repo.Create(ctx, Book{Title: "Tintin in Tibet", Author: "Herge"})
+	assert.NoError(t, err)
+	_, err = repo.Create(ctx, Book{Title: "Tintin in Tibet", Author: "Herge"})
+	assert.True(t, errors.As(err, &constraint), err)
+	assert.Equal(t, "title", constraint.Constraint.Field)
+	assert.Equal(t, "Duplicate value books.title", err.Error())
+
+	var validation *ValidationError
+	_, err = repo.Finds(ctx, NewQuery(nil, NewCondition().Equal("password", "secret"), nil), 0, 0)
+	assert.True(t, errors.As(err, &validation), err)
+}
+
+type VersionedBook struct {
+	ID      uint   `json:"id,omitempty" gorm:"primary_key"`
+	Title   string `json:"title,omitempty"`
+	Version int    `json:"version,omitempty" gorm:"version"`
+}
+
+func TestRepository_Version(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open("file:repository_version?mode=memory"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal("Init GORM Error", err)
+	}
+	if err := db.AutoMigrate(&VersionedBook{}); err != nil {
+		t.Fatal("AutoMigrate Error", err)
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.SetMaxOpenConns(1)
+	}
+	repo := NewRepository[VersionedBook](NewDatabaseModel(db))
+
+	book, err := repo.Create(ctx, VersionedBook{Title: "Tintin in Tibet"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, book.Version)
+	assert.Equal(t, `"1"`, repo.ETag(book))
+
+	book, err = repo.Update(ctx, 1, `"1"`, func(book *VersionedBook) error {
+		book.Title = "Tintin in America"
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, book.Version)
+
+	var preconditionFailed *PreconditionFailedError
+	_, err = repo.Update(ctx, 1, `"1"`, func(book *VersionedBook) error {
+		book.Title = "Tintin in Congo"
+		return nil
+	})
+	assert.True(t, errors.As(err, &preconditionFailed), err)
+
+	_, err = repo.Update(ctx, 1, "", func(book *VersionedBook) error {
+		// a concurrent writer bumps the version between read and write
+		db.Model(&VersionedBook{}).Where("id = ?", 1).Update("version", 3)
+		book.Title = "Tintin in Congo"
+		return nil
+	})
+	assert.True(t, errors.As(err, &preconditionFailed), err)
+
+	book, err = repo.Find(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, VersionedBook{ID: 1, Title: "Tintin in America", Version: 3}, book)
+
+	var validation *ValidationError
+	_, err = repo.Update(ctx, 1, "", func(book *VersionedBook) error {
+		book.Version = 9
+		return nil
+	})
+	assert.True(t, errors.As(err, &validation), err)
+
+	repo.RequireIfMatch = true
+	var preconditionRequired *PreconditionRequiredError
+	_, err = repo.Delete(ctx, 1, "")
+	assert.True(t, errors.As(err, &preconditionRequired), err)
+	_, err = repo.Delete(ctx, 1, `"2"`)
+	assert.True(t, errors.As(err, &preconditionFailed), err)
+	_, err = repo.Delete(ctx, 1, `"3"`)
+	assert.NoError(t, err)
+}
+
+func TestRepository_UpdateStaleETag(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	book, err := repo.Create(ctx, Book{Title: "Tintin in Tibet", Author: "Herge"})
+	assert.NoError(t, err)
+	etag := repo.ETag(book)
+
+	// both updates pass the ETag check, the second one to write loses
+	var preconditionFailed *PreconditionFailedError
+	_, err = repo.Update(ctx, 1, etag, func(book *Book) error {
+		_, err := repo.Update(ctx, 1, etag, func(book *Book) error {
+			book.Summary = "Tintin goes to Tibet"
+			return nil
+		})
+		assert.NoError(t, err)
+		book.Author = "Hergé"
+		return nil
+	})
+	assert.True(t, errors.As(err, &preconditionFailed), err)
+	book, err = repo.Find(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, Book{ID: 1, Title: "Tintin in Tibet", Author: "Herge", Summary: "Tintin goes to Tibet"}, book)
+
+	_, err = repo.Delete(ctx, 1, etag)
+	assert.True(t, errors.As(err, &preconditionFailed), err)
+	_, err = repo.Update(ctx, 1, repo.ETag(book), func(book *Book) error {
+		book.Author = "Hergé"
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestMatchETag(t *testing.T) {
+	assert.True(t, MatchETag(`"1"`, `"1"`, false))
+	assert.True(t, MatchETag(`"0", "1"`, `"1"`, false))
+	assert.True(t, MatchETag(`*`, `"1"`, false))
+	assert.False(t, MatchETag(`W/"1"`, `"1"`, false))
+	assert.True(t, MatchETag(`W/"1"`, `"1"`, true))
+	assert.False(t, MatchETag(`"2"`, `"1"`, true))
+}
+
+func TestRepository_Keyset(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	for _, title := range []string{"A", "C", "E", "B", "D"} {
+		_, err := repo.Create(ctx, Book{Title: title, Author: "Herge"})
+		assert.NoError(t, err)
+	}
+	titles := func(page Page[Book]) string {
+		s := ""
+		for _, b := range page.Data {
+			s += b.Title
+		}
+		return s
+	}
+	query := NewQuery(Fields("title"), nil, &QueryOrderBy{Field: "title", Desc: true})
+
+	page, err := repo.Paginate(ctx, query, PageOptions{Keyset: true, Limit: 2, Count: CountNone})
+	assert.NoError(t, err)
+	assert.Nil(t, page.Count)
+	assert.Equal(t, "ED", titles(page))
+	assert.Empty(t, page.Prev)
+
+	page, err = repo.Paginate(ctx, query, PageOptions{Keyset: true, Cursor: page.Next, Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), *page.Count)
+	assert.Equal(t, "CB", titles(page))
+
+	page, err = repo.Paginate(ctx, query, PageOptions{Keyset: true, Cursor: page.Next, Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, "A", titles(page))
+	assert.Empty(t, page.Next)
+
+	page, err = repo.Paginate(ctx, query, PageOptions{Keyset: true, Cursor: page.Prev, Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, "CB", titles(page))
+	assert.NotEmpty(t, page.Prev)
+	assert.NotEmpty(t, page.Next)
+
+	var badRequest *BadRequestError
+	_, err = repo.Paginate(ctx, NewQuery(nil, nil, nil), PageOptions{Keyset: true, Cursor: page.Next, Limit: 2})
+	assert.True(t, errors.As(err, &badRequest), err)
+
+	other := NewRepository[Book](&DatabaseModel{DB: repo.DB.DB, Translate: repo.DB.Translate, CursorKey: []byte("other")})
+	_, err = other.Paginate(ctx, query, PageOptions{Keyset: true, Cursor: page.Next, Limit: 2})
+	assert.True(t, errors.As(err, &badRequest), err)
+}
@@ -0,0 +1,274 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// RSQLFrontend reads an RSQL (FIQL) expression from the filter parameter,
+// e.g. author=="J. K. Rowling";(title==*Stone,id=in=(2,3)). Unquoted
+// arguments are typed after the schema field, * in the argument of == and !=
+// on string fields is a wildcard. It is selected with syntax=rsql.
+type RSQLFrontend struct{}
+
+func (RSQLFrontend) Name() string {
+	return "rsql"
+}
+
+func (RSQLFrontend) Parse(schema *Schema, params url.Values, query *Query, opts *PageOptions) error {
+	if str := params.Get("filter"); str != "" {
+		filter, err := ParseRSQL(schema, str)
+		if err != nil {
+			return err
+		}
+		query.Condition.and(filter)
+	}
+	return nil
+}
+
+var rsqlOperators = map[string]string{
+	"==": "=", "!=": "!=", "<": "<", "=lt=": "<", "<=": "<=", "=le=": "<=",
+	">": ">", "=gt=": ">", ">=": ">=", "=ge=": ">=", "=in=": "IN", "=out=": "NOT IN",
+	"=like=": "LIKE", "=isnull=": "IS NULL",
+}
+
+const rsqlReserved = "\"'();,=!~<> "
+
+type rsqlParser struct {
+	schema *Schema
+	input  string
+	pos    int
+}
+
+// ParseRSQL parses an RSQL expression into a Condition, the schema types the
+// arguments and may be nil.
+func ParseRSQL(schema *Schema, input string) (*Condition, error) {
+	p := &rsqlParser{schema: schema, input: input}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.input) {
+		return nil, p.errorf("expected ; or ,")
+	}
+	return rootCondition(e), nil
+}
+
+func (p *rsqlParser) errorf(format string, args ...any) error {
+	found := "end of filter"
+	if p.pos < len(p.input) {
+		found = strconv.Quote(p.input[p.pos : p.pos+1])
+	}
+	return &FilterError{Pos: p.pos, Message: fmt.Sprintf(format, args...) + ", found " + found}
+}
+
+func (p *rsqlParser) skipSpace() {
+	for p.pos < len(p.input) && p.input[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// accept consumes s when the input continues with it.
+func (p *rsqlParser) accept(s string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.input[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+func (p *rsqlParser) parseOr() (any, error) {
+	return p.parseList("OR", ",", p.parseAnd)
+}
+
+func (p *rsqlParser) parseAnd() (any, error) {
+	return p.parseList("AND", ";", p.parseConstraint)
+}
+
+func (p *rsqlParser) parseList(op string, sep string, parse func() (any, error)) (any, error) {
+	e, err := parse()
+	if err != nil {
+		return nil, err
+	}
+	entries := []any{e}
+	for p.accept(sep) {
+		if e, err = parse(); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return listCondition(op, entries), nil
+}
+
+func (p *rsqlParser) parseConstraint() (any, error) {
+	if p.accept("(") {
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, p.errorf("expected )")
+		}
+		return e, nil
+	}
+
+	p.skipSpace()
+	field := p.unreserved()
+	if field == "" {
+		return nil, p.errorf("expected selector")
+	}
+	p.skipSpace()
+	opPos := p.pos
+	operator := p.operator()
+	op, ok := rsqlOperators[operator]
+	if !ok {
+		p.pos = opPos
+		return nil, p.errorf("expected comparison operator")
+	}
+
+	var fieldType FieldType
+	if p.schema != nil {
+		if f, ok := p.schema.Fields[field]; ok {
+			fieldType = f.Type
+		}
+	}
+	p.skipSpace()
+	if op == "IN" || op == "NOT IN" {
+		if !p.accept("(") {
+			return nil, p.errorf("expected (")
+		}
+		values := []any{}
+		for {
+			v, err := p.argument(fieldType)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+			if p.accept(")") {
+				return findQueryOp{op: op, field: field, value: values}, nil
+			} else if !p.accept(",") {
+				return nil, p.errorf("expected , or )")
+			}
+		}
+	}
+
+	argPos := p.pos
+	switch op {
+	case "IS NULL":
+		v, err := p.argument(FieldBool)
+		if err != nil {
+			return nil, err
+		}
+		if v == false {
+			op = "IS NOT NULL"
+		}
+		return findQueryOp{op: op, field: field}, nil
+	case "LIKE":
+		v, err := p.argument(FieldString)
+		if err != nil {
+			return nil, err
+		}
+		return rsqlLike(field, v.(string)), nil
+	}
+
+	v, err := p.argument(fieldType)
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := v.(string); ok && fieldType == FieldString && (op == "=" || op == "!=") && strings.Contains(s, "*") && p.input[argPos] != '\'' && p.input[argPos] != '"' {
+		like := rsqlLike(field, s)
+		if op == "!=" {
+			return notCondition(like), nil
+		}
+		return like, nil
+	}
+	return findQueryOp{op: op, field: field, value: v}, nil
+}
+
+// rsqlLike matches the * wildcards of s, any other character literally.
+func rsqlLike(field string, s string) findQueryOp {
+	parts := strings.Split(s, "*")
+	if len(parts) == 2 && parts[0] == "" {
+		return findQueryOp{op: "LIKE", field: field, value: parts[1], mode: MatchSuffix}
+	} else if len(parts) == 2 && parts[1] == "" {
+		return findQueryOp{op: "LIKE", field: field, value: parts[0], mode: MatchPrefix}
+	} else if len(parts) == 3 && parts[0] == "" && parts[2] == "" {
+		return findQueryOp{op: "LIKE", field: field, value: parts[1], mode: MatchContains}
+	}
+	for i := range parts {
+		parts[i] = EscapeLike(parts[i])
+	}
+	return findQueryOp{op: "LIKE", field: field, value: strings.Join(parts, "%"), mode: MatchPattern}
+}
+
+// operator reads ==, !=, <, <=, >, >= or an =name= operator.
+func (p *rsqlParser) operator() string {
+	rest := p.input[p.pos:]
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if strings.HasPrefix(rest, op) {
+			p.pos += len(op)
+			return op
+		}
+	}
+	if strings.HasPrefix(rest, "=") {
+		if end := strings.IndexByte(rest[1:], '='); end >= 0 {
+			p.pos += end + 2
+			return rest[:end+2]
+		}
+	}
+	return ""
+}
+
+func (p *rsqlParser) unreserved() string {
+	start := p.pos
+	for p.pos < len(p.input) && !strings.ContainsRune(rsqlReserved, rune(p.input[p.pos])) {
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+// argument reads a quoted or unquoted argument, unquoted ones are converted
+// to the type of the field.
+func (p *rsqlParser) argument(fieldType FieldType) (any, error) {
+	p.skipSpace()
+	start := p.pos
+	if p.pos < len(p.input) && (p.input[p.pos] == '\'' || p.input[p.pos] == '"') {
+		quote := p.input[p.pos]
+		sb := strings.Builder{}
+		for p.pos++; p.pos < len(p.input) && p.input[p.pos] != quote; p.pos++ {
+			if p.input[p.pos] == '\\' && p.pos+1 < len(p.input) {
+				p.pos++
+			}
+			sb.WriteByte(p.input[p.pos])
+		}
+		if p.pos >= len(p.input) {
+			p.pos = start
+			return nil, &FilterError{Pos: start, Message: "unterminated string"}
+		}
+		p.pos++
+		return sb.String(), nil
+	}
+
+	s := p.unreserved()
+	if s == "" {
+		return nil, p.errorf("expected argument")
+	}
+	switch fieldType {
+	case FieldNumber:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, &FilterError{Pos: start, Message: "invalid number " + s}
+		}
+		return f, nil
+	case FieldBool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, &FilterError{Pos: start, Message: "invalid boolean " + s}
+		}
+		return b, nil
+	}
+	return s, nil
+}
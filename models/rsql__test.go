@@ -0,0 +1,55 @@
+package models
+
+import (
+	"errors"
+	"testing"
+
+	test_lib "github.com/senomas/go-api/test/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRSQL_Parse(t *testing.T) {
+	schema := SchemaOf(&Book{})
+	for filter, expected := range map[string]string{
+		`title=="Tintin in Tibet"`:                              `title = "Tintin in Tibet"`,
+		`id!=1;id<2;id=le=3;id>4;id=ge=5`:                       `id != 1 and id < 2 and id <= 3 and id > 4 and id >= 5`,
+		`title==*Potter*,title=like=Tin*`:                       `title ~ "Potter" or title like "Tin%"`,
+		`title!=*Stone;summary=isnull=true;author=isnull=false`: `not title like "%Stone" and summary is null and author is not null`,
+		`id=in=(1,4);(author=='O\'Brien',title=="*")`:           `id in (1, 4) and (author = "O'Brien" or title = "*")`,
+		`id=out=(2, 3) ; title==1984`:                           `id not in (2, 3) and title = "1984"`,
+	} {
+		condition, err := ParseRSQL(schema, filter)
+		if assert.NoError(t, err, filter) {
+			assert.Equal(t, expected, condition.String(), filter)
+		}
+	}
+
+	condition, err := ParseRSQL(schema, `author=="J. K. Rowling";(title==*Stone,id=in=(2,3))`)
+	assert.NoError(t, err)
+	expected := NewCondition().Equal("author", "J. K. Rowling").
+		Or(NewCondition().Like("title", "").In("id", 2.0, 3.0))
+	expected.entries[1].(Condition).entries[0] = findQueryOp{op: "LIKE", field: "title", value: "Stone", mode: MatchSuffix}
+	assert.Equal(t, test_lib.Marshal(t, expected), test_lib.Marshal(t, condition))
+}
+
+func TestRSQL_Errors(t *testing.T) {
+	schema := SchemaOf(&Book{})
+	for filter, expected := range map[string]FilterError{
+		`title==`:          {Pos: 7, Message: "expected argument, found end of filter"},
+		`title~"a"`:        {Pos: 5, Message: `expected comparison operator, found "~"`},
+		`==1`:              {Pos: 0, Message: `expected selector, found "="`},
+		`id==one`:          {Pos: 4, Message: "invalid number one"},
+		`id=in=1`:          {Pos: 6, Message: `expected (, found "1"`},
+		`(id==1`:           {Pos: 6, Message: "expected ), found end of filter"},
+		`title=="a`:        {Pos: 7, Message: "unterminated string"},
+		`id==1)`:           {Pos: 5, Message: `expected ; or ,, found ")"`},
+		`id=isnull=maybe`:  {Pos: 10, Message: "invalid boolean maybe"},
+		`id=between=(1,2)`: {Pos: 2, Message: `expected comparison operator, found "="`},
+	} {
+		_, err := ParseRSQL(schema, filter)
+		var fe *FilterError
+		if assert.True(t, errors.As(err, &fe), filter) {
+			assert.Equal(t, expected, *fe, filter)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package models
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm/schema"
+)
+
+type FieldType string
+
+const (
+	FieldString FieldType = "string"
+	FieldNumber FieldType = "number"
+	FieldBool   FieldType = "bool"
+)
+
+// SchemaField describes how a model field is exposed to the query DSL.
+// Name is the JSON name clients use, Column the database column it maps to.
+type SchemaField struct {
+	Name       string
+	Column     string
+	Type       FieldType
+	Filterable bool
+	Sortable   bool
+}
+
+// Schema is the query DSL view of a model. Names lists the fields in
+// declaration order, Search the fields of the full text index in rank order,
+// see SearchIndex.
+type Schema struct {
+	Fields map[string]*SchemaField
+	Names  []string
+	Search []string
+}
+
+var schemas sync.Map
+
+// ParseSchema derives a Schema from the struct tags of model. The field name
+// comes from the json tag, the column from the gorm tag (or gorm's default
+// naming), and the optional query tag accepts "-", "nofilter", "nosort" and
+// "search", which adds a string field to the full text index.
+func ParseSchema(model any) *Schema {
+	s := &Schema{Fields: map[string]*SchemaField{}}
+	s.parse(modelType(model))
+	return s
+}
+
+// RegisterSchema overrides the schema derived from the struct tags of model.
+func RegisterSchema(model any, s *Schema) {
+	schemas.Store(modelType(model), s)
+}
+
+func SchemaOf(model any) *Schema {
+	t := modelType(model)
+	if s, ok := schemas.Load(t); ok {
+		return s.(*Schema)
+	}
+	s, _ := schemas.LoadOrStore(t, ParseSchema(model))
+	return s.(*Schema)
+}
+
+func modelType(model any) reflect.Type {
+	t := reflect.TypeOf(model)
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	return t
+}
+
+func (s *Schema) parse(t reflect.Type) {
+	naming := schema.NamingStrategy{}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			s.parse(sf.Type)
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		name := strings.Split(sf.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		} else if name == "" {
+			name = sf.Name
+		}
+		ft := sf.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		var fieldType FieldType
+		switch ft.Kind() {
+		case reflect.String:
+			fieldType = FieldString
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			fieldType = FieldNumber
+		case reflect.Bool:
+			fieldType = FieldBool
+		default:
+			continue
+		}
+		column := schema.ParseTagSetting(sf.Tag.Get("gorm"), ";")["COLUMN"]
+		if column == "" {
+			column = naming.ColumnName("", sf.Name)
+		}
+		field := &SchemaField{Name: name, Column: column, Type: fieldType, Filterable: true, Sortable: true}
+		for _, opt := range strings.Split(sf.Tag.Get("query"), ",") {
+			switch strings.TrimSpace(opt) {
+			case "-":
+				field = nil
+			case "nofilter":
+				field.Filterable = false
+			case "nosort":
+				field.Sortable = false
+			case "search":
+				if fieldType == FieldString {
+					s.Search = append(s.Search, name)
+				}
+			}
+			if field == nil {
+				break
+			}
+		}
+		if field != nil {
+			s.Fields[name] = field
+			s.Names = append(s.Names, name)
+		}
+	}
+}
+
+// NewQuery returns an empty Query bound to the schema, so that unmarshalling
+// JSON into it rejects unknown fields and wrongly typed values.
+func (s *Schema) NewQuery() *Query {
+	return &Query{Condition: Condition{schema: s, path: "condition"}}
+}
+
+func (s *Schema) Column(name string) string {
+	if f, ok := s.Fields[name]; ok {
+		return f.Column
+	}
+	return name
+}
+
+func (s *Schema) checkFilter(qe *ValidationError, path string, op *findQueryOp) {
+	f, ok := s.Fields[op.field]
+	if !ok {
+		qe.add(joinPath(path, "f"), op.field, "UNKNOWN FIELD %s", op.field)
+		return
+	}
+	if !f.Filterable {
+		qe.add(joinPath(path, "f"), op.field, "FIELD NOT FILTERABLE %s", op.field)
+		return
+	}
+	values := []any{op.value}
+	if vs, ok := op.value.([]any); ok {
+		values = vs
+	}
+	valid := true
+	switch op.op {
+	case "IS NULL", "IS NOT NULL":
+	case "LIKE", "ILIKE":
+		_, isString := op.value.(string)
+		valid = isString && f.Type == FieldString
+	default:
+		for _, v := range values {
+			switch v.(type) {
+			case string:
+				valid = valid && f.Type == FieldString
+			case float64, int, int64, uint:
+				valid = valid && f.Type == FieldNumber
+			case bool:
+				valid = valid && f.Type == FieldBool
+			default:
+				valid = false
+			}
+		}
+	}
+	if !valid {
+		qe.add(joinPath(path, "v"), op.field, "INVALID VALUE TYPE %T FOR %s FIELD %s", op.value, f.Type, op.field)
+		return
+	}
+	op.column = f.Column
+}
+
+func (s *Schema) checkCondition(qe *ValidationError, path string, c *Condition) {
+	for i, e := range c.entries {
+		ep := joinPath(path, fmt.Sprintf("e[%d]", i))
+		switch et := e.(type) {
+		case findQueryOp:
+			s.checkFilter(qe, ep, &et)
+			c.entries[i] = et
+		case Condition:
+			s.checkCondition(qe, ep, &et)
+			c.entries[i] = et
+		}
+	}
+}
+
+// ValidateQuery checks every field referenced by q against the schema and
+// returns a *ValidationError listing each offending JSON path.
+func (s *Schema) ValidateQuery(q *Query) error {
+	qe := &ValidationError{Message: "INVALID QUERY"}
+	for i, name := range q.Select {
+		if _, ok := s.Fields[name]; !ok {
+			qe.add(fmt.Sprintf("select[%d]", i), name, "UNKNOWN FIELD %s", name)
+		}
+	}
+	if len(q.GroupBy) > 0 || len(q.Aggregates) > 0 || q.Having != nil {
+		qe.add("groupBy", "", "AGGREGATE NOT SUPPORTED")
+	}
+	s.checkOrderBy(qe, q.OrderBy)
+	s.checkCondition(qe, "condition", &q.Condition)
+	if len(qe.Errors) > 0 {
+		return qe
+	}
+	return nil
+}
+
+func (s *Schema) checkOrderBy(qe *ValidationError, o OrderBy) {
+	for i, key := range o.Keys {
+		path := o.path(i)
+		if f, ok := s.Fields[key.Field]; !ok {
+			qe.add(path+".f", key.Field, "UNKNOWN FIELD %s", key.Field)
+		} else if !f.Sortable {
+			qe.add(path+".f", key.Field, "FIELD NOT SORTABLE %s", key.Field)
+		}
+		if key.Nulls != "" && key.Nulls != NullsFirst && key.Nulls != NullsLast {
+			qe.add(path+".nulls", key.Field, "INVALID NULLS %s", key.Nulls)
+		}
+	}
+}
+
+func joinPath(base, elem string) string {
+	if base == "" {
+		return elem
+	}
+	return base + "." + elem
+}
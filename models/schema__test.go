@@ -0,0 +1,128 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	test_lib "github.com/senomas/go-api/test/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+type schemaTestModel struct {
+	ID       uint   `json:"id"`
+	Name     string `json:"name" gorm:"column:full_name"`
+	Secret   string `json:"secret" query:"-"`
+	Note     string `json:"note" query:"nofilter,nosort"`
+	Internal string `json:"-"`
+	Active   bool   `json:"active"`
+}
+
+func TestSchema_Parse(t *testing.T) {
+	schema := ParseSchema(&schemaTestModel{})
+
+	assert.Equal(t, test_lib.Marshal(t, map[string]*SchemaField{
+		"id":     {Name: "id", Column: "id", Type: FieldNumber, Filterable: true, Sortable: true},
+		"name":   {Name: "name", Column: "full_name", Type: FieldString, Filterable: true, Sortable: true},
+		"note":   {Name: "note", Column: "note", Type: FieldString},
+		"active": {Name: "active", Column: "active", Type: FieldBool, Filterable: true, Sortable: true},
+	}), test_lib.Marshal(t, schema.Fields))
+}
+
+func TestSchema_Book(t *testing.T) {
+	bytes := []byte(`{
+		"select": ["id", "title"],
+		"condition": {
+			"o": "AND",
+			"e": [
+				{ "o": "LIKE", "f": "title", "v": "harry potter" },
+				{ "o": "=", "f": "id", "v": 1 }
+			]
+		},
+		"orderBy": { "f": "author" }
+	}`)
+
+	schema := SchemaOf(&Book{})
+	query := schema.NewQuery()
+	assert.NoError(t, json.Unmarshal(bytes, query))
+	assert.NoError(t, schema.ValidateQuery(query))
+
+	where, params := query.Condition.Apply("", []any{})
+	assert.Equal(t, "title LIKE ? AND id = ?", where)
+	assert.Equal(t, test_lib.Marshal(t, []any{"harry potter", 1}), test_lib.Marshal(t, params))
+}
+
+func TestSchema_Column(t *testing.T) {
+	bytes := []byte(`{ "o": "AND", "e": [ { "o": "=", "f": "name", "v": "john" } ] }`)
+
+	query := ParseSchema(&schemaTestModel{}).NewQuery()
+	assert.NoError(t, json.Unmarshal(bytes, &query.Condition))
+
+	where, _ := query.Condition.Apply("", []any{})
+	assert.Equal(t, "full_name = ?", where)
+}
+
+func TestSchema_Fail_UnknownField(t *testing.T) {
+	bytes := []byte(`{
+		"condition": {
+			"o": "AND",
+			"e": [
+				{ "o": "LIKE", "f": "title", "v": "harry potter" },
+				{ "o": "OR", "e": [ { "o": "=", "f": "password", "v": "secret" } ] }
+			]
+		}
+	}`)
+
+	var qe *ValidationError
+	err := json.Unmarshal(bytes, SchemaOf(&Book{}).NewQuery())
+	assert.True(t, errors.As(err, &qe), err)
+	assert.Equal(t, test_lib.Marshal(t, []FieldError{
+		{Path: "condition.e[1].e[0].f", Field: "password", Message: "UNKNOWN FIELD password"},
+	}), test_lib.Marshal(t, qe.Errors))
+}
+
+func TestSchema_Fail_NotFilterable(t *testing.T) {
+	bytes := []byte(`{ "condition": { "o": "AND", "e": [ { "o": "=", "f": "note", "v": "x" } ] } }`)
+
+	var qe *ValidationError
+	err := json.Unmarshal(bytes, ParseSchema(&schemaTestModel{}).NewQuery())
+	assert.True(t, errors.As(err, &qe), err)
+	assert.Equal(t, test_lib.Marshal(t, []FieldError{
+		{Path: "condition.e[0].f", Field: "note", Message: "FIELD NOT FILTERABLE note"},
+	}), test_lib.Marshal(t, qe.Errors))
+}
+
+func TestSchema_Fail_ValueType(t *testing.T) {
+	bytes := []byte(`{ "condition": { "o": "AND", "e": [ { "o": "=", "f": "id", "v": "1" } ] } }`)
+
+	var qe *ValidationError
+	err := json.Unmarshal(bytes, SchemaOf(&Book{}).NewQuery())
+	assert.True(t, errors.As(err, &qe), err)
+	assert.Equal(t, test_lib.Marshal(t, []FieldError{
+		{Path: "condition.e[0].v", Field: "id", Message: "INVALID VALUE TYPE string FOR number FIELD id"},
+	}), test_lib.Marshal(t, qe.Errors))
+}
+
+func TestSchema_Fail_SelectOrderBy(t *testing.T) {
+	schema := ParseSchema(&schemaTestModel{})
+	query := NewQuery(Fields("id", "secret"), nil, &QueryOrderBy{Field: "note"})
+
+	var qe *ValidationError
+	assert.True(t, errors.As(schema.ValidateQuery(query), &qe))
+	assert.Equal(t, test_lib.Marshal(t, []FieldError{
+		{Path: "select[1]", Field: "secret", Message: "UNKNOWN FIELD secret"},
+		{Path: "orderBy.f", Field: "note", Message: "FIELD NOT SORTABLE note"},
+	}), test_lib.Marshal(t, qe.Errors))
+}
+
+func TestSchema_Fail_OrderByList(t *testing.T) {
+	schema := ParseSchema(&schemaTestModel{})
+	query := NewQuery(nil, nil, nil).SortBy(Asc("id"), Desc("note"), QueryOrderBy{Field: "id", Nulls: "middle"})
+
+	var qe *ValidationError
+	assert.True(t, errors.As(schema.ValidateQuery(query), &qe))
+	assert.Equal(t, test_lib.Marshal(t, []FieldError{
+		{Path: "orderBy[1].f", Field: "note", Message: "FIELD NOT SORTABLE note"},
+		{Path: "orderBy[2].nulls", Field: "id", Message: "INVALID NULLS middle"},
+	}), test_lib.Marshal(t, qe.Errors))
+}
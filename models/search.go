@@ -0,0 +1,443 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Markers around the matched terms in the highlights of a SearchHit.
+const (
+	HighlightStart = "<mark>"
+	HighlightStop  = "</mark>"
+)
+
+// SearchIndex maintains the full text index of a table next to it and
+// searches it. The text uses the web search syntax on every dialect: words
+// must all match, "quoted phrases" match in sequence, or separates
+// alternatives and -word excludes a word. Columns rank in their order.
+type SearchIndex interface {
+	// Supported reports whether the database has full text search.
+	Supported(tx *gorm.DB) bool
+	Migrate(tx *gorm.DB, table string, columns []string) error
+	Index(tx *gorm.DB, table string, id any, columns []string, values []string) error
+	Remove(tx *gorm.DB, table string, id any) error
+	// Search selects the primary key of the rows of table matching text as
+	// search_id, their relevance as search_score, higher is better, and the
+	// highlighted columns as search_<column>.
+	Search(tx *gorm.DB, table string, pk string, columns []string, text string) *gorm.DB
+}
+
+// SearchHit is one search result, Highlights maps the matching fields to
+// an excerpt with the matched terms between HighlightStart and
+// HighlightStop.
+type SearchHit[T any] struct {
+	Data       T                 `json:"data"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights,omitempty"`
+}
+
+// ErrSearchUnsupported is returned by Search when the database has no
+// SearchIndex or the model no searchable fields.
+var ErrSearchUnsupported = errors.New("Search is not supported")
+
+var searchIndexes sync.Map
+
+func init() {
+	RegisterSearchIndex("postgres", postgresSearch{config: "english"})
+	RegisterSearchIndex("sqlite", sqliteSearch{tokenize: "porter unicode61"})
+}
+
+// RegisterSearchIndex sets the SearchIndex used for the gorm dialector name.
+func RegisterSearchIndex(name string, index SearchIndex) {
+	searchIndexes.Store(name, index)
+}
+
+// SearchIndexOf returns the SearchIndex registered for name, nil when there
+// is none.
+func SearchIndexOf(name string) SearchIndex {
+	if index, ok := searchIndexes.Load(name); ok {
+		return index.(SearchIndex)
+	}
+	return nil
+}
+
+func searchTable(table string) string {
+	return table + "_search"
+}
+
+func (s *Schema) searchColumns() []string {
+	columns := []string{}
+	for _, name := range s.Search {
+		columns = append(columns, s.Column(name))
+	}
+	return columns
+}
+
+// MigrateSearch creates the search index of model when it has searchable
+// fields. Databases without full text search are logged and skipped.
+func MigrateSearch(db *gorm.DB, model any) error {
+	index := SearchIndexOf(db.Dialector.Name())
+	columns := SchemaOf(model).searchColumns()
+	if index == nil || len(columns) == 0 {
+		return nil
+	}
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	if !index.Supported(db) {
+		log.Printf("%v, %s has no search index\n", ErrSearchUnsupported, stmt.Schema.Table)
+		return nil
+	}
+	return index.Migrate(db, stmt.Schema.Table, columns)
+}
+
+func (r *Repository[T]) searchable() bool {
+	return len(r.Schema.Search) > 0 && r.db().Search != nil
+}
+
+// indexSearch writes the searchable fields of data to the search index.
+func (r *Repository[T]) indexSearch(tx *gorm.DB, data *T) error {
+	if !r.searchable() {
+		return nil
+	}
+	s, err := r.gormSchema()
+	if err != nil {
+		return err
+	}
+	columns := r.Schema.searchColumns()
+	values := []string{}
+	for _, column := range columns {
+		field := s.LookUpField(column)
+		if field == nil {
+			return fmt.Errorf("Unknown column %s", column)
+		}
+		value, _ := field.ValueOf(tx.Statement.Context, reflect.ValueOf(data))
+		if value == nil {
+			values = append(values, "")
+		} else {
+			values = append(values, fmt.Sprint(reflect.Indirect(reflect.ValueOf(value)).Interface()))
+		}
+	}
+	id, err := r.PrimaryKey(tx.Statement.Context, *data)
+	if err != nil {
+		return err
+	}
+	return r.db().Search.Index(tx, s.Table, id, columns, values)
+}
+
+func (r *Repository[T]) removeSearch(tx *gorm.DB, data *T) error {
+	if !r.searchable() {
+		return nil
+	}
+	s, err := r.gormSchema()
+	if err != nil {
+		return err
+	}
+	id, err := r.PrimaryKey(tx.Statement.Context, *data)
+	if err != nil {
+		return err
+	}
+	return r.db().Search.Remove(tx, s.Table, id)
+}
+
+// Search returns the page of the rows matching text and the condition of
+// query, the most relevant first. Offset and Limit of opts apply.
+func (r *Repository[T]) Search(ctx context.Context, text string, query *Query, opts PageOptions) (Page[SearchHit[T]], error) {
+	page := Page[SearchHit[T]]{Data: []SearchHit[T]{}}
+	if query == nil {
+		query = NewQuery(nil, nil, nil)
+	}
+	if err := r.Schema.ValidateQuery(query); err != nil {
+		return page, err
+	}
+	if strings.TrimSpace(text) == "" {
+		return page, &BadRequestError{Err: errors.New("Missing search text")}
+	}
+	if !r.searchable() {
+		return page, &BadRequestError{Err: ErrSearchUnsupported}
+	}
+	s, err := r.gormSchema()
+	if err != nil {
+		return page, err
+	}
+	pk := s.PrioritizedPrimaryField
+	if pk == nil {
+		return page, fmt.Errorf("No primary key %s", s.Name)
+	}
+
+	db := r.db()
+	var model T
+	columns := r.Schema.searchColumns()
+	tx := db.Search.Search(r.tx(ctx).Model(&model), s.Table, pk.DBName, columns, text)
+	where, params := query.Condition.ApplyDialect(db.SQLDialect(), "", []any{})
+	tx = tx.Where(where, params...).
+		Order(clause.OrderByColumn{Column: clause.Column{Name: "search_score"}, Desc: true}).
+		Order(clause.OrderByColumn{Column: clause.Column{Table: s.Table, Name: pk.DBName}})
+	if opts.Offset > 0 {
+		tx = tx.Offset(opts.Offset)
+	}
+	if opts.Limit > 0 {
+		tx = tx.Limit(opts.Limit)
+	}
+	rows := []map[string]any{}
+	if err := tx.Find(&rows).Error; err != nil {
+		return page, db.TranslateError(err)
+	}
+	if len(rows) == 0 {
+		return page, nil
+	}
+
+	ids := []any{}
+	for _, row := range rows {
+		ids = append(ids, row["search_id"])
+	}
+	dtx := r.tx(ctx).Where(clause.IN{Column: clause.Column{Name: pk.DBName}, Values: ids})
+	if query.Select != nil {
+		selects := []string{}
+		for _, name := range query.Select {
+			selects = append(selects, r.Schema.Column(name))
+		}
+		if !containsString(selects, pk.DBName) {
+			selects = append(selects, pk.DBName)
+		}
+		dtx = dtx.Select(selects)
+	}
+	data := []T{}
+	if err := dtx.Find(&data).Error; err != nil {
+		return page, db.TranslateError(err)
+	}
+	byID := map[string]T{}
+	for _, d := range data {
+		id, _ := pk.ValueOf(ctx, reflect.ValueOf(&d))
+		byID[fmt.Sprint(id)] = d
+	}
+
+	for _, row := range rows {
+		d, ok := byID[fmt.Sprint(row["search_id"])]
+		if !ok {
+			continue
+		}
+		hit := SearchHit[T]{Data: d, Score: searchFloat(row["search_score"])}
+		for i, column := range columns {
+			if h, ok := row["search_"+column].(string); ok && strings.Contains(h, HighlightStart) {
+				if hit.Highlights == nil {
+					hit.Highlights = map[string]string{}
+				}
+				hit.Highlights[r.Schema.Search[i]] = h
+			}
+		}
+		page.Data = append(page.Data, hit)
+	}
+	return page, nil
+}
+
+func searchFloat(v any) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case float32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case string:
+		f, _ := strconv.ParseFloat(n, 64)
+		return f
+	case []byte:
+		f, _ := strconv.ParseFloat(string(n), 64)
+		return f
+	}
+	return 0
+}
+
+// postgresSearch keeps a weighted tsvector per row in table_search, the
+// first four columns weigh A to D, further ones D.
+type postgresSearch struct {
+	config string
+}
+
+func (p postgresSearch) Supported(tx *gorm.DB) bool {
+	return true
+}
+
+func (p postgresSearch) Migrate(tx *gorm.DB, table string, columns []string) error {
+	st := searchTable(table)
+	if err := tx.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (%s bigint PRIMARY KEY, %s tsvector NOT NULL)`,
+		tx.Statement.Quote(st), tx.Statement.Quote("id"), tx.Statement.Quote("document"))).Error; err != nil {
+		return err
+	}
+	return tx.Exec(fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s USING GIN (%s)`,
+		tx.Statement.Quote("idx_"+st+"_document"), tx.Statement.Quote(st), tx.Statement.Quote("document"))).Error
+}
+
+func (p postgresSearch) Index(tx *gorm.DB, table string, id any, columns []string, values []string) error {
+	vectors := []string{}
+	params := []any{id}
+	for i, v := range values {
+		weight := "ABCD"[minInt(i, 3)]
+		vectors = append(vectors, fmt.Sprintf("setweight(to_tsvector('%s', ?), '%c')", p.config, weight))
+		params = append(params, v)
+	}
+	st := tx.Statement.Quote(searchTable(table))
+	return tx.Exec(fmt.Sprintf(`INSERT INTO %s (%s,%s) VALUES (?,%s) ON CONFLICT (%s) DO UPDATE SET %s = excluded.%s`,
+		st, tx.Statement.Quote("id"), tx.Statement.Quote("document"), strings.Join(vectors, " || "),
+		tx.Statement.Quote("id"), tx.Statement.Quote("document"), tx.Statement.Quote("document")), params...).Error
+}
+
+func (p postgresSearch) Remove(tx *gorm.DB, table string, id any) error {
+	return tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE %s = ?`, tx.Statement.Quote(searchTable(table)), tx.Statement.Quote("id")), id).Error
+}
+
+func (p postgresSearch) Search(tx *gorm.DB, table string, pk string, columns []string, text string) *gorm.DB {
+	query := fmt.Sprintf("websearch_to_tsquery('%s', ?)", p.config)
+	selects := []string{tx.Statement.Quote(table+"."+pk) + " AS search_id", "s.search_score"}
+	params := []any{}
+	for _, column := range columns {
+		selects = append(selects, fmt.Sprintf("ts_headline('%s', %s, %s, 'StartSel=%s, StopSel=%s') AS %s",
+			p.config, tx.Statement.Quote(table+"."+column), query, HighlightStart, HighlightStop, tx.Statement.Quote("search_"+column)))
+		params = append(params, text)
+	}
+	st := tx.Statement.Quote(searchTable(table))
+	join := fmt.Sprintf(`JOIN (SELECT %s AS search_id, ts_rank(%s, %s) AS search_score FROM %s WHERE %s @@ %s) AS s ON s.search_id = %s`,
+		tx.Statement.Quote("id"), tx.Statement.Quote("document"), query, st, tx.Statement.Quote("document"), query, tx.Statement.Quote(table+"."+pk))
+	return tx.Select(strings.Join(selects, ","), params...).Joins(join, text, text)
+}
+
+// sqliteSearch keeps an FTS5 table table_search keyed by rowid, ranked with
+// bm25. FTS5 needs the sqlite_fts5 build tag of go-sqlite3.
+type sqliteSearch struct {
+	tokenize string
+}
+
+var sqliteFTS5 struct {
+	once      sync.Once
+	available bool
+}
+
+func (sqliteSearch) Supported(tx *gorm.DB) bool {
+	sqliteFTS5.once.Do(func() {
+		var used int
+		if err := tx.Session(&gorm.Session{NewDB: true}).Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&used).Error; err == nil {
+			sqliteFTS5.available = used == 1
+		}
+	})
+	return sqliteFTS5.available
+}
+
+func (p sqliteSearch) Migrate(tx *gorm.DB, table string, columns []string) error {
+	quoted := []string{}
+	for _, column := range columns {
+		quoted = append(quoted, tx.Statement.Quote(column))
+	}
+	return tx.Exec(fmt.Sprintf(`CREATE VIRTUAL TABLE IF NOT EXISTS %s USING fts5(%s, tokenize='%s')`,
+		tx.Statement.Quote(searchTable(table)), strings.Join(quoted, ","), p.tokenize)).Error
+}
+
+func (p sqliteSearch) Index(tx *gorm.DB, table string, id any, columns []string, values []string) error {
+	if err := p.Remove(tx, table, id); err != nil {
+		return err
+	}
+	quoted := []string{"rowid"}
+	params := []any{id}
+	for i, v := range values {
+		quoted = append(quoted, tx.Statement.Quote(columns[i]))
+		params = append(params, v)
+	}
+	marks := strings.TrimSuffix(strings.Repeat("?,", len(params)), ",")
+	return tx.Exec(fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s)`, tx.Statement.Quote(searchTable(table)), strings.Join(quoted, ","), marks), params...).Error
+}
+
+func (p sqliteSearch) Remove(tx *gorm.DB, table string, id any) error {
+	return tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE rowid = ?`, tx.Statement.Quote(searchTable(table))), id).Error
+}
+
+func (p sqliteSearch) Search(tx *gorm.DB, table string, pk string, columns []string, text string) *gorm.DB {
+	query := ftsQuery(text)
+	if query == "" {
+		// only excluded words, FTS5 can not match their complement
+		return tx.Where("1 = 0")
+	}
+	st := tx.Statement.Quote(searchTable(table))
+	weights := []string{}
+	selects := []string{"rowid AS search_id"}
+	outer := []string{"s.search_id", "s.search_score"}
+	for i, column := range columns {
+		weights = append(weights, strconv.Itoa(len(columns)-i))
+		alias := tx.Statement.Quote("search_" + column)
+		selects = append(selects, fmt.Sprintf("snippet(%s, %d, '%s', '%s', '...', 32) AS %s", st, i, HighlightStart, HighlightStop, alias))
+		outer = append(outer, "s."+alias)
+	}
+	selects = append(selects, fmt.Sprintf("-bm25(%s, %s) AS search_score", st, strings.Join(weights, ", ")))
+	join := fmt.Sprintf(`JOIN (SELECT %s FROM %s WHERE %s MATCH ?) AS s ON s.search_id = %s`,
+		strings.Join(selects, ", "), st, st, tx.Statement.Quote(table+"."+pk))
+	return tx.Select(strings.Join(outer, ",")).Joins(join, query)
+}
+
+// ftsQuery translates the web search syntax to an FTS5 query, quoting every
+// word so that punctuation is not taken for FTS5 syntax.
+func ftsQuery(text string) string {
+	groups := []string{}
+	and, not := []string{}, []string{}
+	flush := func() {
+		if len(and) > 0 {
+			group := strings.Join(and, " ")
+			for _, n := range not {
+				group += " NOT " + n
+			}
+			groups = append(groups, group)
+		}
+		and, not = []string{}, []string{}
+	}
+	for i := 0; i < len(text); {
+		switch c := text[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '"':
+			end := strings.IndexByte(text[i+1:], '"')
+			if end < 0 {
+				end = len(text) - i - 1
+			}
+			if phrase := strings.TrimSpace(text[i+1 : i+1+end]); phrase != "" {
+				and = append(and, ftsQuote(phrase))
+			}
+			i += end + 2
+			continue
+		}
+		end := strings.IndexAny(text[i:], " \t\n\r")
+		if end < 0 {
+			end = len(text) - i
+		}
+		word := text[i : i+end]
+		i += end
+		if strings.EqualFold(word, "or") {
+			flush()
+		} else if strings.HasPrefix(word, "-") && len(word) > 1 {
+			not = append(not, ftsQuote(word[1:]))
+		} else {
+			and = append(and, ftsQuote(word))
+		}
+	}
+	flush()
+	return strings.Join(groups, " OR ")
+}
+
+func ftsQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
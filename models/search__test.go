@@ -0,0 +1,82 @@
+package models
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFTSQuery(t *testing.T) {
+	for text, expected := range map[string]string{
+		`harry potter`:               `"harry" "potter"`,
+		`"chamber of secrets" -dark`: `"chamber of secrets" NOT "dark"`,
+		`tintin or harry -magic`:     `"tintin" OR "harry" NOT "magic"`,
+		`j.k. "rowling`:              `"j.k." "rowling"`,
+		`-dark`:                      ``,
+	} {
+		assert.Equal(t, expected, ftsQuery(text), text)
+	}
+}
+
+func TestRepository_Search(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	if repo.DB.Search == nil {
+		t.Skip("sqlite without FTS5, run with -tags sqlite_fts5")
+	}
+	for _, book := range []Book{
+		{Title: "Tintin in Tibet", Author: "Herge", Summary: "Tintin searches the Himalayas for Chang"},
+		{Title: "Harry Potter and the Chamber of Secrets", Author: "J. K. Rowling", Summary: "The chamber is opened"},
+		{Title: "Harry Potter and the Philosopher's Stone", Author: "J. K. Rowling", Summary: "The boy who lived"},
+	} {
+		_, err := repo.Create(ctx, book)
+		assert.NoError(t, err)
+	}
+
+	page, err := repo.Search(ctx, "tintin", nil, PageOptions{})
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(page.Data)) {
+		assert.Equal(t, uint(1), page.Data[0].Data.ID)
+		assert.Greater(t, page.Data[0].Score, 0.0)
+		assert.Equal(t, map[string]string{
+			"title":   "<mark>Tintin</mark> in Tibet",
+			"summary": "<mark>Tintin</mark> searches the Himalayas for Chang",
+		}, page.Data[0].Highlights)
+	}
+
+	// the title weighs more than the summary
+	page, err = repo.Search(ctx, "chamber or lived", nil, PageOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{2, 3}, searchIDs(page))
+
+	page, err = repo.Search(ctx, "harry -chamber", NewQuery(Fields("title"), nil, nil), PageOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{3}, searchIDs(page))
+	assert.Equal(t, "", page.Data[0].Data.Author)
+
+	_, err = repo.Update(ctx, 3, "", func(book *Book) error {
+		book.Title = "Harry Potter and the Sorcerer's Stone"
+		return nil
+	})
+	assert.NoError(t, err)
+	_, err = repo.Delete(ctx, 2, "")
+	assert.NoError(t, err)
+	page, err = repo.Search(ctx, "sorcerer", nil, PageOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{3}, searchIDs(page))
+	page, err = repo.Search(ctx, "harry", NewQuery(nil, NewCondition().Equal("author", "J. K. Rowling"), nil), PageOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{3}, searchIDs(page))
+
+	_, err = repo.Search(ctx, " ", nil, PageOptions{})
+	assert.EqualError(t, err, "Missing search text")
+}
+
+func searchIDs(page Page[SearchHit[Book]]) []uint {
+	ids := []uint{}
+	for _, hit := range page.Data {
+		ids = append(ids, hit.Data.ID)
+	}
+	return ids
+}
@@ -1,50 +1,215 @@
 package models
 
 import (
-	"fmt"
-	"regexp"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
 
-	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// DatabaseModel wraps the gorm connection with its dialect specific error
+// translation. CursorKey signs pagination cursors, it defaults to the
+// CURSOR_KEY environment variable or a random per process key. Search is the
+// full text index kept up to date by the repositories, nil without one. Feed
+// carries the outbox events to the live queries, see Repository.Watch.
+//
+// A nil DatabaseModel, in the DB field of a Repository, an OutboxRelay,
+// Webhooks or Idempotency, falls back to the one installed by Setup.
 type DatabaseModel struct {
-	DB       *gorm.DB
-	Dialect  string
-	ErrorMap func(error) interface{}
+	DB        *gorm.DB
+	Dialect   string
+	ErrorMap  func(error) *Problem
+	Translate func(error) error
+	CursorKey []byte
+	Search    SearchIndex
+	Feed      *Feed
 }
 
 var DB *DatabaseModel
 
 func Setup(db *gorm.DB) error {
-	DB = &DatabaseModel{DB: db, Dialect: db.Dialector.Name()}
-	switch DB.Dialect {
+	DB = NewDatabaseModel(db)
+	return nil
+}
+
+func NewDatabaseModel(db *gorm.DB) *DatabaseModel {
+	model := &DatabaseModel{DB: db, Dialect: db.Dialector.Name(), Feed: NewFeed()}
+	switch model.Dialect {
 	case "sqlite":
-		duplicate := regexp.MustCompile(`UNIQUE constraint failed: (.*)`)
-		DB.ErrorMap = func(err error) interface{} {
-			errText := err.Error()
-			if match := duplicate.FindStringSubmatch(errText); len(match) == 2 {
-				return gin.H{"error": fmt.Sprintf("Duplicate value %s", match[1])}
-			}
-			return gin.H{"error": errText}
-		}
+		model.Translate = constraintTranslator(sqliteConstraint)
 	case "postgres":
-		duplicate := regexp.MustCompile(`ERROR: duplicate key value violates unique constraint "(.*)" \(SQLSTATE 23505\)`)
-		DB.ErrorMap = func(err error) interface{} {
-			errText := err.Error()
-			if match := duplicate.FindStringSubmatch(errText); len(match) == 2 {
-				switch match[1] {
-				case "idx_books_title":
-					return gin.H{"error": "Duplicate value books.title"}
-				}
-			}
-			return gin.H{"error": errText}
+		model.Translate = constraintTranslator(postgresConstraint)
+	case "mysql":
+		model.Translate = constraintTranslator(mysqlConstraint)
+	default:
+		model.Translate = func(err error) error {
+			return err
 		}
+	}
+	model.ErrorMap = ProblemOf
+	if index := SearchIndexOf(model.Dialect); index != nil && index.Supported(db) {
+		model.Search = index
+	}
+	return model
+}
+
+// orDefault returns db, or the DatabaseModel installed by Setup when db is
+// nil.
+func (db *DatabaseModel) orDefault() *DatabaseModel {
+	if db != nil {
+		return db
+	}
+	return DB
+}
+
+// prune deletes the rows of model matching where and returns their number.
+func (db *DatabaseModel) prune(ctx context.Context, model any, where string, args ...any) (int64, error) {
+	res := db.DB.WithContext(ctx).Where(where, args...).Delete(model)
+	if res.Error != nil {
+		return 0, db.TranslateError(res.Error)
+	}
+	return res.RowsAffected, nil
+}
+
+// clock is the time source of the background workers, time.Now when nil.
+type clock func() time.Time
+
+func (c clock) Now() time.Time {
+	if c != nil {
+		return c()
+	}
+	return time.Now()
+}
+
+// runEvery calls fn every interval until ctx is done, logging its errors as
+// the ones of name.
+func runEvery(ctx context.Context, interval time.Duration, name string, fn func(ctx context.Context) error) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := fn(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("%s error: %v\n", name, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// TranslateError maps a driver error to NotFoundError, ConstraintError or the
+// error itself.
+func (db *DatabaseModel) TranslateError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &NotFoundError{Err: err}
+	}
+	return db.Translate(err)
+}
+
+// EstimateCount returns the row count of table from the database statistics,
+// false when the dialect has none.
+func (db *DatabaseModel) EstimateCount(ctx context.Context, table string) (int64, bool) {
+	var count int64
+	var tx *gorm.DB
+	switch db.Dialect {
+	case "postgres":
+		tx = db.DB.WithContext(ctx).Raw("SELECT reltuples::bigint FROM pg_class WHERE oid = to_regclass(?)", table).Scan(&count)
+	case "mysql":
+		tx = db.DB.WithContext(ctx).Raw("SELECT TABLE_ROWS FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?", table).Scan(&count)
 	default:
-		DB.ErrorMap = func(err error) interface{} {
-			return gin.H{"error": err.Error()}
+		return 0, false
+	}
+	// postgres reports -1 for tables never analyzed
+	if tx.Error != nil || tx.RowsAffected == 0 || count < 0 {
+		return 0, false
+	}
+	return count, true
+}
+
+// orderColumns renders one sort key. Mysql has no NULLS FIRST/LAST, an
+// IS NULL key in front of the column sorts the null values there.
+func (db *DatabaseModel) orderColumns(tx *gorm.DB, column string, desc bool, nulls string) []clause.OrderByColumn {
+	col := clause.OrderByColumn{Column: clause.Column{Name: column}, Desc: desc}
+	if nulls == "" {
+		return []clause.OrderByColumn{col}
+	}
+	quoted := tx.Statement.Quote(clause.Column{Name: column})
+	if db.Dialect == "mysql" {
+		return []clause.OrderByColumn{{Column: clause.Column{Name: quoted + " IS NULL", Raw: true}, Desc: nulls == NullsFirst}, col}
+	}
+	sql := quoted
+	if desc {
+		sql += " DESC"
+	}
+	return []clause.OrderByColumn{{Column: clause.Column{Name: sql + " NULLS " + strings.ToUpper(nulls), Raw: true}}}
+}
+
+var registeredModels = []any{}
+
+// RegisterModel adds model to the set migrated by AutoMigrate.
+func RegisterModel(model any) {
+	t := modelType(model)
+	for _, m := range registeredModels {
+		if modelType(m) == t {
+			return
+		}
+	}
+	registeredModels = append(registeredModels, model)
+	RegisterConstraints(model)
+}
+
+// AutoMigrate migrates the registered models, their search indexes, the
+// revisions of the audit, the outbox, the webhooks and the idempotency
+// records.
+func AutoMigrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(registeredModels...); err != nil {
+		return err
+	}
+	for _, model := range registeredModels {
+		if err := dropReplacedIndexes(db, model); err != nil {
+			return err
+		}
+		if err := MigrateSearch(db, model); err != nil {
+			return err
 		}
 	}
+	if err := MigrateAudit(db); err != nil {
+		return err
+	}
+	if err := MigrateOutbox(db); err != nil {
+		return err
+	}
+	if err := MigrateWebhooks(db); err != nil {
+		return err
+	}
+	return MigrateIdempotency(db)
+}
 
+// dropReplacedIndexes drops the plain unique index of a column that a
+// partial unique index of model now covers, e.g. the one of a title before
+// the model opted in to soft delete. AutoMigrate creates the new index but
+// leaves the old one, which would still reject the deleted titles.
+func dropReplacedIndexes(db *gorm.DB, model any) error {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	for _, idx := range stmt.Schema.ParseIndexes() {
+		if idx.Class != "UNIQUE" || idx.Where == "" || len(idx.Fields) != 1 {
+			continue
+		}
+		name := db.NamingStrategy.IndexName(stmt.Schema.Table, idx.Fields[0].DBName)
+		if name == idx.Name || !db.Migrator().HasIndex(model, name) {
+			continue
+		}
+		if err := db.Migrator().DropIndex(model, name); err != nil {
+			return err
+		}
+	}
 	return nil
 }
@@ -0,0 +1,154 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+var deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+
+// deletedField returns the gorm.DeletedAt field of models opting in to soft
+// delete, nil for the others.
+func (r *Repository[T]) deletedField() (*schema.Field, error) {
+	s, err := r.gormSchema()
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range s.Fields {
+		if field.FieldType == deletedAtType && field.DBName != "" {
+			return field, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *Repository[T]) softDeleteField() (*schema.Field, error) {
+	field, err := r.deletedField()
+	if err != nil {
+		return nil, err
+	} else if field == nil {
+		var model T
+		return nil, &BadRequestError{Err: fmt.Errorf("Soft delete is not supported by %s", modelType(&model).Name())}
+	}
+	return field, nil
+}
+
+// scopeDeleted widens tx to the soft deleted records with IncludeDeleted, or
+// narrows it to them with OnlyDeleted.
+func (r *Repository[T]) scopeDeleted(tx *gorm.DB, query *Query) (*gorm.DB, error) {
+	if !query.IncludeDeleted && !query.OnlyDeleted {
+		return tx, nil
+	}
+	field, err := r.deletedField()
+	if err != nil {
+		return nil, err
+	} else if field == nil {
+		qe := &ValidationError{Message: "INVALID QUERY"}
+		if query.IncludeDeleted {
+			qe.add("includeDeleted", "", "SOFT DELETE NOT SUPPORTED")
+		}
+		if query.OnlyDeleted {
+			qe.add("onlyDeleted", "", "SOFT DELETE NOT SUPPORTED")
+		}
+		return nil, qe
+	}
+	tx = tx.Unscoped()
+	if query.OnlyDeleted {
+		tx = tx.Where(clause.Neq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: nil})
+	}
+	return tx, nil
+}
+
+// FindUnscoped is Find including the soft deleted records.
+func (r *Repository[T]) FindUnscoped(ctx context.Context, id any) (T, error) {
+	var data T
+	if err := r.tx(ctx).Unscoped().Where("id = ?", id).First(&data).Error; err != nil {
+		return data, r.db().TranslateError(err)
+	}
+	return data, nil
+}
+
+// Restore undeletes the soft deleted record identified by id, ifMatch is
+// checked as in Update and the version of versioned models is bumped. It
+// fails with a ConstraintError when a live record took its unique key in the
+// meantime, and with a PreconditionFailedError when the record was restored
+// or changed concurrently.
+func (r *Repository[T]) Restore(ctx context.Context, id any, ifMatch string) (T, error) {
+	var data T
+	field, err := r.softDeleteField()
+	if err != nil {
+		return data, err
+	}
+	if data, err = r.FindUnscoped(ctx, id); err != nil {
+		return data, err
+	}
+	deletedAt := reflect.ValueOf(&data).Elem().FieldByIndex(field.StructField.Index)
+	if !deletedAt.Interface().(gorm.DeletedAt).Valid {
+		return data, &ConflictError{Err: fmt.Errorf("Record %v is not deleted", id)}
+	}
+	if err := r.checkMatch(data, ifMatch); err != nil {
+		return data, err
+	}
+	version, err := r.versionField()
+	if err != nil {
+		return data, err
+	}
+
+	before := data
+	err = r.write(ctx, func(tx *gorm.DB) error {
+		res := tx.Unscoped().Model(&data).Where(clause.Neq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: nil})
+		columns := []string{field.DBName}
+		if version != nil {
+			v, _ := version.ValueOf(ctx, reflect.ValueOf(&data))
+			res = res.Where(clause.Eq{Column: clause.Column{Name: version.DBName}, Value: v})
+			bumpVersion(reflect.ValueOf(&data).Elem().FieldByIndex(version.StructField.Index))
+			columns = append(columns, version.DBName)
+		} else if ifMatch != "" {
+			unchanged, err := r.unchanged(ctx, &before)
+			if err != nil {
+				return err
+			}
+			res = res.Where(unchanged)
+		}
+		deletedAt.Set(reflect.Zero(field.FieldType))
+		if res = res.Select(columns).Updates(&data); res.Error != nil {
+			return r.db().TranslateError(res.Error)
+		} else if res.RowsAffected == 0 {
+			return &PreconditionFailedError{Err: fmt.Errorf("Record %v was modified", id)}
+		}
+		if err := r.indexSearch(tx, &data); err != nil {
+			return err
+		}
+		return r.changed(tx, AuditRestore, &before, &data)
+	})
+	return data, err
+}
+
+// Purge permanently removes the records soft deleted before the given time,
+// all of them when before is zero, and returns how many there were. It is
+// outside of the Audit and Outbox scope: their deletes were recorded and
+// published already, Purge writes no revision nor event and leaves the
+// history of the purged records in place.
+func (r *Repository[T]) Purge(ctx context.Context, before time.Time) (int64, error) {
+	field, err := r.softDeleteField()
+	if err != nil {
+		return 0, err
+	}
+	column := clause.Column{Table: clause.CurrentTable, Name: field.DBName}
+	var model T
+	tx := r.tx(ctx).Unscoped().Where(clause.Neq{Column: column, Value: nil})
+	if !before.IsZero() {
+		tx = tx.Where(clause.Lt{Column: column, Value: before})
+	}
+	res := tx.Delete(&model)
+	if res.Error != nil {
+		return 0, r.db().TranslateError(res.Error)
+	}
+	return res.RowsAffected, nil
+}
@@ -0,0 +1,211 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestRepository_SoftDelete(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	for _, book := range []Book{
+		{Title: "Tintin in Tibet", Author: "Herge"},
+		{Title: "Tintin in America", Author: "Herge"},
+	} {
+		_, err := repo.Create(ctx, book)
+		assert.NoError(t, err)
+	}
+
+	_, err := repo.Delete(ctx, 1, "")
+	assert.NoError(t, err)
+	var notFound *NotFoundError
+	_, err = repo.Find(ctx, 1)
+	assert.True(t, errors.As(err, &notFound), err)
+	book, err := repo.FindUnscoped(ctx, 1)
+	assert.NoError(t, err)
+	assert.True(t, book.DeletedAt.Valid)
+
+	titles := func(query *Query) []string {
+		page, err := repo.Finds(ctx, query.SortBy(Asc("id")), 0, 0)
+		assert.NoError(t, err)
+		titles := []string{}
+		for _, book := range page.Data {
+			titles = append(titles, book.Title)
+		}
+		return titles
+	}
+	assert.Equal(t, []string{"Tintin in America"}, titles(NewQuery(nil, nil, nil)))
+	assert.Equal(t, []string{"Tintin in Tibet", "Tintin in America"}, titles(&Query{IncludeDeleted: true}))
+	assert.Equal(t, []string{"Tintin in Tibet"}, titles(&Query{OnlyDeleted: true}))
+
+	book, err = repo.Create(ctx, Book{Title: "Tintin in Tibet", Author: "Hergé"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint(3), book.ID)
+	book, err = repo.Upsert(ctx, Book{Title: "Tintin in Tibet", Author: "Herge"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint(3), book.ID)
+
+	var conflict *ConflictError
+	_, err = repo.Restore(ctx, 2, "")
+	assert.True(t, errors.As(err, &conflict), err)
+	var constraint *ConstraintError
+	_, err = repo.Restore(ctx, 1, "")
+	assert.True(t, errors.As(err, &constraint), err)
+
+	_, err = repo.Delete(ctx, 3, "")
+	assert.NoError(t, err)
+	book, err = repo.Restore(ctx, 1, "")
+	assert.NoError(t, err)
+	assert.Equal(t, Book{ID: 1, Title: "Tintin in Tibet", Author: "Herge"}, book)
+	_, err = repo.Find(ctx, 1)
+	assert.NoError(t, err)
+
+	purged, err := repo.Purge(ctx, time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), purged)
+	purged, err = repo.Purge(ctx, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	_, err = repo.FindUnscoped(ctx, 3)
+	assert.True(t, errors.As(err, &notFound), err)
+}
+
+type SoftVersionedBook struct {
+	ID        uint           `json:"id,omitempty" gorm:"primary_key"`
+	Title     string         `json:"title,omitempty"`
+	Version   int            `json:"version,omitempty" gorm:"version"`
+	DeletedAt gorm.DeletedAt `json:"deletedAt"`
+}
+
+func TestRepository_SoftDeleteVersion(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open("file:softdelete_version?mode=memory"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal("Init GORM Error", err)
+	}
+	if err := db.AutoMigrate(&SoftVersionedBook{}); err != nil {
+		t.Fatal("AutoMigrate Error", err)
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.SetMaxOpenConns(1)
+	}
+	repo := NewRepository[SoftVersionedBook](NewDatabaseModel(db))
+	_, err = repo.Create(ctx, SoftVersionedBook{Title: "Tintin in Tibet"})
+	assert.NoError(t, err)
+
+	book, err := repo.Delete(ctx, 1, `"1"`)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, book.Version)
+	book, err = repo.FindUnscoped(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, book.Version)
+	assert.True(t, book.DeletedAt.Valid)
+
+	var preconditionFailed *PreconditionFailedError
+	_, err = repo.Restore(ctx, 1, `"1"`)
+	assert.True(t, errors.As(err, &preconditionFailed), err)
+
+	// a concurrent writer restores the record between read and write
+	concurrent := true
+	db.Callback().Update().Before("gorm:update").Register("test:concurrent", func(tx *gorm.DB) {
+		if concurrent {
+			concurrent = false
+			tx.Session(&gorm.Session{NewDB: true}).Exec("UPDATE soft_versioned_books SET deleted_at = NULL, version = 3 WHERE id = 1")
+		}
+	})
+	_, err = repo.Restore(ctx, 1, `"2"`)
+	assert.True(t, errors.As(err, &preconditionFailed), err)
+	book, err = repo.Find(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, book.Version)
+
+	_, err = repo.Delete(ctx, 1, `"3"`)
+	assert.NoError(t, err)
+	book, err = repo.Restore(ctx, 1, `"4"`)
+	assert.NoError(t, err)
+	assert.Equal(t, SoftVersionedBook{ID: 1, Title: "Tintin in Tibet", Version: 5}, book)
+	book, err = repo.Find(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, book.Version)
+}
+
+func TestRepository_PurgeAudit(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	if err := MigrateAudit(repo.DB.DB); err != nil {
+		t.Fatal("MigrateAudit Error", err)
+	}
+	if err := MigrateOutbox(repo.DB.DB); err != nil {
+		t.Fatal("MigrateOutbox Error", err)
+	}
+	repo.Audit = true
+	repo.Outbox = true
+	_, err := repo.Create(ctx, Book{Title: "Tintin in Tibet", Author: "Herge"})
+	assert.NoError(t, err)
+	_, err = repo.Delete(ctx, 1, "")
+	assert.NoError(t, err)
+
+	purged, err := repo.Purge(ctx, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	var events int64
+	assert.NoError(t, repo.DB.DB.Model(&OutboxEvent{}).Count(&events).Error)
+	assert.Equal(t, int64(2), events)
+	history, err := repo.History(ctx, 1, 0, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, history.Data, 2) {
+		assert.Equal(t, []string{AuditDelete, AuditCreate}, []string{history.Data[0].Action, history.Data[1].Action})
+	}
+}
+
+// baselineBook is the book table before it opted in to soft delete.
+type baselineBook struct {
+	ID      uint   `gorm:"primary_key"`
+	Title   string `gorm:"uniqueIndex"`
+	Author  string
+	Summary string
+}
+
+func (baselineBook) TableName() string {
+	return "books"
+}
+
+func TestAutoMigrate_SoftDeleteUpgrade(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open("file:upgrade?mode=memory"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal("Init GORM Error", err)
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.SetMaxOpenConns(1)
+	}
+	if err := db.AutoMigrate(&baselineBook{}); err != nil {
+		t.Fatal("AutoMigrate Error", err)
+	}
+	assert.NoError(t, db.Create(&baselineBook{Title: "Tintin in Tibet", Author: "Herge"}).Error)
+	assert.True(t, db.Migrator().HasIndex(&Book{}, "idx_books_title"))
+
+	RegisterModel(&Book{})
+	if err := AutoMigrate(db); err != nil {
+		t.Fatal("AutoMigrate Error", err)
+	}
+	assert.False(t, db.Migrator().HasIndex(&Book{}, "idx_books_title"))
+	assert.True(t, db.Migrator().HasIndex(&Book{}, "idx_books_title_active"))
+
+	repo := NewRepository[Book](NewDatabaseModel(db))
+	_, err = repo.Delete(ctx, 1, "")
+	assert.NoError(t, err)
+	book, err := repo.Create(ctx, Book{Title: "Tintin in Tibet", Author: "Herge"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint(2), book.ID)
+	var constraint *ConstraintError
+	_, err = repo.Create(ctx, Book{Title: "Tintin in Tibet", Author: "Herge"})
+	assert.True(t, errors.As(err, &constraint), err)
+}
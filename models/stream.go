@@ -0,0 +1,398 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// EventStreamContentType is the media type of Server-Sent Events.
+const EventStreamContentType = "text/event-stream"
+
+// Events of a live query.
+const (
+	LiveSnapshot  = "snapshot"
+	LiveInsert    = "insert"
+	LiveUpdate    = "update"
+	LiveDelete    = "delete"
+	LiveHeartbeat = "heartbeat"
+)
+
+// StreamHeartbeat is the interval of the heartbeats of a live query, they
+// keep idle connections open through proxies.
+var StreamHeartbeat = 15 * time.Second
+
+// StreamSnapshotLimit caps the records of a live query, a larger result set
+// is refused. The snapshot is sent in events of StreamSnapshotChunk records.
+var (
+	StreamSnapshotLimit = 10000
+	StreamSnapshotChunk = 500
+)
+
+// ErrSlowConsumer closes a Subscription that fell further behind than the
+// Feed buffers.
+var ErrSlowConsumer = errors.New("Slow consumer")
+
+// Feed fans the outbox events out to the in-process subscribers, it is the
+// Sink of an OutboxRelay. Publish never blocks the relay: a subscriber with
+// Buffer events pending is closed with ErrSlowConsumer.
+type Feed struct {
+	Buffer int
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+}
+
+func NewFeed() *Feed {
+	return &Feed{Buffer: 256, subs: map[*Subscription]struct{}{}}
+}
+
+// Subscription receives the events published to a Feed from its creation
+// until it is closed.
+type Subscription struct {
+	feed *Feed
+	c    chan OutboxEvent
+	err  error
+}
+
+func (f *Feed) Subscribe() *Subscription {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := &Subscription{feed: f, c: make(chan OutboxEvent, f.Buffer)}
+	f.subs[s] = struct{}{}
+	return s
+}
+
+func (f *Feed) Publish(ctx context.Context, event OutboxEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for s := range f.subs {
+		select {
+		case s.c <- event:
+		default:
+			s.err = ErrSlowConsumer
+			delete(f.subs, s)
+			close(s.c)
+		}
+	}
+	return nil
+}
+
+// Events is closed when the subscription is, see Err.
+func (s *Subscription) Events() <-chan OutboxEvent {
+	return s.c
+}
+
+// Err is ErrSlowConsumer when the feed closed the subscription.
+func (s *Subscription) Err() error {
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+	return s.err
+}
+
+func (s *Subscription) Close() {
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+	if _, ok := s.feed.subs[s]; ok {
+		delete(s.feed.subs, s)
+		close(s.c)
+	}
+}
+
+// LiveEvent is a message of a live query: a chunk of the snapshot of the
+// matching records, the insert, update or delete of one entering, changing
+// in or leaving the result set, or a heartbeat. ID is the outbox event it
+// follows, resuming from it continues after it. Only the last chunk of the
+// snapshot has one, it completes the snapshot.
+type LiveEvent struct {
+	ID    uint   `json:"id,omitempty"`
+	Event string `json:"event"`
+	Data  any    `json:"data,omitempty"`
+}
+
+func (r *Repository[T]) streamEnabled() error {
+	if !r.Outbox || r.db().Feed == nil {
+		var model T
+		return &BadRequestError{Err: fmt.Errorf("Stream is not enabled for %s", modelType(&model).Name())}
+	}
+	return nil
+}
+
+// Watch sends the live events of query to send until ctx is done, send fails
+// or the subscription falls behind with ErrSlowConsumer. It starts with a
+// snapshot of the matching records, or after lastEventID when the outbox
+// still has it: the records changed since then are sent as an update when
+// they match and as a delete otherwise, clients ignore deletes of records
+// they do not hold. A query matching more than StreamSnapshotLimit records
+// is refused. The changes come from the Feed of the database, an
+// OutboxRelay must publish to it. A change is sent unless the client holds
+// the record as it is already, whatever its ID: a write may commit after a
+// later one and after the snapshot with an ID before it.
+func (r *Repository[T]) Watch(ctx context.Context, query *Query, lastEventID uint, send func(LiveEvent) error) error {
+	if err := r.streamEnabled(); err != nil {
+		return err
+	}
+	if query == nil {
+		query = NewQuery(nil, nil, nil)
+	}
+	s, err := r.gormSchema()
+	if err != nil {
+		return err
+	}
+	sub := r.db().Feed.Subscribe()
+	defer sub.Close()
+
+	// last is the resume point of the snapshot, the feed may still deliver
+	// events up to it: ids are allocated before commit
+	var last uint
+	if err := r.tx(ctx).Model(&OutboxEvent{}).Select("COALESCE(MAX(id), 0)").Scan(&last).Error; err != nil {
+		return r.db().TranslateError(err)
+	}
+	if StreamSnapshotLimit > 0 {
+		page, err := r.Paginate(ctx, query, PageOptions{Limit: 1, Count: CountExact})
+		if err != nil {
+			return err
+		}
+		if *page.Count > int64(StreamSnapshotLimit) {
+			return &BadRequestError{Err: fmt.Errorf("Stream matches %d records, more than %d", *page.Count, StreamSnapshotLimit)}
+		}
+	}
+	resumed := false
+	if lastEventID > 0 {
+		var count int64
+		if err := r.tx(ctx).Model(&OutboxEvent{}).Where("id = ?", lastEventID).Count(&count).Error; err != nil {
+			return r.db().TranslateError(err)
+		}
+		resumed = count > 0
+	}
+
+	// the ETags of the records in the result set, by primary key, as the
+	// client holds them
+	known := map[string]string{}
+	chunk := []T{}
+	if err := r.Each(ctx, query, func(data T) error {
+		id, err := r.PrimaryKey(ctx, data)
+		if err != nil {
+			return err
+		}
+		known[fmt.Sprint(id)] = r.ETag(data)
+		if resumed {
+			return nil
+		}
+		chunk = append(chunk, data)
+		if len(chunk) < StreamSnapshotChunk {
+			return nil
+		}
+		rows := chunk
+		chunk = []T{}
+		return send(LiveEvent{Event: LiveSnapshot, Data: rows})
+	}); err != nil {
+		return err
+	}
+	if resumed {
+		if err := r.replay(ctx, s.Table, lastEventID, last, known, send); err != nil {
+			return err
+		}
+	} else if err := send(LiveEvent{ID: last, Event: LiveSnapshot, Data: chunk}); err != nil {
+		return err
+	}
+
+	heartbeat := time.NewTicker(StreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-heartbeat.C:
+			if err := send(LiveEvent{Event: LiveHeartbeat}); err != nil {
+				return err
+			}
+		case event, ok := <-sub.Events():
+			if !ok {
+				return sub.Err()
+			}
+			if event.RecordType != s.Table {
+				continue
+			}
+			var data T
+			if err := json.Unmarshal(event.Data, &data); err != nil {
+				return err
+			}
+			in, err := r.liveMatch(ctx, query, event.Action, data)
+			if err != nil {
+				return err
+			}
+			live := LiveEvent{ID: event.ID, Data: data}
+			etag, was := known[event.RecordID]
+			switch {
+			case in && was && etag == r.ETag(data):
+				// the client holds it already, e.g. from the snapshot
+				continue
+			case in && was:
+				live.Event = LiveUpdate
+			case in:
+				live.Event = LiveInsert
+			case was:
+				live.Event = LiveDelete
+			default:
+				continue
+			}
+			if in {
+				known[event.RecordID] = r.ETag(data)
+			} else {
+				delete(known, event.RecordID)
+			}
+			if err := send(live); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// replay sends the records of table changed by the outbox events after from
+// up to to, once each with the ID and record of its last event, and keeps
+// the ETag of the ones sent as an update in known.
+func (r *Repository[T]) replay(ctx context.Context, table string, from uint, to uint, known map[string]string, send func(LiveEvent) error) error {
+	events := []OutboxEvent{}
+	if err := r.tx(ctx).Where("record_type = ? AND id > ? AND id <= ?", table, from, to).
+		Order("id").Find(&events).Error; err != nil {
+		return r.db().TranslateError(err)
+	}
+	latest := map[string]uint{}
+	for _, event := range events {
+		latest[event.RecordID] = event.ID
+	}
+	for _, event := range events {
+		if latest[event.RecordID] != event.ID {
+			continue
+		}
+		var data T
+		if err := json.Unmarshal(event.Data, &data); err != nil {
+			return err
+		}
+		live := LiveEvent{ID: event.ID, Event: LiveDelete, Data: data}
+		if _, ok := known[event.RecordID]; ok {
+			live.Event = LiveUpdate
+			known[event.RecordID] = r.ETag(data)
+		}
+		if err := send(live); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// liveMatch tells whether data after an outbox event with action is in the
+// result set of query, with the soft delete options of the query.
+func (r *Repository[T]) liveMatch(ctx context.Context, query *Query, action string, data T) (bool, error) {
+	deleted := action == AuditDelete
+	field, err := r.deletedField()
+	if err != nil {
+		return false, err
+	} else if field != nil {
+		v, _ := field.ValueOf(ctx, reflect.ValueOf(&data))
+		if at, ok := v.(gorm.DeletedAt); ok {
+			deleted = at.Valid
+		}
+	}
+	if query.OnlyDeleted && !deleted || !query.OnlyDeleted && !query.IncludeDeleted && deleted {
+		return false, nil
+	}
+	return query.Condition.Match(data)
+}
+
+// streamEvents serves the live query as Server-Sent Events, the heartbeats
+// are comments.
+func streamEvents[T any](c *gin.Context, repo *Repository[T], query *Query, lastEventID uint) {
+	begun := false
+	err := repo.Watch(c.Request.Context(), query, lastEventID, func(event LiveEvent) error {
+		if !begun {
+			begun = true
+			header := c.Writer.Header()
+			header.Set("Content-Type", EventStreamContentType)
+			header.Set("Cache-Control", "no-cache")
+			// nginx buffers responses unless told otherwise
+			header.Set("X-Accel-Buffering", "no")
+			c.Writer.WriteHeader(http.StatusOK)
+		}
+		var b strings.Builder
+		if event.Event == LiveHeartbeat {
+			b.WriteString(": heartbeat\n\n")
+		} else {
+			data, err := json.Marshal(event.Data)
+			if err != nil {
+				return err
+			}
+			if event.ID > 0 {
+				fmt.Fprintf(&b, "id: %d\n", event.ID)
+			}
+			fmt.Fprintf(&b, "event: %s\ndata: %s\n\n", event.Event, data)
+		}
+		if _, err := io.WriteString(c.Writer, b.String()); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	})
+	if err != nil && !begun {
+		repo.ErrorJSON(c, err)
+	} else if err != nil {
+		// the client reconnects with the Last-Event-ID it got
+		c.Error(err)
+	}
+}
+
+// streamWebSocket serves the live query as WebSocket text messages of the
+// LiveEvent json, the heartbeats are pings. A slow client is closed with
+// try again later. The handshake is a GET from an origin WebSocketOrigin
+// accepts.
+func streamWebSocket[T any](c *gin.Context, repo *Repository[T], query *Query, lastEventID uint) {
+	if err := checkWebSocket(c.Request); err != nil {
+		repo.ErrorJSON(c, err)
+		return
+	}
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	var ws *wsConn
+	err := repo.Watch(ctx, query, lastEventID, func(event LiveEvent) error {
+		if ws == nil {
+			conn, err := upgradeWebSocket(c.Writer, c.Request)
+			if err != nil {
+				return err
+			}
+			ws = conn
+			// the hijacked connection no longer ends the request context
+			go ws.readLoop(cancel)
+		}
+		if event.Event == LiveHeartbeat {
+			return ws.writeFrame(wsPing, nil)
+		}
+		msg, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		return ws.writeFrame(wsText, msg)
+	})
+	switch {
+	case ws == nil:
+		if err != nil {
+			repo.ErrorJSON(c, err)
+		}
+	case errors.Is(err, ErrSlowConsumer):
+		ws.close(wsTryAgainLater, err.Error())
+	case err != nil:
+		c.Error(err)
+		ws.close(wsInternalError, "")
+	default:
+		ws.close(wsNormalClosure, "")
+	}
+}
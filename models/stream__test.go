@@ -0,0 +1,313 @@
+package models
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeed_SlowConsumer(t *testing.T) {
+	feed := NewFeed()
+	feed.Buffer = 1
+	sub := feed.Subscribe()
+	assert.NoError(t, feed.Publish(context.Background(), OutboxEvent{ID: 1}))
+	assert.NoError(t, feed.Publish(context.Background(), OutboxEvent{ID: 2}))
+	event, ok := <-sub.Events()
+	assert.True(t, ok)
+	assert.Equal(t, uint(1), event.ID)
+	_, ok = <-sub.Events()
+	assert.False(t, ok)
+	assert.Equal(t, ErrSlowConsumer, sub.Err())
+	sub.Close()
+}
+
+type sseEvent struct {
+	id    string
+	event string
+	data  string
+}
+
+// nextSSE reads the next event of a stream, counting the heartbeats before
+// it.
+func nextSSE(t *testing.T, r *bufio.Reader, heartbeats *int) sseEvent {
+	var e sseEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal("Read stream", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && e.event != "":
+			return e
+		case line == ": heartbeat" && heartbeats != nil:
+			*heartbeats++
+		case strings.HasPrefix(line, "id: "):
+			e.id = line[4:]
+		case strings.HasPrefix(line, "event: "):
+			e.event = line[7:]
+		case strings.HasPrefix(line, "data: "):
+			e.data = line[6:]
+		}
+	}
+}
+
+func TestRepository_Watch(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	if err := MigrateOutbox(repo.DB.DB); err != nil {
+		t.Fatal("MigrateOutbox Error", err)
+	}
+	relay := NewOutboxRelay(repo.DB, repo.DB.Feed)
+	flush := func() {
+		_, err := relay.Flush(ctx)
+		assert.NoError(t, err)
+	}
+	heartbeat := StreamHeartbeat
+	StreamHeartbeat = 20 * time.Millisecond
+	defer func() { StreamHeartbeat = heartbeat }()
+
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	engine.GET("/books/stream", func(c *gin.Context) {
+		Stream(c, repo)
+	})
+	server := httptest.NewServer(engine)
+	defer server.Close()
+	tintin := NewQuery(nil, NewCondition().Like("title", "Tintin"), nil).String()
+
+	resp, err := http.Get(server.URL + "/books/stream?query=" + tintin)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Contains(t, string(body), "Stream is not enabled for Book")
+		resp.Body.Close()
+	}
+	repo.Outbox = true
+
+	_, err = repo.Create(ctx, Book{Title: "Tintin in Tibet", Author: "Herge"})
+	assert.NoError(t, err)
+	_, err = repo.Create(ctx, Book{Title: "Harry Potter and the Philosopher's Stone", Author: "J. K. Rowling"})
+	assert.NoError(t, err)
+	flush()
+
+	t.Run("Server-Sent Events", func(t *testing.T) {
+		sctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(sctx, http.MethodGet, server.URL+"/books/stream?query="+tintin, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("Http Error", err)
+		}
+		defer resp.Body.Close()
+		assert.Equal(t, EventStreamContentType, resp.Header.Get("Content-Type"))
+		r := bufio.NewReader(resp.Body)
+
+		assert.Equal(t, sseEvent{"2", LiveSnapshot, `[{"id":1,"title":"Tintin in Tibet","author":"Herge","deletedAt":null}]`}, nextSSE(t, r, nil))
+
+		// an event up to the snapshot is sent unless the snapshot holds its
+		// record already, its write may have committed after the snapshot
+		assert.NoError(t, repo.DB.Feed.Publish(ctx, OutboxEvent{ID: 1, RecordType: "books", RecordID: "1", Action: AuditCreate,
+			Data: JSONText(`{"id":1,"title":"Tintin in Tibet","author":"Herge","deletedAt":null}`)}))
+		assert.NoError(t, repo.DB.Feed.Publish(ctx, OutboxEvent{ID: 2, RecordType: "books", RecordID: "9", Action: AuditCreate,
+			Data: JSONText(`{"id":9,"title":"Tintin in Peru","author":"Herge","deletedAt":null}`)}))
+		assert.Equal(t, sseEvent{"2", LiveInsert, `{"id":9,"title":"Tintin in Peru","author":"Herge","deletedAt":null}`}, nextSSE(t, r, nil))
+
+		_, err = repo.Create(ctx, Book{Title: "Tintin in Congo", Author: "Herge"})
+		assert.NoError(t, err)
+		flush()
+		assert.Equal(t, sseEvent{"3", LiveInsert, `{"id":3,"title":"Tintin in Congo","author":"Herge","deletedAt":null}`}, nextSSE(t, r, nil))
+
+		_, err = repo.Update(ctx, 1, "", func(book *Book) error {
+			book.Title = "Harry in Tibet"
+			return nil
+		})
+		assert.NoError(t, err)
+		flush()
+		assert.Equal(t, sseEvent{"4", LiveDelete, `{"id":1,"title":"Harry in Tibet","author":"Herge","deletedAt":null}`}, nextSSE(t, r, nil))
+
+		// a change outside of the result set is not sent
+		_, err = repo.Update(ctx, 2, "", func(book *Book) error {
+			book.Summary = "The boy who lived"
+			return nil
+		})
+		assert.NoError(t, err)
+		_, err = repo.Update(ctx, 3, "", func(book *Book) error {
+			book.Summary = "Tintin goes to Africa"
+			return nil
+		})
+		assert.NoError(t, err)
+		flush()
+		e := nextSSE(t, r, nil)
+		assert.Equal(t, "6", e.id)
+		assert.Equal(t, LiveUpdate, e.event)
+	})
+
+	t.Run("Resume Server-Sent Events", func(t *testing.T) {
+		sctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(sctx, http.MethodGet, server.URL+"/books/stream?query="+tintin, nil)
+		req.Header.Set("Last-Event-ID", "3")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("Http Error", err)
+		}
+		defer resp.Body.Close()
+		r := bufio.NewReader(resp.Body)
+
+		events := []string{}
+		for i := 0; i < 3; i++ {
+			e := nextSSE(t, r, nil)
+			events = append(events, e.id+" "+e.event)
+		}
+		assert.Equal(t, []string{"4 delete", "5 delete", "6 update"}, events)
+
+		heartbeats := 0
+		_, err = repo.Delete(ctx, 3, "")
+		assert.NoError(t, err)
+		time.Sleep(50 * time.Millisecond)
+		flush()
+		e := nextSSE(t, r, &heartbeats)
+		assert.Equal(t, "7", e.id)
+		assert.Equal(t, LiveDelete, e.event)
+		assert.Greater(t, heartbeats, 0)
+	})
+
+	t.Run("WebSocket", func(t *testing.T) {
+		conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+		if err != nil {
+			t.Fatal("Dial Error", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		harry := NewQuery(nil, NewCondition().Like("title", "Harry"), nil).String()
+		key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+		fmt.Fprintf(conn, "GET /books/stream?query=%s HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: %s\r\n\r\n", harry, key)
+		r := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(r, nil)
+		if err != nil {
+			t.Fatal("Handshake Error", err)
+		}
+		assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+		assert.Equal(t, "BACScCJPNqyz+UBoqMH89VmURoA=", resp.Header.Get("Sec-WebSocket-Accept"))
+
+		next := func() LiveEvent {
+			for {
+				opcode, payload := readTestFrame(t, r)
+				if opcode == wsText {
+					var event LiveEvent
+					assert.NoError(t, json.Unmarshal(payload, &event))
+					return event
+				}
+				assert.Equal(t, byte(wsPing), opcode)
+			}
+		}
+		event := next()
+		assert.Equal(t, uint(7), event.ID)
+		assert.Equal(t, LiveSnapshot, event.Event)
+		assert.Len(t, event.Data, 2)
+
+		_, err = repo.Create(ctx, Book{Title: "Harry Potter and the Chamber of Secrets", Author: "J. K. Rowling"})
+		assert.NoError(t, err)
+		flush()
+		event = next()
+		assert.Equal(t, LiveEvent{ID: 8, Event: LiveInsert, Data: map[string]any{
+			"id": 4.0, "title": "Harry Potter and the Chamber of Secrets", "author": "J. K. Rowling", "deletedAt": nil,
+		}}, event)
+
+		writeTestFrame(t, conn, wsClose, []byte{0x03, 0xe8})
+		for {
+			opcode, payload := readTestFrame(t, r)
+			if opcode == wsClose {
+				assert.Equal(t, []byte{0x03, 0xe8}, payload)
+				break
+			}
+		}
+	})
+}
+
+func TestRepository_WatchSnapshot(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	if err := MigrateOutbox(repo.DB.DB); err != nil {
+		t.Fatal("MigrateOutbox Error", err)
+	}
+	repo.Outbox = true
+	for _, title := range []string{"Tintin in Tibet", "Tintin in Congo", "Tintin in America"} {
+		_, err := repo.Create(ctx, Book{Title: title, Author: "Herge"})
+		assert.NoError(t, err)
+	}
+	limit, chunk := StreamSnapshotLimit, StreamSnapshotChunk
+	defer func() { StreamSnapshotLimit, StreamSnapshotChunk = limit, chunk }()
+	StreamSnapshotChunk = 2
+
+	wctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	chunks := []string{}
+	err := repo.Watch(wctx, NewQuery(nil, nil, nil).SortBy(Asc("id")), 0, func(event LiveEvent) error {
+		assert.Equal(t, LiveSnapshot, event.Event)
+		ids := []string{}
+		for _, book := range event.Data.([]Book) {
+			ids = append(ids, fmt.Sprint(book.ID))
+		}
+		chunks = append(chunks, fmt.Sprintf("%d %s", event.ID, strings.Join(ids, ",")))
+		if event.ID > 0 {
+			cancel()
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	// only the last chunk has the event to resume from
+	assert.Equal(t, []string{"0 1,2", "3 3"}, chunks)
+
+	StreamSnapshotLimit = 2
+	var badRequest *BadRequestError
+	err = repo.Watch(ctx, nil, 0, func(event LiveEvent) error {
+		t.Fatal("Unexpected event", event)
+		return nil
+	})
+	if assert.ErrorAs(t, err, &badRequest) {
+		assert.Equal(t, "Stream matches 3 records, more than 2", err.Error())
+	}
+}
+
+func readTestFrame(t *testing.T, r *bufio.Reader) (byte, []byte) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		t.Fatal("Read frame", err)
+	}
+	n := int(header[1] & 0x7f)
+	if n == 126 {
+		size := make([]byte, 2)
+		io.ReadFull(r, size)
+		n = int(size[0])<<8 | int(size[1])
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal("Read frame", err)
+	}
+	return header[0] & 0x0f, payload
+}
+
+func writeTestFrame(t *testing.T, w io.Writer, opcode byte, payload []byte) {
+	mask := []byte{1, 2, 3, 4}
+	frame := append([]byte{0x80 | opcode, 0x80 | byte(len(payload))}, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := w.Write(frame); err != nil {
+		t.Fatal("Write frame", err)
+	}
+}
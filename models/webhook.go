@@ -0,0 +1,568 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Headers of a webhook delivery. The ID is the one of the delivery, the same
+// for its retries, the event is "<type>.<action>" and the signature covers
+// the timestamp and the body, see SignWebhook.
+const (
+	WebhookIDHeader        = "X-Webhook-ID"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// States of a WebhookDelivery.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// StringList is a list of strings stored as json in a text column.
+type StringList []string
+
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal([]string(l))
+	return string(b), err
+}
+
+func (l *StringList) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, (*[]string)(l))
+	case string:
+		return json.Unmarshal([]byte(v), (*[]string)(l))
+	}
+	return fmt.Errorf("Invalid StringList %T", src)
+}
+
+func (StringList) GormDataType() string {
+	return "text"
+}
+
+// WebhookSubscription sends the outbox events of the records of Type, the
+// table of a registered model, to URL. Events narrows them to some Audit
+// actions and Condition, in the query json format, to the records it matches,
+// the record after the write or before a hard delete. URL is an http or https
+// one. Secret signs the deliveries and is never rendered.
+type WebhookSubscription struct {
+	ID        uint       `json:"id,omitempty" gorm:"primary_key"`
+	URL       string     `json:"url,omitempty"`
+	Type      string     `json:"type,omitempty"`
+	Events    StringList `json:"events,omitempty"`
+	Condition JSONText   `json:"condition,omitempty"`
+	Secret    string     `json:"-"`
+}
+
+// BeforeSave rejects a subscription to a URL of another scheme, or to an
+// unknown type, event or field.
+func (w *WebhookSubscription) BeforeSave(tx *gorm.DB) error {
+	ve := &ValidationError{Message: "INVALID INPUT"}
+	if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		ve.add("url", "url", "HTTP OR HTTPS URL")
+	}
+	model := registeredTable(tx, w.Type)
+	if model == nil {
+		ve.add("type", "type", "UNKNOWN TYPE %s", w.Type)
+	}
+	for i, event := range w.Events {
+		if !containsString([]string{AuditCreate, AuditUpdate, AuditDelete, AuditRestore}, event) {
+			ve.add(fmt.Sprintf("events[%d]", i), "events", "UNKNOWN EVENT %s", event)
+		}
+	}
+	if len(w.Condition) > 0 && model != nil {
+		cond := Condition{}
+		if err := json.Unmarshal(w.Condition, &cond); err != nil {
+			ve.add("condition", "condition", "INVALID CONDITION %v", err)
+		} else {
+			SchemaOf(model).checkCondition(ve, "condition", &cond)
+		}
+	}
+	if len(ve.Errors) > 0 {
+		return ve
+	}
+	return nil
+}
+
+// registeredTable returns the registered model stored in table, nil when
+// there is none.
+func registeredTable(tx *gorm.DB, table string) any {
+	for _, model := range registeredModels {
+		stmt := &gorm.Statement{DB: tx}
+		if err := stmt.Parse(model); err == nil && stmt.Schema.Table == table {
+			return model
+		}
+	}
+	return nil
+}
+
+// accepts tells whether the subscription wants the event.
+func (w *WebhookSubscription) accepts(event OutboxEvent) (bool, error) {
+	if w.Type != event.RecordType || len(w.Events) > 0 && !containsString(w.Events, event.Action) {
+		return false, nil
+	}
+	if len(w.Condition) == 0 {
+		return true, nil
+	}
+	cond := Condition{}
+	if err := json.Unmarshal(w.Condition, &cond); err != nil {
+		return false, err
+	}
+	record := map[string]any{}
+	if err := json.Unmarshal(event.Data, &record); err != nil {
+		return false, err
+	}
+	return cond.Match(record)
+}
+
+// WebhookDelivery is the delivery of an outbox event to a subscription and
+// its log: the attempts made, the status and error of the last one and when
+// the next one is due. Payload is the json of the OutboxEvent.
+type WebhookDelivery struct {
+	ID             uint       `json:"id" gorm:"primary_key"`
+	SubscriptionID uint       `json:"subscriptionId" gorm:"uniqueIndex:idx_webhook_deliveries_event,priority:1"`
+	EventID        uint       `json:"eventId" gorm:"uniqueIndex:idx_webhook_deliveries_event,priority:2"`
+	Event          string     `json:"event"`
+	Payload        JSONText   `json:"payload"`
+	State          string     `json:"state"`
+	Attempts       int        `json:"attempts"`
+	ResponseStatus int        `json:"responseStatus,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	NextAttemptAt  *time.Time `json:"nextAttemptAt,omitempty"`
+	DeliveredAt    *time.Time `json:"deliveredAt,omitempty"`
+}
+
+// WebhookDeadLetter is a delivery given up after MaxAttempts, kept with the
+// URL and payload it failed with.
+type WebhookDeadLetter struct {
+	ID             uint      `json:"id" gorm:"primary_key"`
+	DeliveryID     uint      `json:"deliveryId"`
+	SubscriptionID uint      `json:"subscriptionId"`
+	URL            string    `json:"url"`
+	Event          string    `json:"event"`
+	Payload        JSONText  `json:"payload"`
+	Attempts       int       `json:"attempts"`
+	LastError      string    `json:"lastError"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// MigrateWebhooks creates the tables of the subscriptions, deliveries and
+// dead letters.
+func MigrateWebhooks(db *gorm.DB) error {
+	return db.AutoMigrate(&WebhookSubscription{}, &WebhookDelivery{}, &WebhookDeadLetter{})
+}
+
+// SignWebhook returns the signature of a delivery of body at timestamp, in
+// unix seconds: "v1=" and the hex HMAC-SHA256 of "<timestamp>.<body>" keyed
+// by secret.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the signature headers of a delivery of body, for the
+// receivers. Deliveries signed more than tolerance away from now are refused
+// as replays.
+func VerifyWebhook(secret string, header http.Header, body []byte, tolerance time.Duration) error {
+	timestamp, err := strconv.ParseInt(header.Get(WebhookTimestampHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("Webhook timestamp error: %w", err)
+	}
+	if age := time.Since(time.Unix(timestamp, 0)); age > tolerance || age < -tolerance {
+		return errors.New("Webhook timestamp is out of tolerance")
+	}
+	if !hmac.Equal([]byte(header.Get(WebhookSignatureHeader)), []byte(SignWebhook(secret, timestamp, body))) {
+		return errors.New("Webhook signature mismatch")
+	}
+	return nil
+}
+
+// Webhooks delivers the outbox events to the subscriptions. It is a Sink of
+// an OutboxRelay, with MultiSink next to the Feed, queueing a delivery per
+// matching subscription, and Run POSTs the due deliveries. A failed attempt,
+// an error or a non 2xx status, is retried after Backoff doubling per attempt
+// up to MaxBackoff, the delivery is dead after MaxAttempts and copied to the
+// dead letters. Delivery is at least once and retries do not wait for each
+// other, receivers order the events of a record by their id. Run one per
+// database.
+//
+// The Client of NewWebhooks refuses to connect to the addresses of
+// nonPublicPrefixes, whatever the URL resolves to, so that a subscription
+// cannot reach the internal network. AllowAddress admits some of them, e.g.
+// the receivers of a trusted private network.
+type Webhooks struct {
+	DB           *DatabaseModel
+	Client       *http.Client
+	AllowAddress func(ip net.IP) bool
+	MaxAttempts  int
+	Backoff      time.Duration
+	MaxBackoff   time.Duration
+	Interval     time.Duration
+	BatchSize    int
+	now          clock
+}
+
+func NewWebhooks(db *DatabaseModel) *Webhooks {
+	w := &Webhooks{
+		DB:          db,
+		MaxAttempts: 8,
+		Backoff:     30 * time.Second,
+		MaxBackoff:  time.Hour,
+		Interval:    time.Second,
+		BatchSize:   100,
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: w.control}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would be the address checked instead of the target
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	w.Client = &http.Client{Timeout: 10 * time.Second, Transport: transport}
+	return w
+}
+
+// nonPublicPrefixes are the address ranges a webhook never connects to:
+// unspecified, "this network", loopback, private, shared (CGNAT),
+// link-local, benchmarking, multicast and reserved IPv4 ones, and the
+// unspecified, loopback, NAT64, unique local, link-local and multicast IPv6
+// ones. IPv4-mapped IPv6 addresses are checked as IPv4.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("224.0.0.0/4"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("::/128"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("ff00::/8"),
+}
+
+// control refuses the connections to the internal addresses, at dial time
+// after the name resolution and for every redirect.
+func (w *Webhooks) control(network string, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("Webhook address %s is not an ip", host)
+	}
+	addr = addr.Unmap()
+	if w.AllowAddress != nil && w.AllowAddress(net.IP(addr.AsSlice())) {
+		return nil
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return fmt.Errorf("Webhook address %s is not public", addr)
+		}
+	}
+	return nil
+}
+
+// Publish queues the deliveries of event, once per subscription when the
+// relay publishes it again.
+func (w *Webhooks) Publish(ctx context.Context, event OutboxEvent) error {
+	db := w.DB.orDefault()
+	subs := []WebhookSubscription{}
+	if err := db.DB.WithContext(ctx).Where("type = ?", event.RecordType).Order("id").Find(&subs).Error; err != nil {
+		return db.TranslateError(err)
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	now := w.now.Now()
+	for _, sub := range subs {
+		if ok, err := sub.accepts(event); err != nil {
+			log.Printf("Webhook subscription %d error: %v\n", sub.ID, err)
+			continue
+		} else if !ok {
+			continue
+		}
+		delivery := WebhookDelivery{
+			SubscriptionID: sub.ID,
+			EventID:        event.ID,
+			Event:          event.RecordType + "." + event.Action,
+			Payload:        payload,
+			State:          DeliveryPending,
+			NextAttemptAt:  &now,
+		}
+		if err := db.DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&delivery).Error; err != nil {
+			return db.TranslateError(err)
+		}
+	}
+	return nil
+}
+
+// Run delivers the due deliveries every Interval until ctx is done.
+func (w *Webhooks) Run(ctx context.Context) error {
+	return runEvery(ctx, w.Interval, "Webhooks", func(ctx context.Context) error {
+		_, err := w.Deliver(ctx)
+		return err
+	})
+}
+
+// Deliver attempts the due deliveries once and returns how many succeeded.
+// The delivery errors are recorded on the deliveries, the returned error is
+// a database one.
+func (w *Webhooks) Deliver(ctx context.Context) (int, error) {
+	db := w.DB.orDefault()
+	size := w.BatchSize
+	if size <= 0 {
+		size = 100
+	}
+	delivered := 0
+	subs := map[uint]*WebhookSubscription{}
+	var last uint
+	for {
+		deliveries := []WebhookDelivery{}
+		if err := db.DB.WithContext(ctx).Where("state = ? AND next_attempt_at <= ? AND id > ?", DeliveryPending, w.now.Now(), last).
+			Order("id").Limit(size).Find(&deliveries).Error; err != nil {
+			return delivered, db.TranslateError(err)
+		}
+		for _, delivery := range deliveries {
+			last = delivery.ID
+			sub, ok := subs[delivery.SubscriptionID]
+			if !ok {
+				sub = &WebhookSubscription{}
+				if err := db.DB.WithContext(ctx).Where("id = ?", delivery.SubscriptionID).Limit(1).Find(sub).Error; err != nil {
+					return delivered, db.TranslateError(err)
+				}
+				subs[delivery.SubscriptionID] = sub
+			}
+			if sub.ID == 0 {
+				delivery.State, delivery.NextAttemptAt = DeliveryDead, nil
+				delivery.LastError = fmt.Sprintf("Subscription %d is deleted", delivery.SubscriptionID)
+				if err := db.DB.WithContext(ctx).Save(&delivery).Error; err != nil {
+					return delivered, db.TranslateError(err)
+				}
+				continue
+			}
+			if err := w.attempt(ctx, sub, &delivery); err != nil {
+				return delivered, db.TranslateError(err)
+			}
+			if delivery.State == DeliveryDelivered {
+				delivered++
+			}
+		}
+		if len(deliveries) < size {
+			return delivered, nil
+		}
+	}
+}
+
+// attempt sends delivery to sub and records the outcome.
+func (w *Webhooks) attempt(ctx context.Context, sub *WebhookSubscription, delivery *WebhookDelivery) error {
+	db := w.DB.orDefault()
+	status, err := w.send(ctx, sub, delivery)
+	now := w.now.Now()
+	delivery.Attempts++
+	delivery.ResponseStatus = status
+	if err == nil {
+		delivery.State, delivery.LastError = DeliveryDelivered, ""
+		delivery.NextAttemptAt, delivery.DeliveredAt = nil, &now
+		return db.DB.WithContext(ctx).Save(delivery).Error
+	}
+	delivery.LastError = err.Error()
+	if delivery.Attempts < w.MaxAttempts {
+		next := now.Add(w.backoff(delivery.Attempts))
+		delivery.NextAttemptAt = &next
+		return db.DB.WithContext(ctx).Save(delivery).Error
+	}
+	delivery.State, delivery.NextAttemptAt = DeliveryDead, nil
+	return db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(delivery).Error; err != nil {
+			return err
+		}
+		return tx.Create(&WebhookDeadLetter{
+			DeliveryID:     delivery.ID,
+			SubscriptionID: sub.ID,
+			URL:            sub.URL,
+			Event:          delivery.Event,
+			Payload:        delivery.Payload,
+			Attempts:       delivery.Attempts,
+			LastError:      delivery.LastError,
+		}).Error
+	})
+}
+
+// backoff is the wait after the given number of failed attempts.
+func (w *Webhooks) backoff(attempts int) time.Duration {
+	d := w.Backoff
+	for i := 1; i < attempts && d < w.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > w.MaxBackoff {
+		return w.MaxBackoff
+	}
+	return d
+}
+
+// send POSTs the signed payload of delivery and returns the response status.
+func (w *Webhooks) send(ctx context.Context, sub *WebhookSubscription, delivery *WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := w.now.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookIDHeader, strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set(WebhookEventHeader, delivery.Event)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(sub.Secret, timestamp, delivery.Payload))
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("Webhook %s responded %s", sub.URL, resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Deliveries returns a page of the deliveries of subscription id, the latest
+// first, only the ones in state when it is not empty.
+func (w *Webhooks) Deliveries(ctx context.Context, id any, state string, offset int, limit int) (Page[WebhookDelivery], error) {
+	page := Page[WebhookDelivery]{Data: []WebhookDelivery{}}
+	tx, err := w.subscriptionLog(ctx, id, &WebhookDelivery{})
+	if err != nil {
+		return page, err
+	}
+	if state != "" {
+		tx = tx.Where("state = ?", state)
+	}
+	return page, w.page(tx, offset, limit, &page.Count, &page.Data)
+}
+
+// DeadLetters returns a page of the dead letters of subscription id, the
+// latest first.
+func (w *Webhooks) DeadLetters(ctx context.Context, id any, offset int, limit int) (Page[WebhookDeadLetter], error) {
+	page := Page[WebhookDeadLetter]{Data: []WebhookDeadLetter{}}
+	tx, err := w.subscriptionLog(ctx, id, &WebhookDeadLetter{})
+	if err != nil {
+		return page, err
+	}
+	return page, w.page(tx, offset, limit, &page.Count, &page.Data)
+}
+
+// subscriptionLog scopes model to the rows of subscription id, NotFoundError
+// when it does not exist.
+func (w *Webhooks) subscriptionLog(ctx context.Context, id any, model any) (*gorm.DB, error) {
+	db := w.DB.orDefault()
+	if err := db.DB.WithContext(ctx).Where("id = ?", id).First(&WebhookSubscription{}).Error; err != nil {
+		return nil, db.TranslateError(err)
+	}
+	return db.DB.WithContext(ctx).Model(model).Where("subscription_id = ?", id), nil
+}
+
+func (w *Webhooks) page(tx *gorm.DB, offset int, limit int, count **int64, data any) error {
+	var n int64
+	if err := tx.Count(&n).Error; err != nil {
+		return w.DB.orDefault().TranslateError(err)
+	}
+	*count = &n
+	tx = tx.Order("id DESC")
+	if offset > 0 {
+		tx = tx.Offset(offset)
+	}
+	if limit > 0 {
+		tx = tx.Limit(limit)
+	}
+	if err := tx.Find(data).Error; err != nil {
+		return w.DB.orDefault().TranslateError(err)
+	}
+	return nil
+}
+
+// WebhookDeliveries responds with a page of the deliveries of the
+// subscription :id, the state parameter narrows them to one state.
+func WebhookDeliveries(c *gin.Context, webhooks *Webhooks) {
+	db := webhooks.DB.orDefault()
+	opts := PageOptions{Limit: 1000}
+	if err := pageParams(c, &opts); err != nil {
+		db.ErrorJSON(c, err)
+		return
+	}
+	state := c.Query("state")
+	if state != "" && !containsString([]string{DeliveryPending, DeliveryDelivered, DeliveryDead}, strings.ToLower(state)) {
+		db.ErrorJSON(c, &BadRequestError{Err: fmt.Errorf("Unknown state %s", state)})
+		return
+	}
+
+	page, err := webhooks.Deliveries(c.Request.Context(), c.Param("id"), strings.ToLower(state), opts.Offset, opts.Limit)
+	if err != nil {
+		db.ErrorJSON(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// WebhookDeadLetters responds with a page of the dead letters of the
+// subscription :id.
+func WebhookDeadLetters(c *gin.Context, webhooks *Webhooks) {
+	db := webhooks.DB.orDefault()
+	opts := PageOptions{Limit: 1000}
+	if err := pageParams(c, &opts); err != nil {
+		db.ErrorJSON(c, err)
+		return
+	}
+
+	page, err := webhooks.DeadLetters(c.Request.Context(), c.Param("id"), opts.Offset, opts.Limit)
+	if err != nil {
+		db.ErrorJSON(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
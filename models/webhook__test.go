@@ -0,0 +1,235 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignWebhook(t *testing.T) {
+	body := []byte(`{"id":1}`)
+	now := time.Now().Unix()
+	header := http.Header{}
+	header.Set(WebhookTimestampHeader, strconv.FormatInt(now, 10))
+	header.Set(WebhookSignatureHeader, SignWebhook("0123456789abcdef", now, body))
+	assert.NoError(t, VerifyWebhook("0123456789abcdef", header, body, time.Minute))
+	assert.EqualError(t, VerifyWebhook("fedcba9876543210", header, body, time.Minute), "Webhook signature mismatch")
+	assert.EqualError(t, VerifyWebhook("0123456789abcdef", header, []byte(`{"id":2}`), time.Minute), "Webhook signature mismatch")
+
+	old := now - 600
+	header.Set(WebhookTimestampHeader, strconv.FormatInt(old, 10))
+	header.Set(WebhookSignatureHeader, SignWebhook("0123456789abcdef", old, body))
+	assert.EqualError(t, VerifyWebhook("0123456789abcdef", header, body, time.Minute), "Webhook timestamp is out of tolerance")
+	assert.Equal(t, "v1=", SignWebhook("0123456789abcdef", old, body)[:3])
+}
+
+func TestWebhooks(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	RegisterModel(&Book{})
+	if err := MigrateOutbox(repo.DB.DB); err != nil {
+		t.Fatal("MigrateOutbox Error", err)
+	}
+	if err := MigrateWebhooks(repo.DB.DB); err != nil {
+		t.Fatal("MigrateWebhooks Error", err)
+	}
+	repo.Outbox = true
+	db := repo.DB.DB
+
+	var ve *ValidationError
+	err := db.Create(&WebhookSubscription{URL: "file:///etc/passwd", Type: "comics", Events: StringList{"create"}}).Error
+	if assert.ErrorAs(t, err, &ve) {
+		assert.Equal(t, []FieldError{
+			{Path: "url", Field: "url", Message: "HTTP OR HTTPS URL"},
+			{Path: "type", Field: "type", Message: "UNKNOWN TYPE comics"},
+		}, ve.Errors)
+	}
+	err = db.Create(&WebhookSubscription{URL: "http://localhost", Type: "books", Events: StringList{"publish"},
+		Condition: JSONText(`{"e":[{"o":"=","f":"publisher","v":"Casterman"}]}`)}).Error
+	if assert.ErrorAs(t, err, &ve) {
+		assert.Equal(t, []FieldError{
+			{Path: "events[0]", Field: "events", Message: "UNKNOWN EVENT publish"},
+			{Path: "condition.e[0].f", Field: "publisher", Message: "UNKNOWN FIELD publisher"},
+		}, ve.Errors)
+	}
+
+	const secret = "0123456789abcdef"
+	received := []OutboxEvent{}
+	tintin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := VerifyWebhook(secret, r.Header, body, 5*time.Minute); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		assert.Equal(t, "books.update", r.Header.Get(WebhookEventHeader))
+		var event OutboxEvent
+		assert.NoError(t, json.Unmarshal(body, &event))
+		received = append(received, event)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer tintin.Close()
+	attempts := 0
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	condition, _ := json.Marshal(NewCondition().Like("title", "Tintin"))
+	updates := WebhookSubscription{URL: tintin.URL, Type: "books", Events: StringList{AuditUpdate}, Condition: condition, Secret: secret}
+	assert.NoError(t, db.Create(&updates).Error)
+	all := WebhookSubscription{URL: down.URL, Type: "books", Secret: secret}
+	assert.NoError(t, db.Create(&all).Error)
+
+	_, err = repo.Create(ctx, Book{Title: "Tintin in Tibet", Author: "Herge"})
+	assert.NoError(t, err)
+	_, err = repo.Create(ctx, Book{Title: "Harry Potter and the Philosopher's Stone", Author: "J. K. Rowling"})
+	assert.NoError(t, err)
+	for _, id := range []uint{1, 2} {
+		_, err = repo.Update(ctx, id, "", func(book *Book) error {
+			book.Summary = "Summary"
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+
+	now := time.Now()
+	webhooks := NewWebhooks(repo.DB)
+	webhooks.MaxAttempts = 3
+	webhooks.AllowAddress = func(ip net.IP) bool { return ip.IsLoopback() }
+	webhooks.now = func() time.Time { return now }
+	relay := NewOutboxRelay(repo.DB, MultiSink{repo.DB.Feed, webhooks})
+	n, err := relay.Flush(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	// a redelivered event is not queued twice
+	var event OutboxEvent
+	assert.NoError(t, db.Where("record_id = ? AND action = ?", "1", AuditUpdate).First(&event).Error)
+	assert.NoError(t, webhooks.Publish(ctx, event))
+
+	n, err = webhooks.Deliver(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	if assert.Len(t, received, 1) {
+		assert.Equal(t, event.ID, received[0].ID)
+		assert.JSONEq(t, `{"id":1,"title":"Tintin in Tibet","author":"Herge","summary":"Summary","deletedAt":null}`, string(received[0].Data))
+	}
+	assert.Equal(t, 4, attempts)
+
+	page, err := webhooks.Deliveries(ctx, all.ID, DeliveryPending, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), *page.Count)
+	if assert.Len(t, page.Data, 4) {
+		delivery := page.Data[0]
+		assert.Equal(t, "books.update", delivery.Event)
+		assert.Equal(t, 1, delivery.Attempts)
+		assert.Equal(t, http.StatusServiceUnavailable, delivery.ResponseStatus)
+		assert.Equal(t, "Webhook "+down.URL+" responded 503 Service Unavailable", delivery.LastError)
+		assert.True(t, now.Add(30*time.Second).Equal(*delivery.NextAttemptAt))
+	}
+
+	// retried after the backoff only
+	n, err = webhooks.Deliver(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, 4, attempts)
+	now = now.Add(30 * time.Second)
+	_, err = webhooks.Deliver(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, attempts)
+	now = now.Add(30 * time.Second)
+	_, err = webhooks.Deliver(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, attempts)
+	now = now.Add(30 * time.Second)
+	_, err = webhooks.Deliver(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 12, attempts)
+
+	page, err = webhooks.Deliveries(ctx, all.ID, DeliveryDead, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), *page.Count)
+	letters, err := webhooks.DeadLetters(ctx, all.ID, 0, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, letters.Data, 4) {
+		assert.Equal(t, down.URL, letters.Data[0].URL)
+		assert.Equal(t, 3, letters.Data[0].Attempts)
+		assert.Equal(t, page.Data[0].Payload, letters.Data[0].Payload)
+	}
+	now = now.Add(time.Hour)
+	_, err = webhooks.Deliver(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 12, attempts)
+
+	_, err = webhooks.Deliveries(ctx, 99, "", 0, 10)
+	var notFound *NotFoundError
+	assert.True(t, errors.As(err, &notFound))
+}
+
+func TestWebhooks_Address(t *testing.T) {
+	received := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = true
+	}))
+	defer server.Close()
+
+	webhooks := NewWebhooks(nil)
+	sub := &WebhookSubscription{URL: server.URL, Secret: "0123456789abcdef"}
+	_, err := webhooks.send(context.Background(), sub, &WebhookDelivery{Payload: JSONText(`{}`)})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Webhook address 127.0.0.1 is not public")
+	}
+	assert.False(t, received)
+
+	for address, public := range map[string]bool{
+		"10.1.2.3:80":                false,
+		"192.168.1.1:443":            false,
+		"169.254.169.254:80":         false,
+		"0.0.0.0:80":                 false,
+		"0.1.2.3:80":                 false,
+		"100.64.0.1:80":              false,
+		"100.127.255.254:80":         false,
+		"224.0.0.1:80":               false,
+		"239.255.255.250:80":         false,
+		"255.255.255.255:80":         false,
+		"[::1]:80":                   false,
+		"[::]:80":                    false,
+		"[fd00::1]:80":               false,
+		"[fe80::1]:80":               false,
+		"[ff02::1]:80":               false,
+		"[64:ff9b::a01:203]:80":      false,
+		"[::ffff:127.0.0.1]:80":      false,
+		"[::ffff:10.1.2.3]:80":       false,
+		"100.128.0.1:443":            true,
+		"93.184.216.34:443":          true,
+		"[::ffff:93.184.216.34]:443": true,
+		"[2606:4700::1]:443":         true,
+	} {
+		err := webhooks.control("tcp", address, nil)
+		assert.Equal(t, public, err == nil, address)
+	}
+	webhooks.AllowAddress = func(ip net.IP) bool { return ip.Equal(net.ParseIP("10.1.2.3")) }
+	assert.NoError(t, webhooks.control("tcp", "10.1.2.3:80", nil))
+	assert.Error(t, webhooks.control("tcp", "10.1.2.4:80", nil))
+}
+
+func TestWebhooks_Backoff(t *testing.T) {
+	webhooks := NewWebhooks(nil)
+	backoffs := []time.Duration{}
+	for attempts := 1; attempts <= 9; attempts++ {
+		backoffs = append(backoffs, webhooks.backoff(attempts))
+	}
+	assert.Equal(t, []time.Duration{
+		30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute,
+		16 * time.Minute, 32 * time.Minute, time.Hour, time.Hour,
+	}, backoffs)
+}
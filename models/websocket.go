@@ -0,0 +1,257 @@
+package models
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The server side of RFC 6455 needed by the live queries: text messages out,
+// control frames in.
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xa
+)
+
+// Close codes of RFC 6455.
+const (
+	wsNormalClosure = 1000
+	wsProtocolError = 1002
+	wsMessageTooBig = 1009
+	wsInternalError = 1011
+	wsTryAgainLater = 1013
+)
+
+// wsMaxControlPayload is the largest payload of a control frame.
+const wsMaxControlPayload = 125
+
+// WebSocketWriteTimeout bounds a write to a WebSocket, a client not reading
+// for longer is disconnected.
+var WebSocketWriteTimeout = 10 * time.Second
+
+// wsMaxPayload bounds the frames read from the client, they are only control
+// frames and ignored messages.
+const wsMaxPayload = 1 << 16
+
+// WebSocketOrigin tells whether to accept a handshake from the Origin header
+// of r. Nil accepts the handshakes without one, from clients other than
+// browsers, and the ones from the host of r, so that the pages of other
+// sites cannot read the live queries with the cookies of their visitors.
+var WebSocketOrigin func(r *http.Request) bool
+
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// isWebSocket tells a WebSocket handshake from a plain request.
+func isWebSocket(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+func headerHasToken(h http.Header, name string, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsCloseError fails the connection with Code, for a frame breaking the
+// protocol.
+type wsCloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *wsCloseError) Error() string {
+	return e.Reason
+}
+
+// wsConn is a WebSocket taken over from the http server. Nothing is written
+// after the close frame.
+type wsConn struct {
+	conn   net.Conn
+	rw     *bufio.ReadWriter
+	mu     sync.Mutex
+	closed bool
+}
+
+// checkWebSocket validates a handshake before the connection is upgraded.
+func checkWebSocket(r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return &BadRequestError{Err: fmt.Errorf("WebSocket handshake must be a GET, not a %s", r.Method)}
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return &BadRequestError{Err: errors.New("WebSocket version 13 is required")}
+	}
+	if r.Header.Get("Sec-WebSocket-Key") == "" {
+		return &BadRequestError{Err: errors.New("WebSocket key is missing")}
+	}
+	allow := WebSocketOrigin
+	if allow == nil {
+		allow = sameOrigin
+	}
+	if !allow(r) {
+		return &ForbiddenError{Err: fmt.Errorf("WebSocket origin %s is not allowed", r.Header.Get("Origin"))}
+	}
+	return nil
+}
+
+// upgradeWebSocket answers the handshake and takes the connection over from
+// the http server.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if err := checkWebSocket(r); err != nil {
+		return nil, err
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("WebSocket is not supported by the server")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " +
+		base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, rw: rw}, nil
+}
+
+func (ws *wsConn) writeFrame(opcode byte, payload []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.closed {
+		return net.ErrClosed
+	}
+	ws.closed = opcode == wsClose
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xffff:
+		header = append(header, 126, byte(n>>8), byte(n))
+	default:
+		size := make([]byte, 8)
+		binary.BigEndian.PutUint64(size, uint64(n))
+		header = append(append(header, 127), size...)
+	}
+	ws.conn.SetWriteDeadline(time.Now().Add(WebSocketWriteTimeout))
+	if _, err := ws.rw.Write(header); err != nil {
+		return err
+	}
+	if _, err := ws.rw.Write(payload); err != nil {
+		return err
+	}
+	return ws.rw.Flush()
+}
+
+// readFrame returns the next frame of the client, unmasked. A frame breaking
+// the protocol is a wsCloseError.
+func (ws *wsConn) readFrame() (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(ws.rw, header); err != nil {
+		return 0, nil, err
+	}
+	opcode := header[0] & 0x0f
+	control := opcode&0x8 != 0
+	if header[1]&0x80 == 0 {
+		return 0, nil, &wsCloseError{Code: wsProtocolError, Reason: "Client frames must be masked"}
+	}
+	if control && header[0]&0x80 == 0 {
+		return 0, nil, &wsCloseError{Code: wsProtocolError, Reason: "Control frames must not be fragmented"}
+	}
+	n := uint64(header[1] & 0x7f)
+	switch n {
+	case 126:
+		size := make([]byte, 2)
+		if _, err := io.ReadFull(ws.rw, size); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(size))
+	case 127:
+		size := make([]byte, 8)
+		if _, err := io.ReadFull(ws.rw, size); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(size)
+	}
+	if control && n > wsMaxControlPayload {
+		return 0, nil, &wsCloseError{Code: wsProtocolError, Reason: "Control frame is too large"}
+	}
+	if n > wsMaxPayload {
+		return 0, nil, &wsCloseError{Code: wsMessageTooBig, Reason: "Frame is too large"}
+	}
+	mask := make([]byte, 4)
+	if _, err := io.ReadFull(ws.rw, mask); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(ws.rw, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// readLoop answers the pings of the client and calls done when it closes the
+// connection, stops reading or breaks the protocol.
+func (ws *wsConn) readLoop(done func()) {
+	defer done()
+	for {
+		opcode, payload, err := ws.readFrame()
+		var closeErr *wsCloseError
+		if errors.As(err, &closeErr) {
+			ws.close(closeErr.Code, closeErr.Reason)
+			return
+		} else if err != nil {
+			return
+		}
+		switch opcode {
+		case wsClose:
+			if len(payload) > 2 {
+				payload = payload[:2]
+			}
+			ws.writeFrame(wsClose, payload)
+			return
+		case wsPing:
+			ws.writeFrame(wsPong, payload)
+		}
+	}
+}
+
+// close sends a close frame with code and reason and drops the connection.
+func (ws *wsConn) close(code int, reason string) error {
+	payload := append([]byte{byte(code >> 8), byte(code)}, reason...)
+	ws.writeFrame(wsClose, payload)
+	return ws.conn.Close()
+}
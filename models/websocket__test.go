@@ -0,0 +1,91 @@
+package models
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebSocket_Handshake(t *testing.T) {
+	repo := newTestRepository(t)
+	if err := MigrateOutbox(repo.DB.DB); err != nil {
+		t.Fatal("MigrateOutbox Error", err)
+	}
+	repo.Outbox = true
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	engine.Any("/books/stream", func(c *gin.Context) {
+		Stream(c, repo)
+	})
+	server := httptest.NewServer(engine)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	handshake := func(method string, origin string) (net.Conn, *bufio.Reader, *http.Response) {
+		conn, err := net.Dial("tcp", host)
+		if err != nil {
+			t.Fatal("Dial Error", err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		fmt.Fprintf(conn, "%s /books/stream HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: MDEyMzQ1Njc4OWFiY2RlZg==\r\n", method, host)
+		if origin != "" {
+			fmt.Fprintf(conn, "Origin: %s\r\n", origin)
+		}
+		fmt.Fprint(conn, "\r\n")
+		r := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(r, nil)
+		if err != nil {
+			t.Fatal("Handshake Error", err)
+		}
+		return conn, r, resp
+	}
+	refused := func(method string, origin string, status int, detail string) {
+		conn, _, resp := handshake(method, origin)
+		defer conn.Close()
+		assert.Equal(t, status, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Contains(t, string(body), detail)
+	}
+
+	refused(http.MethodPost, "", http.StatusBadRequest, "WebSocket handshake must be a GET, not a POST")
+	refused(http.MethodGet, "http://evil.example", http.StatusForbidden, "WebSocket origin http://evil.example is not allowed")
+	WebSocketOrigin = func(r *http.Request) bool { return r.Header.Get("Origin") == "http://app.example" }
+	defer func() { WebSocketOrigin = nil }()
+	conn, _, resp := handshake(http.MethodGet, "http://app.example")
+	conn.Close()
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	WebSocketOrigin = nil
+
+	// an invalid control frame fails the connection with a protocol error
+	for name, frame := range map[string][]byte{
+		"fragmented ping": {wsPing, 0x80, 1, 2, 3, 4},
+		"large ping":      append([]byte{0x80 | wsPing, 0x80 | 126, 0, 126, 1, 2, 3, 4}, make([]byte, 126)...),
+	} {
+		conn, r, resp := handshake(http.MethodGet, "http://"+host)
+		assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode, name)
+		opcode, _ := readTestFrame(t, r)
+		assert.Equal(t, byte(wsText), opcode, name)
+		if _, err := conn.Write(frame); err != nil {
+			t.Fatal("Write frame", err)
+		}
+		for {
+			opcode, payload := readTestFrame(t, r)
+			if opcode == wsClose {
+				assert.Equal(t, []byte{0x03, 0xea}, payload[:2], name)
+				break
+			}
+		}
+		_, err := r.ReadByte()
+		assert.ErrorIs(t, err, io.EOF, name)
+		conn.Close()
+	}
+}
@@ -1,8 +1,11 @@
 package test_base
 
 import (
+	"encoding/json"
 	"log"
+	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"testing"
 
@@ -11,6 +14,7 @@ import (
 	"github.com/senomas/go-api/controllers"
 	"github.com/senomas/go-api/models"
 	test_lib "github.com/senomas/go-api/test/lib"
+	"github.com/stretchr/testify/assert"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
@@ -36,10 +40,28 @@ type ResponseBook struct {
 	Data models.Book `json:"data"`
 }
 
+type ResponseRevisions struct {
+	Count int64             `json:"count"`
+	Data  []models.Revision `json:"data"`
+}
+
+// admin lets the requests of the admin actor only through.
+func admin(c *gin.Context) {
+	if c.GetHeader(models.ActorHeader) != "admin" {
+		c.Header("Content-Type", models.ProblemContentType)
+		c.AbortWithStatusJSON(http.StatusForbidden, models.Problem{
+			Type:   "about:blank",
+			Title:  "Forbidden",
+			Status: http.StatusForbidden,
+			Detail: "Admin only",
+		})
+	}
+}
+
 func NewTestContext(t *testing.T, dialector gorm.Dialector, mock sqlmock.Sqlmock, initMock func(name string)) *TestContext {
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.New()
-	controllers.SetupRoutes(r)
+	controllers.SetupRoutes(r, admin)
 	server := httptest.NewServer(r)
 
 	ctx := &TestContext{Api: &test_lib.Api{Server: server, T: t}, dialector: dialector, mock: mock, initMock: initMock}
@@ -64,14 +86,15 @@ func NewTestContext(t *testing.T, dialector gorm.Dialector, mock sqlmock.Sqlmock
 		t.Fatal("Init GORM Error", err)
 	} else {
 		if mock == nil {
-			db.Migrator().DropTable(&models.Book{})
+			db.Migrator().DropTable(&models.Book{}, "books_search", &models.Revision{}, &models.OutboxEvent{},
+				&models.WebhookSubscription{}, &models.WebhookDelivery{}, &models.WebhookDeadLetter{}, &models.IdempotencyRecord{})
 		}
 		models.Setup(db)
 		ctx.db = db
 	}
 
 	defer ctx.startMock("AutoMigrate")()
-	ctx.db.AutoMigrate(&models.Book{})
+	models.AutoMigrate(ctx.db)
 
 	return ctx
 }
@@ -116,7 +139,7 @@ func TestBookCRUD(t *testing.T, dialector gorm.Dialector, mock sqlmock.Sqlmock,
 			Title:   "Harry Potter and the Philosopher's Stone",
 			Author:  "J. K. Rawling",
 			Summary: "The boy who lived",
-		}, 200, ResponseBook{
+		}, 201, ResponseBook{
 			Data: models.Book{
 				ID:      1,
 				Title:   "Harry Potter and the Philosopher's Stone",
@@ -124,6 +147,7 @@ func TestBookCRUD(t *testing.T, dialector gorm.Dialector, mock sqlmock.Sqlmock,
 				Summary: "The boy who lived",
 			},
 		})
+		assert.Equal(t, "/books/1", ctx.Api.Response.Header.Get("Location"))
 	})
 
 	t.Run("Insert Harry Potter and the Chamber of Secrets", func(t *testing.T) {
@@ -132,7 +156,7 @@ func TestBookCRUD(t *testing.T, dialector gorm.Dialector, mock sqlmock.Sqlmock,
 		ctx.Api.HttpPut("/books", controllers.CreateBookInput{
 			Title:  "Harry Potter and the Chamber of Secrets",
 			Author: "J. K. Rawling",
-		}, 200, ResponseBook{
+		}, 201, ResponseBook{
 			Data: models.Book{
 				ID:     2,
 				Title:  "Harry Potter and the Chamber of Secrets",
@@ -182,10 +206,6 @@ func TestBookCRUD(t *testing.T, dialector gorm.Dialector, mock sqlmock.Sqlmock,
 	})
 
 	t.Run("Finds chamber of secrets", func(t *testing.T) {
-		if ctx.dialector.Name() == "sqlite" || ctx.dialector.Name() == "mysql" {
-			t.Skipf("SKIP: same behaviour as ilike in %v", ctx.dialector.Name())
-		}
-
 		defer ctx.startMock(t.Name())()
 
 		ctx.Api.HttpPost(
@@ -199,10 +219,6 @@ func TestBookCRUD(t *testing.T, dialector gorm.Dialector, mock sqlmock.Sqlmock,
 	})
 
 	t.Run("Finds chamber of secrets using ILIKE", func(t *testing.T) {
-		if ctx.dialector.Name() == "sqlite" || ctx.dialector.Name() == "mysql" {
-			t.Skipf("SKIP: same behaviour as ilike in %v", ctx.dialector.Name())
-		}
-
 		defer ctx.startMock(t.Name())()
 
 		ctx.Api.HttpPost(
@@ -227,7 +243,7 @@ func TestBookCRUD(t *testing.T, dialector gorm.Dialector, mock sqlmock.Sqlmock,
 		ctx.Api.HttpPut("/books", controllers.CreateBookInput{
 			Title:  "Harry Potter and Book of Dark Magic",
 			Author: "Lord Voldermort",
-		}, 200, ResponseBook{
+		}, 201, ResponseBook{
 			Data: models.Book{
 				ID:     3,
 				Title:  "Harry Potter and Book of Dark Magic",
@@ -292,7 +308,7 @@ func TestBookCRUD(t *testing.T, dialector gorm.Dialector, mock sqlmock.Sqlmock,
 		ctx.Api.HttpPut("/books", controllers.CreateBookInput{
 			Title:  "Tintin in Tibet",
 			Author: "Herge",
-		}, 200, ResponseBook{
+		}, 201, ResponseBook{
 			Data: models.Book{
 				ID:     4,
 				Title:  "Tintin in Tibet",
@@ -427,7 +443,7 @@ func TestBookCRUD(t *testing.T, dialector gorm.Dialector, mock sqlmock.Sqlmock,
 		ctx.Api.HttpPut("/books", controllers.CreateBookInput{
 			Title:  "Tintin in Jakarta",
 			Author: "Herge",
-		}, 200, ResponseBook{
+		}, 201, ResponseBook{
 			Data: models.Book{
 				ID:     5,
 				Title:  "Tintin in Jakarta",
@@ -524,8 +540,15 @@ func TestBookCRUD(t *testing.T, dialector gorm.Dialector, mock sqlmock.Sqlmock,
 		ctx.Api.HttpPut("/books", controllers.CreateBookInput{
 			Title:  "Tintin in America",
 			Author: "Herge",
-		}, 400, map[string]string{
-			"error": "Duplicate value books.title",
+		}, 409, models.Problem{
+			Type:   "about:blank",
+			Title:  "Conflict",
+			Status: 409,
+			Detail: "Duplicate value books.title",
+			Code:   "unique_violation",
+			Errors: []models.FieldError{
+				{Path: "title", Field: "title", Message: "unique_violation"},
+			},
 		})
 	})
 
@@ -535,24 +558,33 @@ func TestBookCRUD(t *testing.T, dialector gorm.Dialector, mock sqlmock.Sqlmock,
 		ctx.Api.HttpPatch("/books/9999", controllers.CreateBookInput{
 			Title:  "Book of Unknown",
 			Author: "John Doe",
-		}, 400, map[string]string{
-			"error": "record not found",
+		}, 404, models.Problem{
+			Type:   "about:blank",
+			Title:  "Not Found",
+			Status: 404,
+			Detail: "record not found",
 		})
 	})
 
 	t.Run("Delete unknown book", func(t *testing.T) {
 		defer ctx.startMock(t.Name())()
 
-		ctx.Api.HttpDelete("/books/9999", 400, map[string]string{
-			"error": "record not found",
+		ctx.Api.HttpDelete("/books/9999", 404, models.Problem{
+			Type:   "about:blank",
+			Title:  "Not Found",
+			Status: 404,
+			Detail: "record not found",
 		})
 	})
 
 	t.Run("Get unknown book", func(t *testing.T) {
 		defer ctx.startMock(t.Name())()
 
-		ctx.Api.HttpGet("/books/9999", 400, map[string]string{
-			"error": "record not found",
+		ctx.Api.HttpGet("/books/9999", 404, models.Problem{
+			Type:   "about:blank",
+			Title:  "Not Found",
+			Status: 404,
+			Detail: "record not found",
 		})
 	})
 
@@ -562,8 +594,15 @@ func TestBookCRUD(t *testing.T, dialector gorm.Dialector, mock sqlmock.Sqlmock,
 		ctx.Api.HttpPatch("/books/5", controllers.UpdateBookInput{
 			Title:  "Harry Potter and the Philosopher's Stone",
 			Author: "Herge",
-		}, 400, map[string]string{
-			"error": "Duplicate value books.title",
+		}, 409, models.Problem{
+			Type:   "about:blank",
+			Title:  "Conflict",
+			Status: 409,
+			Detail: "Duplicate value books.title",
+			Code:   "unique_violation",
+			Errors: []models.FieldError{
+				{Path: "title", Field: "title", Message: "unique_violation"},
+			},
 		})
 	})
 
@@ -595,4 +634,645 @@ func TestBookCRUD(t *testing.T, dialector gorm.Dialector, mock sqlmock.Sqlmock,
 				},
 			})
 	})
+
+	t.Run("Finds with unknown field", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		ctx.Api.HttpPost("/books",
+			models.NewQuery(models.Fields("id", "password"), models.NewCondition().Equal("password", "secret"), nil),
+			422,
+			models.Problem{
+				Type:   "about:blank",
+				Title:  "Unprocessable Entity",
+				Status: 422,
+				Detail: "INVALID QUERY condition.e[0].f: UNKNOWN FIELD password",
+				Errors: []models.FieldError{
+					{Path: "condition.e[0].f", Field: "password", Message: "UNKNOWN FIELD password"},
+				},
+			})
+	})
+
+	t.Run("Finds books by id list", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		ctx.Api.HttpPost("/books",
+			models.NewQuery(models.Fields("id", "title"), models.NewCondition().In("id", 1, 4).IsNotNull("title"), nil),
+			200,
+			ResponseBooks{
+				Count: 2,
+				Data: []models.Book{
+					{
+						ID:    1,
+						Title: "Harry Potter and the Philosopher's Stone",
+					},
+					{
+						ID:    4,
+						Title: "Tintin in Tibet",
+					},
+				},
+			})
+	})
+
+	t.Run("Insert without author", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		ctx.Api.HttpPut("/books", controllers.CreateBookInput{
+			Title: "Book of Nobody",
+		}, 422, models.Problem{
+			Type:   "about:blank",
+			Title:  "Unprocessable Entity",
+			Status: 422,
+			Detail: "INVALID INPUT author: REQUIRED",
+			Errors: []models.FieldError{
+				{Path: "author", Field: "author", Message: "REQUIRED"},
+			},
+		})
+	})
+
+	t.Run("Delete Tintin in Tibet without content", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		ctx.Api.Header = http.Header{"Prefer": []string{"return=minimal"}}
+		defer func() { ctx.Api.Header = nil }()
+
+		ctx.Api.HttpDelete("/books/4", 204, nil)
+	})
+
+	t.Run("Merge patch Harry Potter author", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		ctx.Api.Header = http.Header{"Content-Type": []string{models.MergePatchContentType}}
+		defer func() { ctx.Api.Header = nil }()
+
+		ctx.Api.HttpPatch("/books/1", map[string]any{
+			"author":  "J. K. Rowling",
+			"summary": nil,
+		}, 200, ResponseBook{
+			Data: models.Book{
+				ID:     1,
+				Title:  "Harry Potter and the Philosopher's Stone",
+				Author: "J. K. Rowling",
+			},
+		})
+	})
+
+	t.Run("JSON patch with failed test", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		ctx.Api.Header = http.Header{"Content-Type": []string{models.JSONPatchContentType}}
+		defer func() { ctx.Api.Header = nil }()
+
+		ctx.Api.HttpPatch("/books/1", []models.PatchOperation{
+			{Op: "test", Path: "/author", Value: json.RawMessage(`"J. K. Rawling"`)},
+			{Op: "add", Path: "/summary", Value: json.RawMessage(`"The boy who lived"`)},
+		}, 409, models.Problem{
+			Type:   "about:blank",
+			Title:  "Conflict",
+			Status: 409,
+			Detail: "Patch test failed at /author",
+		})
+	})
+
+	t.Run("JSON patch Harry Potter summary", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		ctx.Api.Header = http.Header{"Content-Type": []string{models.JSONPatchContentType}}
+		defer func() { ctx.Api.Header = nil }()
+
+		ctx.Api.HttpPatch("/books/1", []models.PatchOperation{
+			{Op: "test", Path: "/author", Value: json.RawMessage(`"J. K. Rowling"`)},
+			{Op: "add", Path: "/summary", Value: json.RawMessage(`"The boy who lived"`)},
+		}, 200, ResponseBook{
+			Data: models.Book{
+				ID:      1,
+				Title:   "Harry Potter and the Philosopher's Stone",
+				Author:  "J. K. Rowling",
+				Summary: "The boy who lived",
+			},
+		})
+	})
+
+	var etag string
+	t.Run("Get Harry Potter with ETag", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		ctx.Api.HttpGet("/books/1", 200, ResponseBook{
+			Data: models.Book{
+				ID:      1,
+				Title:   "Harry Potter and the Philosopher's Stone",
+				Author:  "J. K. Rowling",
+				Summary: "The boy who lived",
+			},
+		})
+		etag = ctx.Api.Response.Header.Get("ETag")
+		assert.NotEmpty(t, etag)
+	})
+
+	t.Run("Get unmodified Harry Potter", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		ctx.Api.Header = http.Header{"If-None-Match": []string{etag}}
+		defer func() { ctx.Api.Header = nil }()
+
+		ctx.Api.HttpGet("/books/1", 304, nil)
+	})
+
+	t.Run("Update Harry Potter with stale ETag", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		ctx.Api.Header = http.Header{"If-Match": []string{`"stale"`}}
+		defer func() { ctx.Api.Header = nil }()
+
+		ctx.Api.HttpPatch("/books/1", controllers.UpdateBookInput{
+			Summary: "The boy who lived!",
+		}, 412, models.Problem{
+			Type:   "about:blank",
+			Title:  "Precondition Failed",
+			Status: 412,
+			Detail: "ETag mismatch",
+		})
+	})
+
+	var next, prev string
+	t.Run("Finds first page with cursor", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		var page models.Page[models.Book]
+		ctx.Api.HttpGetInto("/books?cursor=&limit=2&count=none", 200, &page)
+		assert.Nil(t, page.Count)
+		assert.Equal(t, []uint{1, 2}, bookIDs(page.Data))
+		assert.NotEmpty(t, page.Next)
+		assert.Empty(t, page.Prev)
+		next = page.Next
+	})
+
+	t.Run("Finds next page with cursor", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		var page models.Page[models.Book]
+		ctx.Api.HttpGetInto("/books?limit=2&cursor="+url.QueryEscape(next), 200, &page)
+		assert.Equal(t, int64(3), *page.Count)
+		assert.Equal(t, []uint{5}, bookIDs(page.Data))
+		assert.Empty(t, page.Next)
+		assert.NotEmpty(t, page.Prev)
+		prev = page.Prev
+	})
+
+	t.Run("Finds prev page with cursor", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		var page models.Page[models.Book]
+		ctx.Api.HttpGetInto("/books?limit=2&count=none&cursor="+url.QueryEscape(prev), 200, &page)
+		assert.Equal(t, []uint{1, 2}, bookIDs(page.Data))
+		assert.Equal(t, next, page.Next)
+		assert.Empty(t, page.Prev)
+	})
+
+	t.Run("Finds with tampered cursor", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		ctx.Api.HttpGet("/books?cursor="+url.QueryEscape(next+"x"), 400, models.Problem{
+			Type:   "about:blank",
+			Title:  "Bad Request",
+			Status: 400,
+			Detail: "Invalid cursor",
+		})
+	})
+
+	t.Run("Finds books by author then title", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		ctx.Api.HttpPost("/books",
+			models.NewQuery(models.Fields("id", "title", "author"), nil, nil).SortBy(models.Asc("author").NullsLast(), models.Desc("title")),
+			200,
+			ResponseBooks{
+				Count: 3,
+				Data: []models.Book{
+					{ID: 5, Title: "Tintin in America", Author: "Herge"},
+					{ID: 2, Title: "Harry Potter and the Chamber of Secrets", Author: "J. K. Rawling"},
+					{ID: 1, Title: "Harry Potter and the Philosopher's Stone", Author: "J. K. Rowling"},
+				},
+			})
+	})
+
+	t.Run("Finds with filter", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		filter := `title ~ "Harry" and not author = "J. K. Rowling"`
+		ctx.Api.HttpGet("/books?filter="+url.QueryEscape(filter), 200, ResponseBooks{
+			Count: 1,
+			Data: []models.Book{
+				{ID: 2, Title: "Harry Potter and the Chamber of Secrets", Author: "J. K. Rawling"},
+			},
+		})
+	})
+
+	t.Run("Finds with invalid filter", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		filter := `title ~ "Harry" and author`
+		ctx.Api.HttpGet("/books?filter="+url.QueryEscape(filter), 400, models.Problem{
+			Type:   "about:blank",
+			Title:  "Bad Request",
+			Status: 400,
+			Detail: "INVALID FILTER AT 26: expected operator, found end of filter",
+		})
+	})
+
+	t.Run("Finds with OData options", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		params := url.Values{}
+		params.Set("$filter", "startswith(title,'Harry')")
+		params.Set("$orderby", "title desc")
+		params.Set("$select", "id,title")
+		params.Set("$top", "1")
+		ctx.Api.HttpGet("/books?"+params.Encode(), 200, ResponseBooks{
+			Count: 2,
+			Data: []models.Book{
+				{ID: 1, Title: "Harry Potter and the Philosopher's Stone"},
+			},
+		})
+	})
+
+	t.Run("Finds with RSQL filter", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		params := url.Values{}
+		params.Set("syntax", "rsql")
+		params.Set("filter", `author=="J. K. Rawling"`)
+		ctx.Api.HttpGet("/books?"+params.Encode(), 200, ResponseBooks{
+			Count: 1,
+			Data: []models.Book{
+				{ID: 2, Title: "Harry Potter and the Chamber of Secrets", Author: "J. K. Rawling"},
+			},
+		})
+	})
+
+	t.Run("Finds with unknown syntax", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		ctx.Api.HttpGet("/books?syntax=sql&filter=1", 400, models.Problem{
+			Type:   "about:blank",
+			Title:  "Bad Request",
+			Status: 400,
+			Detail: "Unknown syntax sql",
+		})
+	})
+
+	t.Run("Aggregate books per author", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		query := models.NewQuery(nil, models.NewCondition().Like("title", "Harry Potter"), nil).
+			Aggregate(models.Fields("author"), models.Count("books"), models.Max("id", "last")).
+			SortBy(models.Asc("author"))
+		query.Having = models.NewCondition().GreaterEqual("books", 1)
+		ctx.Api.HttpPost("/books/aggregate", query, 200, map[string]any{
+			"data": []map[string]any{
+				{"author": "J. K. Rawling", "books": 1, "last": 2},
+				{"author": "J. K. Rowling", "books": 1, "last": 1},
+			},
+		})
+	})
+
+	t.Run("Aggregate with unknown alias", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		ctx.Api.HttpPost("/books/aggregate",
+			models.NewQuery(nil, nil, nil).Aggregate(models.Fields("author"), models.Count("")).SortBy(models.Desc("books")),
+			422,
+			models.Problem{
+				Type:   "about:blank",
+				Title:  "Unprocessable Entity",
+				Status: 422,
+				Detail: "INVALID QUERY orderBy[0].f: UNKNOWN FIELD books",
+				Errors: []models.FieldError{
+					{Path: "orderBy[0].f", Field: "books", Message: "UNKNOWN FIELD books"},
+				},
+			})
+	})
+
+	t.Run("Search Harry Potter", func(t *testing.T) {
+		if models.DB.Search == nil {
+			t.Skip("no full text search")
+		}
+		defer ctx.startMock(t.Name())()
+
+		var page models.Page[models.SearchHit[models.Book]]
+		ctx.Api.HttpGetInto("/books/search?q="+url.QueryEscape("harry -chamber"), 200, &page)
+		if assert.Equal(t, 1, len(page.Data)) {
+			assert.Equal(t, models.Book{
+				ID:      1,
+				Title:   "Harry Potter and the Philosopher's Stone",
+				Author:  "J. K. Rowling",
+				Summary: "The boy who lived",
+			}, page.Data[0].Data)
+			assert.Equal(t, map[string]string{"title": "<mark>Harry</mark> Potter and the Philosopher's Stone"}, page.Data[0].Highlights)
+		}
+	})
+
+	t.Run("Search without text", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		ctx.Api.HttpGet("/books/search?q=", 400, models.Problem{
+			Type:   "about:blank",
+			Title:  "Bad Request",
+			Status: 400,
+			Detail: "Missing search text",
+		})
+	})
+
+	t.Run("Bulk in best-effort mode", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		repo := models.NewRepository[models.Book](nil)
+		tibet := models.Book{ID: 6, Title: "Tintin in Tibet", Author: "Herge"}
+		america := models.Book{ID: 5, Title: "Tintin in America", Author: "Herge", Summary: "Tintin visits Chicago"}
+		chamber := models.Book{ID: 2, Title: "Harry Potter and the Chamber of Secrets", Author: "J. K. Rowling"}
+		ctx.Api.HttpPost("/books/_bulk", models.BulkRequest{
+			Mode: models.BulkBestEffort,
+			Operations: []models.BulkItem{
+				{Op: models.BulkCreate, Data: json.RawMessage(`{"title": "Tintin in Tibet", "author": "Herge"}`)},
+				{Op: models.BulkUpsert, Data: json.RawMessage(`{"title": "Tintin in America", "author": "Herge", "summary": "Tintin visits Chicago"}`)},
+				{Op: models.BulkUpdate, ID: 2, Data: json.RawMessage(`{"author": "J. K. Rowling"}`)},
+				{Op: models.BulkDelete, ID: 9999},
+				{Op: models.BulkCreate, Data: json.RawMessage(`{"title": "Tintin in Congo"}`)},
+			},
+		}, 207, map[string]any{
+			"data": []models.BulkResult[models.Book]{
+				{Status: 201, ID: 6, ETag: repo.ETag(tibet), Data: &tibet},
+				{Status: 200, ID: 5, ETag: repo.ETag(america), Data: &america},
+				{Status: 200, ID: 2, ETag: repo.ETag(chamber), Data: &chamber},
+				{Status: 404, ID: 9999, Error: models.NewProblem(404, "record not found")},
+				{Status: 422, Error: &models.Problem{
+					Type:   "about:blank",
+					Title:  "Unprocessable Entity",
+					Status: 422,
+					Detail: "INVALID INPUT author: REQUIRED",
+					Errors: []models.FieldError{{Path: "author", Field: "author", Message: "REQUIRED"}},
+				}},
+			},
+		})
+	})
+
+	t.Run("Bulk in atomic mode", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		rolledBack := models.NewProblem(424, "Rolled back, operations[1] failed")
+		ctx.Api.HttpPost("/books/_bulk", models.BulkRequest{
+			Operations: []models.BulkItem{
+				{Op: models.BulkCreate, Data: json.RawMessage(`{"title": "Tintin in Congo", "author": "Herge"}`)},
+				{Op: models.BulkCreate, Data: json.RawMessage(`{"title": "Tintin in Tibet", "author": "Herge"}`)},
+			},
+		}, 207, map[string]any{
+			"data": []models.BulkResult[models.Book]{
+				{Status: 424, Error: rolledBack},
+				{Status: 409, Error: &models.Problem{
+					Type:   "about:blank",
+					Title:  "Conflict",
+					Status: 409,
+					Detail: "Duplicate value books.title",
+					Code:   "unique_violation",
+					Errors: []models.FieldError{{Path: "title", Field: "title", Message: "unique_violation"}},
+				}},
+			},
+		})
+		ctx.Api.HttpGet("/books?query="+models.NewQuery(models.Fields("id", "title"), models.NewCondition().Like("title", "Tintin"), nil).String(), 200, ResponseBooks{
+			Count: 2,
+			Data: []models.Book{
+				{ID: 5, Title: "Tintin in America"},
+				{ID: 6, Title: "Tintin in Tibet"},
+			},
+		})
+	})
+
+	t.Run("Export Tintin books as CSV", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		query := models.NewQuery(nil, models.NewCondition().Like("title", "Tintin"), nil).SortBy(models.Asc("id"))
+		body := ctx.Api.HttpGetText("/books/export?query="+query.String(), "text/csv", 200, "text/csv; charset=utf-8")
+		assert.Equal(t, "id,title,author,summary\n5,Tintin in America,Herge,Tintin visits Chicago\n6,Tintin in Tibet,Herge,\n", body)
+	})
+
+	t.Run("Export books as NDJSON", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		query := models.NewQuery(models.Fields("id", "title"), nil, nil).SortBy(models.Desc("id"))
+		body := ctx.Api.HttpGetText("/books/export?query="+query.String(), "application/x-ndjson", 200, "application/x-ndjson")
+		assert.Equal(t, `{"id":6,"title":"Tintin in Tibet","deletedAt":null}
+{"id":5,"title":"Tintin in America","deletedAt":null}
+{"id":2,"title":"Harry Potter and the Chamber of Secrets","deletedAt":null}
+{"id":1,"title":"Harry Potter and the Philosopher's Stone","deletedAt":null}
+`, body)
+	})
+
+	t.Run("Export Herge books as JSON", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		body := ctx.Api.HttpGetText("/books/export?filter="+url.QueryEscape(`author = "Herge" and summary = ""`), "*/*", 200, "application/json; charset=utf-8")
+		assert.Equal(t, `[{"id":6,"title":"Tintin in Tibet","author":"Herge","deletedAt":null}]`, body)
+	})
+
+	t.Run("Export as XML", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		body := ctx.Api.HttpGetText("/books/export", "application/xml", 406, "application/problem+json")
+		assert.Equal(t, `{"type":"about:blank","title":"Not Acceptable","status":406,"detail":"Export is available as application/json, application/x-ndjson or text/csv"}`, body)
+	})
+
+	t.Run("Import books dry run", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		csv := "Book Title,Writer,summary\n" +
+			"Tintin in Congo,Herge,\n" +
+			"Tintin in Tibet,Herge,\n" +
+			"The Blue Lotus,,\n" +
+			"Tintin in Congo,Herge,again\n"
+		duplicate := &models.Problem{
+			Type:   "about:blank",
+			Title:  "Conflict",
+			Status: 409,
+			Detail: "Duplicate value books.title",
+			Code:   "unique_violation",
+			Errors: []models.FieldError{{Path: "title", Field: "title", Message: "unique_violation"}},
+		}
+		ctx.Api.HttpPostBody("/books/import?dry_run=true&map="+url.QueryEscape("Book Title=title")+"&map=Writer=author", "text/csv", csv, 200, map[string]any{
+			"data": models.ImportReport{
+				DryRun:   true,
+				Rows:     4,
+				Imported: 1,
+				Errors: []models.ImportError{
+					{Row: 3, Error: duplicate},
+					{Row: 4, Error: &models.Problem{
+						Type:   "about:blank",
+						Title:  "Unprocessable Entity",
+						Status: 422,
+						Detail: "INVALID INPUT author: REQUIRED",
+						Errors: []models.FieldError{{Path: "author", Field: "author", Message: "REQUIRED"}},
+					}},
+					{Row: 5, DuplicateOf: 2, Error: duplicate},
+				},
+			},
+		})
+	})
+
+	t.Run("Import books from NDJSON", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		ndjson := `{"title": "Tintin in Congo", "author": "Herge"}` + "\n" + `{"title": "The Blue Lotus", "author": "Herge"}` + "\n"
+		ctx.Api.HttpPostBody("/books/import", "application/x-ndjson", ndjson, 200, map[string]any{
+			"data": models.ImportReport{Rows: 2, Imported: 2, Errors: []models.ImportError{}},
+		})
+		ctx.Api.HttpGet("/books/7", 200, ResponseBook{
+			Data: models.Book{ID: 7, Title: "Tintin in Congo", Author: "Herge"},
+		})
+	})
+
+	t.Run("Delete Tintin in Congo", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		ctx.Api.HttpDelete("/books/7", 200, Response{Data: true})
+	})
+
+	t.Run("Get deleted Tintin in Congo", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		ctx.Api.HttpGet("/books/7", 404, models.Problem{
+			Type:   "about:blank",
+			Title:  "Not Found",
+			Status: 404,
+			Detail: "record not found",
+		})
+		var book struct{ Data models.Book }
+		ctx.Api.HttpGetInto("/books/7?includeDeleted=true", 200, &book)
+		assert.Equal(t, "Tintin in Congo", book.Data.Title)
+		assert.True(t, book.Data.DeletedAt.Valid)
+	})
+
+	t.Run("Finds only deleted books", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		var page models.Page[models.Book]
+		ctx.Api.HttpGetInto("/books?onlyDeleted=true", 200, &page)
+		assert.Equal(t, int64(3), *page.Count)
+		assert.Equal(t, []uint{3, 4, 7}, bookIDs(page.Data))
+	})
+
+	t.Run("Insert deleted Tintin in Congo again", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		ctx.Api.HttpPut("/books", controllers.CreateBookInput{
+			Title:  "Tintin in Congo",
+			Author: "Herge",
+		}, 201, ResponseBook{
+			Data: models.Book{ID: 9, Title: "Tintin in Congo", Author: "Herge"},
+		})
+	})
+
+	t.Run("Restore Tintin in Congo taken again", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		ctx.Api.HttpPost("/books/7/restore", nil, 409, models.Problem{
+			Type:   "about:blank",
+			Title:  "Conflict",
+			Status: 409,
+			Detail: "Duplicate value books.title",
+			Code:   "unique_violation",
+			Errors: []models.FieldError{
+				{Path: "title", Field: "title", Message: "unique_violation"},
+			},
+		})
+	})
+
+	t.Run("Restore Evil book", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		ctx.Api.HttpPost("/books/3/restore", nil, 200, ResponseBook{
+			Data: models.Book{ID: 3, Title: "Harry Potter and Book of Dark Magic", Author: "Lord Voldermort"},
+		})
+	})
+
+	t.Run("Restore book that is not deleted", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		ctx.Api.HttpPost("/books/1/restore", nil, 409, models.Problem{
+			Type:   "about:blank",
+			Title:  "Conflict",
+			Status: 409,
+			Detail: "Record 1 is not deleted",
+		})
+	})
+
+	t.Run("Purge deleted books", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		ctx.Api.HttpPost("/books/purge", nil, 403, models.Problem{
+			Type:   "about:blank",
+			Title:  "Forbidden",
+			Status: 403,
+			Detail: "Admin only",
+		})
+		ctx.Api.Header = http.Header{models.ActorHeader: []string{"admin"}}
+		defer func() { ctx.Api.Header = nil }()
+		ctx.Api.HttpPost("/books/purge?before=yesterday", nil, 400, models.Problem{
+			Type:   "about:blank",
+			Title:  "Bad Request",
+			Status: 400,
+			Detail: `Before error: parsing time "yesterday" as "2006-01-02T15:04:05Z07:00": cannot parse "yesterday" as "2006"`,
+		})
+		ctx.Api.HttpPost("/books/purge", nil, 200, map[string]any{
+			"data": map[string]any{"purged": 2},
+		})
+	})
+
+	t.Run("History of Harry Potter", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		var res ResponseRevisions
+		ctx.Api.HttpGetInto("/books/1/history", 200, &res)
+		assert.Equal(t, int64(3), res.Count)
+		if assert.Len(t, res.Data, 3) {
+			assert.Equal(t, []int{3, 2, 1}, []int{res.Data[0].Rev, res.Data[1].Rev, res.Data[2].Rev})
+			assert.Equal(t, []string{models.AuditUpdate, models.AuditUpdate, models.AuditCreate},
+				[]string{res.Data[0].Action, res.Data[1].Action, res.Data[2].Action})
+			assert.JSONEq(t, `[{"field":"author","before":"J. K. Rawling","after":"J. K. Rowling"},{"field":"summary","before":"The boy who lived","after":null}]`,
+				string(res.Data[1].Diff))
+			assert.Nil(t, res.Data[2].Before)
+		}
+	})
+
+	t.Run("Get revision of Harry Potter", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		var res struct {
+			Data models.Revision `json:"data"`
+		}
+		ctx.Api.HttpGetInto("/books/1/history/1", 200, &res)
+		assert.Equal(t, models.AuditCreate, res.Data.Action)
+		assert.JSONEq(t, `{"id":1,"title":"Harry Potter and the Philosopher's Stone","author":"J. K. Rawling","summary":"The boy who lived","deletedAt":null}`,
+			string(res.Data.After))
+
+		ctx.Api.HttpGet("/books/1/history/99", 404, models.Problem{
+			Type:   "about:blank",
+			Title:  "Not Found",
+			Status: 404,
+			Detail: "record not found",
+		})
+	})
+
+	t.Run("Revert Harry Potter author", func(t *testing.T) {
+		defer ctx.startMock(t.Name())()
+
+		ctx.Api.HttpPost("/books/1/revert/1", nil, 200, ResponseBook{
+			Data: models.Book{ID: 1, Title: "Harry Potter and the Philosopher's Stone", Author: "J. K. Rawling", Summary: "The boy who lived"},
+		})
+	})
+}
+
+func bookIDs(books []models.Book) []uint {
+	ids := []uint{}
+	for _, b := range books {
+		ids = append(ids, b.ID)
+	}
+	return ids
 }
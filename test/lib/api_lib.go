@@ -13,8 +13,10 @@ import (
 )
 
 type Api struct {
-	Server *httptest.Server
-	T      *testing.T
+	Server   *httptest.Server
+	T        *testing.T
+	Header   http.Header
+	Response *http.Response
 }
 
 func Marshal(t *testing.T, v any) string {
@@ -37,24 +39,59 @@ func (api *Api) Marshal(v any) string {
 	return str
 }
 
+// normalize decodes v the same way response bodies are decoded, so that
+// top level keys compare in the same order.
+func (api *Api) normalize(v any) any {
+	var res map[string]json.RawMessage
+	if bb, err := json.Marshal(v); err != nil {
+		api.T.Fatal("marshal", err, v)
+	} else if err := json.Unmarshal(bb, &res); err != nil {
+		return v
+	}
+	return res
+}
+
 func QuoteMeta(r string) string {
 	return "^" + regexp.QuoteMeta(r) + "$"
 }
 
+// ContentType is the response content type expected for statusCode, errors
+// are rendered as RFC 7807 problem details.
+func ContentType(statusCode int) string {
+	if statusCode >= 400 {
+		return "application/problem+json"
+	}
+	return "application/json; charset=utf-8"
+}
+
+func (api *Api) do(req *http.Request) (*http.Response, error) {
+	for k, v := range api.Header {
+		req.Header[k] = v
+	}
+	client := &http.Client{}
+	return client.Do(req)
+}
+
 func (api *Api) HttpGet(path string, statusCode int, responseData any) (string, any) {
 	var resp *http.Response
-	if r, err := http.Get(api.Server.URL + path); err != nil {
+	if req, err := http.NewRequest(http.MethodGet, api.Server.URL+path, nil); err != nil {
+		api.T.Fatal("Http Error", err)
+	} else if r, err := api.do(req); err != nil {
 		api.T.Fatal("Http Error", err)
 	} else {
 		resp = r
 	}
+	api.Response = resp
 	assert.Equal(api.T, statusCode, resp.StatusCode)
+	if statusCode == http.StatusNoContent || statusCode == http.StatusNotModified {
+		return "", nil
+	}
 	val, ok := resp.Header["Content-Type"]
 
 	if !ok {
 		api.T.Fatal("Expected Content-Type header to be set")
 	}
-	assert.Equal(api.T, "application/json; charset=utf-8", val[0])
+	assert.Equal(api.T, ContentType(statusCode), val[0])
 	var res map[string]json.RawMessage
 	var body string
 
@@ -66,26 +103,85 @@ func (api *Api) HttpGet(path string, statusCode int, responseData any) (string,
 			assert.Fail(api.T, "Unmarshal body", err)
 		}
 	}
-	assert.Equal(api.T, api.Marshal(responseData), api.Marshal(res), body)
+	assert.Equal(api.T, api.Marshal(api.normalize(responseData)), api.Marshal(res), body)
 	return body, res
 }
 
+// HttpGetInto decodes the response body into out instead of comparing it,
+// for responses carrying generated values such as cursors.
+func (api *Api) HttpGetInto(path string, statusCode int, out any) string {
+	var resp *http.Response
+	if req, err := http.NewRequest(http.MethodGet, api.Server.URL+path, nil); err != nil {
+		api.T.Fatal("Http Error", err)
+	} else if r, err := api.do(req); err != nil {
+		api.T.Fatal("Http Error", err)
+	} else {
+		resp = r
+	}
+	api.Response = resp
+	assert.Equal(api.T, statusCode, resp.StatusCode)
+	assert.Equal(api.T, ContentType(statusCode), resp.Header.Get("Content-Type"))
+
+	rb, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		api.T.Fatal("Read body", err)
+	}
+	if err := json.Unmarshal(rb, out); err != nil {
+		assert.Fail(api.T, "Unmarshal body", err)
+	}
+	return string(rb)
+}
+
+// HttpGetText requests path accepting the accept media type and returns the
+// raw body, for responses that are not a json object such as exports.
+func (api *Api) HttpGetText(path string, accept string, statusCode int, contentType string) string {
+	var resp *http.Response
+	if req, err := http.NewRequest(http.MethodGet, api.Server.URL+path, nil); err != nil {
+		api.T.Fatal("Http Error", err)
+	} else {
+		req.Header.Set("Accept", accept)
+		if r, err := api.do(req); err != nil {
+			api.T.Fatal("Http Error", err)
+		} else {
+			resp = r
+		}
+	}
+	api.Response = resp
+	assert.Equal(api.T, statusCode, resp.StatusCode)
+	assert.Equal(api.T, contentType, resp.Header.Get("Content-Type"))
+
+	rb, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		api.T.Fatal("Read body", err)
+	}
+	return string(rb)
+}
+
 func (api *Api) HttpPost(path string, requestData any, statusCode int, responseData any) (string, any) {
 	requestDataBytes, _ := json.Marshal(requestData)
 
 	var resp *http.Response
-	if r, err := http.Post(api.Server.URL+path, "application/json; charset=utf-8", bytes.NewBuffer(requestDataBytes)); err != nil {
+	if req, err := http.NewRequest(http.MethodPost, api.Server.URL+path, bytes.NewBuffer(requestDataBytes)); err != nil {
 		api.T.Fatal("Http Error", err)
 	} else {
-		resp = r
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		if r, err := api.do(req); err != nil {
+			api.T.Fatal("Http Error", err)
+		} else {
+			resp = r
+		}
 	}
+	api.Response = resp
 	assert.Equal(api.T, statusCode, resp.StatusCode)
+	if statusCode == http.StatusNoContent {
+		return "", nil
+	}
 	val, ok := resp.Header["Content-Type"]
 
 	if !ok {
 		api.T.Fatal("Expected Content-Type header to be set")
 	}
-	assert.Equal(api.T, "application/json; charset=utf-8", val[0])
+	assert.Equal(api.T, ContentType(statusCode), val[0])
 	var res map[string]json.RawMessage
 	var body string
 
@@ -97,10 +193,38 @@ func (api *Api) HttpPost(path string, requestData any, statusCode int, responseD
 			assert.Fail(api.T, "Unmarshal body", err)
 		}
 	}
-	assert.Equal(api.T, api.Marshal(responseData), api.Marshal(res), body)
+	assert.Equal(api.T, api.Marshal(api.normalize(responseData)), api.Marshal(res), body)
 	return body, res
 }
 
+// HttpPostBody posts body as is with the given content type, for uploads
+// that are not json.
+func (api *Api) HttpPostBody(path string, contentType string, body string, statusCode int, responseData any) (string, any) {
+	var resp *http.Response
+	if req, err := http.NewRequest(http.MethodPost, api.Server.URL+path, bytes.NewBufferString(body)); err != nil {
+		api.T.Fatal("Http Error", err)
+	} else {
+		req.Header.Set("Content-Type", contentType)
+		if r, err := api.do(req); err != nil {
+			api.T.Fatal("Http Error", err)
+		} else {
+			resp = r
+		}
+	}
+	api.Response = resp
+	assert.Equal(api.T, statusCode, resp.StatusCode)
+	assert.Equal(api.T, ContentType(statusCode), resp.Header.Get("Content-Type"))
+	var res map[string]json.RawMessage
+	rb, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		api.T.Fatal("Read body", err)
+	} else if err := json.Unmarshal(rb, &res); err != nil {
+		assert.Fail(api.T, "Unmarshal body", err)
+	}
+	assert.Equal(api.T, api.Marshal(api.normalize(responseData)), api.Marshal(res), string(rb))
+	return string(rb), res
+}
+
 func (api *Api) HttpPut(path string, requestData any, statusCode int, responseData any) (string, any) {
 	requestDataBytes, _ := json.Marshal(requestData)
 
@@ -108,20 +232,23 @@ func (api *Api) HttpPut(path string, requestData any, statusCode int, responseDa
 	if req, err := http.NewRequest(http.MethodPut, api.Server.URL+path, bytes.NewBuffer(requestDataBytes)); err != nil {
 		api.T.Fatal("Http Error", err)
 	} else {
-		client := &http.Client{}
-		if r, err := client.Do(req); err != nil {
+		if r, err := api.do(req); err != nil {
 			api.T.Fatal("Http Error", err)
 		} else {
 			resp = r
 		}
 	}
+	api.Response = resp
 	assert.Equal(api.T, statusCode, resp.StatusCode)
+	if statusCode == http.StatusNoContent {
+		return "", nil
+	}
 	val, ok := resp.Header["Content-Type"]
 
 	if !ok {
 		api.T.Fatal("Expected Content-Type header to be set")
 	}
-	assert.Equal(api.T, "application/json; charset=utf-8", val[0])
+	assert.Equal(api.T, ContentType(statusCode), val[0])
 	var res map[string]json.RawMessage
 	var body string
 
@@ -133,7 +260,7 @@ func (api *Api) HttpPut(path string, requestData any, statusCode int, responseDa
 			assert.Fail(api.T, "Unmarshal body", err)
 		}
 	}
-	assert.Equal(api.T, api.Marshal(responseData), api.Marshal(res), body)
+	assert.Equal(api.T, api.Marshal(api.normalize(responseData)), api.Marshal(res), body)
 	return body, res
 }
 
@@ -144,20 +271,23 @@ func (api *Api) HttpPatch(path string, requestData any, statusCode int, response
 	if req, err := http.NewRequest(http.MethodPatch, api.Server.URL+path, bytes.NewBuffer(requestDataBytes)); err != nil {
 		api.T.Fatal("Http Error", err)
 	} else {
-		client := &http.Client{}
-		if r, err := client.Do(req); err != nil {
+		if r, err := api.do(req); err != nil {
 			api.T.Fatal("Http Error", err)
 		} else {
 			resp = r
 		}
 	}
+	api.Response = resp
 	assert.Equal(api.T, statusCode, resp.StatusCode)
+	if statusCode == http.StatusNoContent {
+		return "", nil
+	}
 	val, ok := resp.Header["Content-Type"]
 
 	if !ok {
 		api.T.Fatal("Expected Content-Type header to be set")
 	}
-	assert.Equal(api.T, "application/json; charset=utf-8", val[0])
+	assert.Equal(api.T, ContentType(statusCode), val[0])
 	var res map[string]json.RawMessage
 	var body string
 
@@ -169,7 +299,7 @@ func (api *Api) HttpPatch(path string, requestData any, statusCode int, response
 			assert.Fail(api.T, "Unmarshal body", err)
 		}
 	}
-	assert.Equal(api.T, api.Marshal(responseData), api.Marshal(res), body)
+	assert.Equal(api.T, api.Marshal(api.normalize(responseData)), api.Marshal(res), body)
 	return body, res
 }
 
@@ -178,20 +308,23 @@ func (api *Api) HttpDelete(path string, statusCode int, responseData any) (strin
 	if req, err := http.NewRequest(http.MethodDelete, api.Server.URL+path, nil); err != nil {
 		api.T.Fatal(api.T, "Http Error", err)
 	} else {
-		client := &http.Client{}
-		if r, err := client.Do(req); err != nil {
+		if r, err := api.do(req); err != nil {
 			api.T.Fatal(api.T, "Http Error", err)
 		} else {
 			resp = r
 		}
 	}
+	api.Response = resp
 	assert.Equal(api.T, statusCode, resp.StatusCode)
+	if statusCode == http.StatusNoContent {
+		return "", nil
+	}
 	val, ok := resp.Header["Content-Type"]
 
 	if !ok {
 		api.T.Fatal("Expected Content-Type header to be set")
 	}
-	assert.Equal(api.T, "application/json; charset=utf-8", val[0])
+	assert.Equal(api.T, ContentType(statusCode), val[0])
 	var res map[string]json.RawMessage
 	var body string
 
@@ -203,6 +336,6 @@ func (api *Api) HttpDelete(path string, statusCode int, responseData any) (strin
 			assert.Fail(api.T, "Unmarshal body", err)
 		}
 	}
-	assert.Equal(api.T, api.Marshal(responseData), api.Marshal(res), body)
+	assert.Equal(api.T, api.Marshal(api.normalize(responseData)), api.Marshal(res), body)
 	return body, res
 }
@@ -2,17 +2,36 @@ package test
 
 import (
 	"database/sql/driver"
-	"errors"
 	"log"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgconn"
 	"github.com/senomas/go-api/models"
 	test_base "github.com/senomas/go-api/test/base"
 	test_lib "github.com/senomas/go-api/test/lib"
 	"gorm.io/driver/postgres"
 )
 
+var deletedAt = time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC)
+
+var revisionColumns = []string{"id", "record_type", "record_id", "rev", "action", "actor", "request_id", "created_at", "before", "after", "diff"}
+
+var (
+	harryPotter        = `{"id":1,"title":"Harry Potter and the Philosopher's Stone","author":"J. K. Rawling","summary":"The boy who lived","deletedAt":null}`
+	harryPotterRowling = `{"id":1,"title":"Harry Potter and the Philosopher's Stone","author":"J. K. Rowling","deletedAt":null}`
+)
+
+var duplicateTitle = &pgconn.PgError{
+	Severity:       "ERROR",
+	Code:           "23505",
+	Message:        `duplicate key value violates unique constraint "idx_books_title_active"`,
+	TableName:      "books",
+	ConstraintName: "idx_books_title_active",
+}
+
 func TestBook(t *testing.T) {
 	if sqlDB, mock, err := sqlmock.New(); err != nil {
 		t.Fatal("init SQLMock Error", err)
@@ -27,176 +46,534 @@ func TestBook(t *testing.T) {
 				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA() AND table_name = $1 AND table_type = $2`)).WithArgs("books", "BASE TABLE").WillReturnRows(sqlmock.NewRows(
 					[]string{"TABLES"}))
 
-				mock.ExpectExec(test_lib.QuoteMeta(`CREATE TABLE "books" ("id" bigserial,"title" text,"author" text,"summary" text,PRIMARY KEY ("id"))`)).WithArgs([]driver.Value{}...).WillReturnResult(driver.RowsAffected(1))
+				mock.ExpectExec(test_lib.QuoteMeta(`CREATE TABLE "books" ("id" bigserial,"title" text,"author" text,"summary" text,"deleted_at" timestamptz,PRIMARY KEY ("id"))`)).WithArgs([]driver.Value{}...).WillReturnResult(driver.RowsAffected(1))
+
+				mock.ExpectExec(test_lib.QuoteMeta(`CREATE UNIQUE INDEX IF NOT EXISTS "idx_books_title_active" ON "books" ("title") WHERE deleted_at IS NULL`)).WithArgs([]driver.Value{}...).WillReturnResult(driver.RowsAffected(1))
+
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM pg_indexes WHERE tablename = $1 AND indexname = $2 AND schemaname = CURRENT_SCHEMA()`)).WithArgs("books", "idx_books_title").WillReturnRows(sqlmock.NewRows(
+					[]string{"count"}).AddRow(0))
+
+				mock.ExpectExec(test_lib.QuoteMeta(`CREATE TABLE IF NOT EXISTS "books_search" ("id" bigint PRIMARY KEY, "document" tsvector NOT NULL)`)).WithArgs([]driver.Value{}...).WillReturnResult(driver.RowsAffected(1))
+
+				mock.ExpectExec(test_lib.QuoteMeta(`CREATE INDEX IF NOT EXISTS "idx_books_search_document" ON "books_search" USING GIN ("document")`)).WithArgs([]driver.Value{}...).WillReturnResult(driver.RowsAffected(1))
+
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA() AND table_name = $1 AND table_type = $2`)).WithArgs("revisions", "BASE TABLE").WillReturnRows(sqlmock.NewRows(
+					[]string{"TABLES"}))
+
+				mock.ExpectExec(test_lib.QuoteMeta(`CREATE TABLE "revisions" ("id" bigserial,"record_type" text,"record_id" text,"rev" bigint,"action" text,"actor" text,"request_id" text,"created_at" timestamptz,"before" text,"after" text,"diff" text,PRIMARY KEY ("id"))`)).WithArgs([]driver.Value{}...).WillReturnResult(driver.RowsAffected(1))
+
+				mock.ExpectExec(test_lib.QuoteMeta(`CREATE UNIQUE INDEX IF NOT EXISTS "idx_revisions_record" ON "revisions" ("record_type","record_id","rev")`)).WithArgs([]driver.Value{}...).WillReturnResult(driver.RowsAffected(1))
+
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA() AND table_name = $1 AND table_type = $2`)).WithArgs("outbox_events", "BASE TABLE").WillReturnRows(sqlmock.NewRows(
+					[]string{"TABLES"}))
+
+				mock.ExpectExec(test_lib.QuoteMeta(`CREATE TABLE "outbox_events" ("id" bigserial,"record_type" text,"record_id" text,"action" text,"request_id" text,"created_at" timestamptz,"data" text,"published_at" timestamptz,"attempts" bigint,"last_error" text,"parked_at" timestamptz,PRIMARY KEY ("id"))`)).WithArgs([]driver.Value{}...).WillReturnResult(driver.RowsAffected(1))
+
+				mock.ExpectExec(test_lib.QuoteMeta(`CREATE INDEX IF NOT EXISTS "idx_outbox_events_published_at" ON "outbox_events" ("published_at","parked_at")`)).WithArgs([]driver.Value{}...).WillReturnResult(driver.RowsAffected(1))
+
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA() AND table_name = $1 AND table_type = $2`)).WithArgs("webhook_subscriptions", "BASE TABLE").WillReturnRows(sqlmock.NewRows(
+					[]string{"TABLES"}))
+
+				mock.ExpectExec(test_lib.QuoteMeta(`CREATE TABLE "webhook_subscriptions" ("id" bigserial,"url" text,"type" text,"events" text,"condition" text,"secret" text,PRIMARY KEY ("id"))`)).WithArgs([]driver.Value{}...).WillReturnResult(driver.RowsAffected(1))
+
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA() AND table_name = $1 AND table_type = $2`)).WithArgs("webhook_deliveries", "BASE TABLE").WillReturnRows(sqlmock.NewRows(
+					[]string{"TABLES"}))
+
+				mock.ExpectExec(test_lib.QuoteMeta(`CREATE TABLE "webhook_deliveries" ("id" bigserial,"subscription_id" bigint,"event_id" bigint,"event" text,"payload" text,"state" text,"attempts" bigint,"response_status" bigint,"last_error" text,"created_at" timestamptz,"next_attempt_at" timestamptz,"delivered_at" timestamptz,PRIMARY KEY ("id"))`)).WithArgs([]driver.Value{}...).WillReturnResult(driver.RowsAffected(1))
+
+				mock.ExpectExec(test_lib.QuoteMeta(`CREATE UNIQUE INDEX IF NOT EXISTS "idx_webhook_deliveries_event" ON "webhook_deliveries" ("subscription_id","event_id")`)).WithArgs([]driver.Value{}...).WillReturnResult(driver.RowsAffected(1))
+
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA() AND table_name = $1 AND table_type = $2`)).WithArgs("webhook_dead_letters", "BASE TABLE").WillReturnRows(sqlmock.NewRows(
+					[]string{"TABLES"}))
+
+				mock.ExpectExec(test_lib.QuoteMeta(`CREATE TABLE "webhook_dead_letters" ("id" bigserial,"delivery_id" bigint,"subscription_id" bigint,"url" text,"event" text,"payload" text,"attempts" bigint,"last_error" text,"created_at" timestamptz,PRIMARY KEY ("id"))`)).WithArgs([]driver.Value{}...).WillReturnResult(driver.RowsAffected(1))
+
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA() AND table_name = $1 AND table_type = $2`)).WithArgs("idempotency_records", "BASE TABLE").WillReturnRows(sqlmock.NewRows(
+					[]string{"TABLES"}))
+
+				mock.ExpectExec(test_lib.QuoteMeta(`CREATE TABLE "idempotency_records" ("id" bigserial,"actor" text,"idempotency_key" text,"fingerprint" text,"status" bigint,"header" text,"body" bytea,"created_at" timestamptz,"expires_at" timestamptz,PRIMARY KEY ("id"))`)).WithArgs([]driver.Value{}...).WillReturnResult(driver.RowsAffected(1))
 
-				mock.ExpectExec(test_lib.QuoteMeta(`CREATE UNIQUE INDEX IF NOT EXISTS "idx_books_title" ON "books" ("title")`)).WithArgs([]driver.Value{}...).WillReturnResult(driver.RowsAffected(1))
+				mock.ExpectExec(test_lib.QuoteMeta(`CREATE UNIQUE INDEX IF NOT EXISTS "idx_idempotency_records_key" ON "idempotency_records" ("actor","idempotency_key")`)).WithArgs([]driver.Value{}...).WillReturnResult(driver.RowsAffected(1))
 			case "TestBook/Finds_Empty":
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books"`)).WithArgs([]driver.Value{}...).WillReturnRows(sqlmock.NewRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE "books"."deleted_at" IS NULL`)).WithArgs([]driver.Value{}...).WillReturnRows(sqlmock.NewRows(
 					[]string{"count"}).AddRow(0))
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" LIMIT 1000`)).WithArgs([]driver.Value{}...).WillReturnRows(sqlmock.NewRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE "books"."deleted_at" IS NULL ORDER BY "id" LIMIT 1000`)).WithArgs([]driver.Value{}...).WillReturnRows(sqlmock.NewRows(
 					[]string{"id", "title", "author", "summary"}))
 			case "TestBook/Insert_Harry_Potter_and_the_Philosopher's_Stone":
 				mock.ExpectBegin()
-				mock.ExpectQuery(test_lib.QuoteMeta(`INSERT INTO "books" ("title","author","summary") VALUES ($1,$2,$3) RETURNING "id"`)).WithArgs("Harry Potter and the Philosopher's Stone", "J. K. Rawling", "The boy who lived").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				mock.ExpectQuery(test_lib.QuoteMeta(`INSERT INTO "books" ("title","author","summary","deleted_at") VALUES ($1,$2,$3,$4) RETURNING "id"`)).WithArgs("Harry Potter and the Philosopher's Stone", "J. K. Rawling", "The boy who lived", nil).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				expectIndex(mock, 1, "Harry Potter and the Philosopher's Stone", "J. K. Rawling", "The boy who lived")
+				expectRevision(mock, 1, 1, models.AuditCreate)
+				expectEvent(mock, 1, models.AuditCreate)
 				mock.ExpectCommit()
 			case "TestBook/Insert_Harry_Potter_and_the_Chamber_of_Secrets":
 				mock.ExpectBegin()
-				mock.ExpectQuery(test_lib.QuoteMeta(`INSERT INTO "books" ("title","author","summary") VALUES ($1,$2,$3) RETURNING "id"`)).WithArgs("Harry Potter and the Chamber of Secrets", "J. K. Rawling", "").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+				mock.ExpectQuery(test_lib.QuoteMeta(`INSERT INTO "books" ("title","author","summary","deleted_at") VALUES ($1,$2,$3,$4) RETURNING "id"`)).WithArgs("Harry Potter and the Chamber of Secrets", "J. K. Rawling", "", nil).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+				expectIndex(mock, 2, "Harry Potter and the Chamber of Secrets", "J. K. Rawling", "")
+				expectRevision(mock, 2, 1, models.AuditCreate)
+				expectEvent(mock, 2, models.AuditCreate)
 				mock.ExpectCommit()
 			case "TestBook/Finds":
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books"`)).WithArgs([]driver.Value{}...).WillReturnRows(sqlmock.NewRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE "books"."deleted_at" IS NULL`)).WithArgs([]driver.Value{}...).WillReturnRows(sqlmock.NewRows(
 					[]string{"count"}).AddRow(2))
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" LIMIT 1000`)).WithArgs([]driver.Value{}...).WillReturnRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE "books"."deleted_at" IS NULL ORDER BY "id" LIMIT 1000`)).WithArgs([]driver.Value{}...).WillReturnRows(
 					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
 						AddRow(1, "Harry Potter and the Philosopher's Stone", "J. K. Rawling", "The boy who lived").
 						AddRow(2, "Harry Potter and the Chamber of Secrets", "J. K. Rawling", ""))
 			case "TestBook/Finds_Chamber_of_Secrets":
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE title LIKE $1`)).WithArgs("%Chamber of Secrets%").WillReturnRows(sqlmock.NewRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE title LIKE $1 AND "books"."deleted_at" IS NULL`)).WithArgs("%Chamber of Secrets%").WillReturnRows(sqlmock.NewRows(
 					[]string{"count"}).AddRow(1))
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE title LIKE $1 LIMIT 1000`)).WithArgs("%Chamber of Secrets%").WillReturnRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE title LIKE $1 AND "books"."deleted_at" IS NULL ORDER BY "id" LIMIT 1000`)).WithArgs("%Chamber of Secrets%").WillReturnRows(
 					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
 						AddRow(2, "Harry Potter and the Chamber of Secrets", "J. K. Rawling", ""))
 			case "TestBook/Finds_chamber_of_secrets":
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE title LIKE $1`)).WithArgs("%chamber of secrets%").WillReturnRows(sqlmock.NewRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE title LIKE $1 AND "books"."deleted_at" IS NULL`)).WithArgs("%chamber of secrets%").WillReturnRows(sqlmock.NewRows(
 					[]string{"count"}).AddRow(0))
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE title LIKE $1 LIMIT 1000`)).WithArgs("%chamber of secrets%").WillReturnRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE title LIKE $1 AND "books"."deleted_at" IS NULL ORDER BY "id" LIMIT 1000`)).WithArgs("%chamber of secrets%").WillReturnRows(
 					sqlmock.NewRows([]string{"id", "title", "author", "summary"}))
 			case "TestBook/Finds_chamber_of_secrets_using_ILIKE":
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE title ILIKE $1`)).WithArgs("%chamber of secrets%").WillReturnRows(sqlmock.NewRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE title ILIKE $1 AND "books"."deleted_at" IS NULL`)).WithArgs("%chamber of secrets%").WillReturnRows(sqlmock.NewRows(
 					[]string{"count"}).AddRow(1))
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE title ILIKE $1 LIMIT 1000`)).WithArgs("%chamber of secrets%").WillReturnRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE title ILIKE $1 AND "books"."deleted_at" IS NULL ORDER BY "id" LIMIT 1000`)).WithArgs("%chamber of secrets%").WillReturnRows(
 					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
 						AddRow(2, "Harry Potter and the Chamber of Secrets", "J. K. Rawling", ""))
 			case "TestBook/Insert_Harry_Potter_and_Book_of_Dark_Magic":
 				mock.ExpectBegin()
-				mock.ExpectQuery(test_lib.QuoteMeta(`INSERT INTO "books" ("title","author","summary") VALUES ($1,$2,$3) RETURNING "id"`)).WithArgs("Harry Potter and Book of Dark Magic", "Lord Voldermort", "").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+				mock.ExpectQuery(test_lib.QuoteMeta(`INSERT INTO "books" ("title","author","summary","deleted_at") VALUES ($1,$2,$3,$4) RETURNING "id"`)).WithArgs("Harry Potter and Book of Dark Magic", "Lord Voldermort", "", nil).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+				expectIndex(mock, 3, "Harry Potter and Book of Dark Magic", "Lord Voldermort", "")
+				expectRevision(mock, 3, 1, models.AuditCreate)
+				expectEvent(mock, 3, models.AuditCreate)
 				mock.ExpectCommit()
 			case "TestBook/Finds_include_evil_book":
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books"`)).WithArgs([]driver.Value{}...).WillReturnRows(sqlmock.NewRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE "books"."deleted_at" IS NULL`)).WithArgs([]driver.Value{}...).WillReturnRows(sqlmock.NewRows(
 					[]string{"count"}).AddRow(3))
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" LIMIT 1000`)).WithArgs([]driver.Value{}...).WillReturnRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE "books"."deleted_at" IS NULL ORDER BY "id" LIMIT 1000`)).WithArgs([]driver.Value{}...).WillReturnRows(
 					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
 						AddRow(1, "Harry Potter and the Philosopher's Stone", "J. K. Rawling", "The boy who lived").
 						AddRow(2, "Harry Potter and the Chamber of Secrets", "J. K. Rawling", "").
 						AddRow(3, "Harry Potter and Book of Dark Magic", "Lord Voldermort", ""))
 			case "TestBook/Finds_goods_book_only":
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE NOT (author = $1)`)).WithArgs("Lord Voldermort").WillReturnRows(sqlmock.NewRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE NOT (author = $1) AND "books"."deleted_at" IS NULL`)).WithArgs("Lord Voldermort").WillReturnRows(sqlmock.NewRows(
 					[]string{"count"}).AddRow(2))
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE NOT (author = $1) LIMIT 1000`)).WithArgs("Lord Voldermort").WillReturnRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE NOT (author = $1) AND "books"."deleted_at" IS NULL ORDER BY "id" LIMIT 1000`)).WithArgs("Lord Voldermort").WillReturnRows(
 					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
 						AddRow(1, "Harry Potter and the Philosopher's Stone", "J. K. Rawling", "The boy who lived").
 						AddRow(2, "Harry Potter and the Chamber of Secrets", "J. K. Rawling", ""))
 			case "TestBook/Insert_Tintin_in_Tibet":
 				mock.ExpectBegin()
-				mock.ExpectQuery(test_lib.QuoteMeta(`INSERT INTO "books" ("title","author","summary") VALUES ($1,$2,$3) RETURNING "id"`)).WithArgs("Tintin in Tibet", "Herge", "").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+				mock.ExpectQuery(test_lib.QuoteMeta(`INSERT INTO "books" ("title","author","summary","deleted_at") VALUES ($1,$2,$3,$4) RETURNING "id"`)).WithArgs("Tintin in Tibet", "Herge", "", nil).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+				expectIndex(mock, 4, "Tintin in Tibet", "Herge", "")
+				expectRevision(mock, 4, 1, models.AuditCreate)
+				expectEvent(mock, 4, models.AuditCreate)
 				mock.ExpectCommit()
 			case "TestBook/Finds_many_books":
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books"`)).WithArgs([]driver.Value{}...).WillReturnRows(sqlmock.NewRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE "books"."deleted_at" IS NULL`)).WithArgs([]driver.Value{}...).WillReturnRows(sqlmock.NewRows(
 					[]string{"count"}).AddRow(4))
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" LIMIT 1000`)).WithArgs([]driver.Value{}...).WillReturnRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE "books"."deleted_at" IS NULL ORDER BY "id" LIMIT 1000`)).WithArgs([]driver.Value{}...).WillReturnRows(
 					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
 						AddRow(1, "Harry Potter and the Philosopher's Stone", "J. K. Rawling", "The boy who lived").
 						AddRow(2, "Harry Potter and the Chamber of Secrets", "J. K. Rawling", "").
 						AddRow(3, "Harry Potter and Book of Dark Magic", "Lord Voldermort", "").
 						AddRow(4, "Tintin in Tibet", "Herge", ""))
 			case "TestBook/Finds_Harry_Potter_books":
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE title LIKE $1`)).WithArgs("%Harry Potter%").WillReturnRows(sqlmock.NewRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE title LIKE $1 AND "books"."deleted_at" IS NULL`)).WithArgs("%Harry Potter%").WillReturnRows(sqlmock.NewRows(
 					[]string{"count"}).AddRow(3))
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE title LIKE $1 LIMIT 1000`)).WithArgs("%Harry Potter%").WillReturnRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE title LIKE $1 AND "books"."deleted_at" IS NULL ORDER BY "id" LIMIT 1000`)).WithArgs("%Harry Potter%").WillReturnRows(
 					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
 						AddRow(1, "Harry Potter and the Philosopher's Stone", "J. K. Rawling", "The boy who lived").
 						AddRow(2, "Harry Potter and the Chamber of Secrets", "J. K. Rawling", "").
 						AddRow(3, "Harry Potter and Book of Dark Magic", "Lord Voldermort", ""))
 			case "TestBook/Finds_Harry_Potter_books_from_J._K._Rawling":
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE title LIKE $1 AND author = $2`)).WithArgs("%Harry Potter%", "J. K. Rawling").WillReturnRows(sqlmock.NewRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE (title LIKE $1 AND author = $2) AND "books"."deleted_at" IS NULL`)).WithArgs("%Harry Potter%", "J. K. Rawling").WillReturnRows(sqlmock.NewRows(
 					[]string{"count"}).AddRow(2))
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE title LIKE $1 AND author = $2 LIMIT 1000`)).
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE (title LIKE $1 AND author = $2) AND "books"."deleted_at" IS NULL ORDER BY "id" LIMIT 1000`)).
 					WithArgs("%Harry Potter%", "J. K. Rawling").WillReturnRows(
 					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
 						AddRow(1, "Harry Potter and the Philosopher's Stone", "J. K. Rawling", "The boy who lived").
 						AddRow(2, "Harry Potter and the Chamber of Secrets", "J. K. Rawling", ""))
 			case "TestBook/Delete_Evil_book":
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE id = $1 ORDER BY "books"."id" LIMIT 1`)).
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE id = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT 1`)).
 					WithArgs("3").WillReturnRows(
 					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
 						AddRow(3, "Harry Potter and Book of Dark Magic", "Lord Voldermort", ""))
 				mock.ExpectBegin()
-				mock.ExpectExec(test_lib.QuoteMeta(`DELETE FROM "books" WHERE "books"."id" = $1`)).
-					WithArgs(3).WillReturnResult(driver.RowsAffected(1))
+				mock.ExpectExec(test_lib.QuoteMeta(`UPDATE "books" SET "deleted_at"=$1 WHERE "books"."id" = $2 AND "books"."deleted_at" IS NULL`)).
+					WithArgs(sqlmock.AnyArg(), 3).WillReturnResult(driver.RowsAffected(1))
+				expectUnindex(mock, 3)
+				expectRevision(mock, 3, 2, models.AuditDelete)
+				expectEvent(mock, 3, models.AuditDelete)
 				mock.ExpectCommit()
 			case "TestBook/Finds_many_good_books":
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books"`)).WithArgs([]driver.Value{}...).WillReturnRows(sqlmock.NewRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE "books"."deleted_at" IS NULL`)).WithArgs([]driver.Value{}...).WillReturnRows(sqlmock.NewRows(
 					[]string{"count"}).AddRow(3))
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" LIMIT 1000`)).WithArgs([]driver.Value{}...).WillReturnRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE "books"."deleted_at" IS NULL ORDER BY "id" LIMIT 1000`)).WithArgs([]driver.Value{}...).WillReturnRows(
 					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
 						AddRow(1, "Harry Potter and the Philosopher's Stone", "J. K. Rawling", "The boy who lived").
 						AddRow(2, "Harry Potter and the Chamber of Secrets", "J. K. Rawling", "").
 						AddRow(4, "Tintin in Tibet", "Herge", ""))
 			case "TestBook/Insert_Tintin_in_Jakarta":
 				mock.ExpectBegin()
-				mock.ExpectQuery(test_lib.QuoteMeta(`INSERT INTO "books" ("title","author","summary") VALUES ($1,$2,$3) RETURNING "id"`)).WithArgs("Tintin in Jakarta", "Herge", "").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+				mock.ExpectQuery(test_lib.QuoteMeta(`INSERT INTO "books" ("title","author","summary","deleted_at") VALUES ($1,$2,$3,$4) RETURNING "id"`)).WithArgs("Tintin in Jakarta", "Herge", "", nil).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+				expectIndex(mock, 5, "Tintin in Jakarta", "Herge", "")
+				expectRevision(mock, 5, 1, models.AuditCreate)
+				expectEvent(mock, 5, models.AuditCreate)
 				mock.ExpectCommit()
 			case "TestBook/Finds_tintin_books":
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE title LIKE $1`)).WithArgs("%Tintin%").WillReturnRows(sqlmock.NewRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE title LIKE $1 AND "books"."deleted_at" IS NULL`)).WithArgs("%Tintin%").WillReturnRows(sqlmock.NewRows(
 					[]string{"count"}).AddRow(2))
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE title LIKE $1 LIMIT 1000`)).WithArgs("%Tintin%").WillReturnRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE title LIKE $1 AND "books"."deleted_at" IS NULL ORDER BY "id" LIMIT 1000`)).WithArgs("%Tintin%").WillReturnRows(
 					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
 						AddRow(4, "Tintin in Tibet", "Herge", "").
 						AddRow(5, "Tintin in Jakarta", "Herge", ""))
 			case "TestBook/Update_typo_Tintin_in_America":
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE id = $1 ORDER BY "books"."id" LIMIT 1`)).
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE id = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT 1`)).
 					WithArgs("5").WillReturnRows(
 					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
 						AddRow(5, "Tintin in Jakarta", "Herge", ""))
 				mock.ExpectBegin()
-				mock.ExpectExec(test_lib.QuoteMeta(`UPDATE "books" SET "title"=$1,"author"=$2 WHERE "id" = $3`)).
-					WithArgs("Tintin in America", "Herge", 5).WillReturnResult(driver.RowsAffected(1))
+				mock.ExpectExec(test_lib.QuoteMeta(`UPDATE "books" SET "title"=$1 WHERE "books"."deleted_at" IS NULL AND "id" = $2`)).
+					WithArgs("Tintin in America", 5).WillReturnResult(driver.RowsAffected(1))
+				expectIndex(mock, 5, "Tintin in America", "Herge", "")
+				expectRevision(mock, 5, 2, models.AuditUpdate)
+				expectEvent(mock, 5, models.AuditUpdate)
 				mock.ExpectCommit()
 			case "TestBook/Finds_updated_tintin_books":
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE title LIKE $1`)).WithArgs("%Tintin%").WillReturnRows(sqlmock.NewRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE title LIKE $1 AND "books"."deleted_at" IS NULL`)).WithArgs("%Tintin%").WillReturnRows(sqlmock.NewRows(
 					[]string{"count"}).AddRow(2))
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE title LIKE $1 LIMIT 1000`)).WithArgs("%Tintin%").WillReturnRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE title LIKE $1 AND "books"."deleted_at" IS NULL ORDER BY "id" LIMIT 1000`)).WithArgs("%Tintin%").WillReturnRows(
 					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
 						AddRow(4, "Tintin in Tibet", "Herge", "").
 						AddRow(5, "Tintin in America", "Herge", ""))
 			case "TestBook/Finds_with_limit":
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books"`)).WithArgs([]driver.Value{}...).WillReturnRows(sqlmock.NewRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE "books"."deleted_at" IS NULL`)).WithArgs([]driver.Value{}...).WillReturnRows(sqlmock.NewRows(
 					[]string{"count"}).AddRow(4))
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" LIMIT 2`)).WithArgs([]driver.Value{}...).WillReturnRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE "books"."deleted_at" IS NULL ORDER BY "id" LIMIT 2`)).WithArgs([]driver.Value{}...).WillReturnRows(
 					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
 						AddRow(1, "Harry Potter and the Philosopher's Stone", "J. K. Rawling", "The boy who lived").
 						AddRow(2, "Harry Potter and the Chamber of Secrets", "J. K. Rawling", ""))
 			case "TestBook/Insert_Duplicate_Tintin_in_America":
 				mock.ExpectBegin()
-				mock.ExpectQuery(test_lib.QuoteMeta(`INSERT INTO "books" ("title","author","summary") VALUES ($1,$2,$3) RETURNING "id"`)).WithArgs("Tintin in America", "Herge", "").WillReturnError(errors.New(`ERROR: duplicate key value violates unique constraint "idx_books_title" (SQLSTATE 23505)`))
+				mock.ExpectQuery(test_lib.QuoteMeta(`INSERT INTO "books" ("title","author","summary","deleted_at") VALUES ($1,$2,$3,$4) RETURNING "id"`)).WithArgs("Tintin in America", "Herge", "", nil).WillReturnError(duplicateTitle)
 				mock.ExpectRollback()
 			case "TestBook/Update_unknown_book":
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE id = $1 ORDER BY "books"."id" LIMIT 1`)).WithArgs("9999").WillReturnRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE id = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT 1`)).WithArgs("9999").WillReturnRows(
 					sqlmock.NewRows([]string{"id", "title", "author", "summary"}))
 			case "TestBook/Delete_unknown_book":
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE id = $1 ORDER BY "books"."id" LIMIT 1`)).WithArgs("9999").WillReturnRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE id = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT 1`)).WithArgs("9999").WillReturnRows(
 					sqlmock.NewRows([]string{"id", "title", "author", "summary"}))
 			case "TestBook/Get_unknown_book":
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE id = $1 ORDER BY "books"."id" LIMIT 1`)).WithArgs("9999").WillReturnRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE id = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT 1`)).WithArgs("9999").WillReturnRows(
 					sqlmock.NewRows([]string{"id", "title", "author", "summary"}))
 			case "TestBook/Update_lead_to_duplicate_record":
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE id = $1 ORDER BY "books"."id" LIMIT 1`)).
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE id = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT 1`)).
 					WithArgs("5").WillReturnRows(
 					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
 						AddRow(5, "Tintin in Jakarta", "Herge", ""))
 				mock.ExpectBegin()
-				mock.ExpectExec(test_lib.QuoteMeta(`UPDATE "books" SET "title"=$1,"author"=$2 WHERE "id" = $3`)).
-					WithArgs("Harry Potter and the Philosopher's Stone", "Herge", 5).WillReturnError(errors.New(`ERROR: duplicate key value violates unique constraint "idx_books_title" (SQLSTATE 23505)`))
+				mock.ExpectExec(test_lib.QuoteMeta(`UPDATE "books" SET "title"=$1 WHERE "books"."deleted_at" IS NULL AND "id" = $2`)).
+					WithArgs("Harry Potter and the Philosopher's Stone", 5).WillReturnError(duplicateTitle)
 				mock.ExpectRollback()
 			case "TestBook/Finds_books_id,_title_only":
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books"`)).WithArgs([]driver.Value{}...).WillReturnRows(sqlmock.NewRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE "books"."deleted_at" IS NULL`)).WithArgs([]driver.Value{}...).WillReturnRows(sqlmock.NewRows(
 					[]string{"count"}).AddRow(4))
-				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT "id","title" FROM "books" ORDER BY "id" DESC LIMIT 1000`)).WithArgs([]driver.Value{}...).WillReturnRows(
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT "id","title" FROM "books" WHERE "books"."deleted_at" IS NULL ORDER BY "id" DESC LIMIT 1000`)).WithArgs([]driver.Value{}...).WillReturnRows(
 					sqlmock.NewRows([]string{"id", "title"}).
 						AddRow(5, "Tintin in America").
 						AddRow(4, "Tintin in Tibet").
 						AddRow(2, "Harry Potter and the Chamber of Secrets").
 						AddRow(1, "Harry Potter and the Philosopher's Stone"))
+			case "TestBook/Finds_with_unknown_field":
+			case "TestBook/Insert_without_author":
+			case "TestBook/Delete_Tintin_in_Tibet_without_content":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE id = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT 1`)).
+					WithArgs("4").WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
+						AddRow(4, "Tintin in Tibet", "Herge", ""))
+				mock.ExpectBegin()
+				mock.ExpectExec(test_lib.QuoteMeta(`UPDATE "books" SET "deleted_at"=$1 WHERE "books"."id" = $2 AND "books"."deleted_at" IS NULL`)).
+					WithArgs(sqlmock.AnyArg(), 4).WillReturnResult(driver.RowsAffected(1))
+				expectUnindex(mock, 4)
+				expectRevision(mock, 4, 2, models.AuditDelete)
+				expectEvent(mock, 4, models.AuditDelete)
+				mock.ExpectCommit()
+			case "TestBook/Finds_books_by_id_list":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE (id IN ($1,$2) AND title IS NOT NULL) AND "books"."deleted_at" IS NULL`)).WithArgs(float64(1), float64(4)).WillReturnRows(sqlmock.NewRows(
+					[]string{"count"}).AddRow(2))
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT "id","title" FROM "books" WHERE (id IN ($1,$2) AND title IS NOT NULL) AND "books"."deleted_at" IS NULL ORDER BY "id" LIMIT 1000`)).WithArgs(float64(1), float64(4)).WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title"}).
+						AddRow(1, "Harry Potter and the Philosopher's Stone").
+						AddRow(4, "Tintin in Tibet"))
+			case "TestBook/Merge_patch_Harry_Potter_author":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE id = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT 1`)).
+					WithArgs("1").WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
+						AddRow(1, "Harry Potter and the Philosopher's Stone", "J. K. Rawling", "The boy who lived"))
+				mock.ExpectBegin()
+				mock.ExpectExec(test_lib.QuoteMeta(`UPDATE "books" SET "author"=$1,"summary"=$2 WHERE "books"."deleted_at" IS NULL AND "id" = $3`)).
+					WithArgs("J. K. Rowling", "", 1).WillReturnResult(driver.RowsAffected(1))
+				expectIndex(mock, 1, "Harry Potter and the Philosopher's Stone", "J. K. Rowling", "")
+				expectRevision(mock, 1, 2, models.AuditUpdate)
+				expectEvent(mock, 1, models.AuditUpdate)
+				mock.ExpectCommit()
+			case "TestBook/JSON_patch_with_failed_test":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE id = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT 1`)).
+					WithArgs("1").WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
+						AddRow(1, "Harry Potter and the Philosopher's Stone", "J. K. Rowling", ""))
+			case "TestBook/JSON_patch_Harry_Potter_summary":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE id = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT 1`)).
+					WithArgs("1").WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
+						AddRow(1, "Harry Potter and the Philosopher's Stone", "J. K. Rowling", ""))
+				mock.ExpectBegin()
+				mock.ExpectExec(test_lib.QuoteMeta(`UPDATE "books" SET "summary"=$1 WHERE "books"."deleted_at" IS NULL AND "id" = $2`)).
+					WithArgs("The boy who lived", 1).WillReturnResult(driver.RowsAffected(1))
+				expectIndex(mock, 1, "Harry Potter and the Philosopher's Stone", "J. K. Rowling", "The boy who lived")
+				expectRevision(mock, 1, 3, models.AuditUpdate)
+				expectEvent(mock, 1, models.AuditUpdate)
+				mock.ExpectCommit()
+			case "TestBook/Get_Harry_Potter_with_ETag", "TestBook/Get_unmodified_Harry_Potter", "TestBook/Update_Harry_Potter_with_stale_ETag":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE id = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT 1`)).
+					WithArgs("1").WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
+						AddRow(1, "Harry Potter and the Philosopher's Stone", "J. K. Rowling", "The boy who lived"))
+			case "TestBook/Finds_first_page_with_cursor":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE "books"."deleted_at" IS NULL ORDER BY "id" LIMIT 3`)).WithArgs([]driver.Value{}...).WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
+						AddRow(1, "Harry Potter and the Philosopher's Stone", "J. K. Rowling", "The boy who lived").
+						AddRow(2, "Harry Potter and the Chamber of Secrets", "J. K. Rawling", "").
+						AddRow(5, "Tintin in America", "Herge", ""))
+			case "TestBook/Finds_next_page_with_cursor":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE "books"."deleted_at" IS NULL`)).WithArgs([]driver.Value{}...).WillReturnRows(sqlmock.NewRows(
+					[]string{"count"}).AddRow(3))
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE "books"."deleted_at" IS NULL AND (id > $1) ORDER BY "id" LIMIT 3`)).WithArgs(2).WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
+						AddRow(5, "Tintin in America", "Herge", ""))
+			case "TestBook/Finds_prev_page_with_cursor":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE (id < $1) AND "books"."deleted_at" IS NULL ORDER BY "id" DESC LIMIT 3`)).WithArgs(5).WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
+						AddRow(2, "Harry Potter and the Chamber of Secrets", "J. K. Rawling", "").
+						AddRow(1, "Harry Potter and the Philosopher's Stone", "J. K. Rowling", "The boy who lived"))
+			case "TestBook/Finds_with_tampered_cursor":
+			case "TestBook/Finds_books_by_author_then_title":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE "books"."deleted_at" IS NULL`)).WithArgs([]driver.Value{}...).WillReturnRows(sqlmock.NewRows(
+					[]string{"count"}).AddRow(3))
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT "id","title","author" FROM "books" WHERE "books"."deleted_at" IS NULL ORDER BY "author" NULLS LAST,"title" DESC LIMIT 1000`)).WithArgs([]driver.Value{}...).WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title", "author"}).
+						AddRow(5, "Tintin in America", "Herge").
+						AddRow(2, "Harry Potter and the Chamber of Secrets", "J. K. Rawling").
+						AddRow(1, "Harry Potter and the Philosopher's Stone", "J. K. Rowling"))
+			case "TestBook/Finds_with_filter":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE (title LIKE $1 AND NOT (author = $2)) AND "books"."deleted_at" IS NULL`)).WithArgs("%Harry%", "J. K. Rowling").WillReturnRows(sqlmock.NewRows(
+					[]string{"count"}).AddRow(1))
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE (title LIKE $1 AND NOT (author = $2)) AND "books"."deleted_at" IS NULL ORDER BY "id" LIMIT 1000`)).WithArgs("%Harry%", "J. K. Rowling").WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
+						AddRow(2, "Harry Potter and the Chamber of Secrets", "J. K. Rawling", ""))
+			case "TestBook/Finds_with_invalid_filter":
+			case "TestBook/Finds_with_OData_options":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE title LIKE $1 AND "books"."deleted_at" IS NULL`)).WithArgs("Harry%").WillReturnRows(sqlmock.NewRows(
+					[]string{"count"}).AddRow(2))
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT "id","title" FROM "books" WHERE title LIKE $1 AND "books"."deleted_at" IS NULL ORDER BY "title" DESC LIMIT 1`)).WithArgs("Harry%").WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title"}).
+						AddRow(1, "Harry Potter and the Philosopher's Stone"))
+			case "TestBook/Finds_with_RSQL_filter":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE author = $1 AND "books"."deleted_at" IS NULL`)).WithArgs("J. K. Rawling").WillReturnRows(sqlmock.NewRows(
+					[]string{"count"}).AddRow(1))
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE author = $1 AND "books"."deleted_at" IS NULL ORDER BY "id" LIMIT 1000`)).WithArgs("J. K. Rawling").WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
+						AddRow(2, "Harry Potter and the Chamber of Secrets", "J. K. Rawling", ""))
+			case "TestBook/Finds_with_unknown_syntax":
+			case "TestBook/Aggregate_books_per_author":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT author AS "author",COUNT(*) AS "books",MAX(id) AS "last" FROM "books" WHERE title LIKE $1 AND "books"."deleted_at" IS NULL GROUP BY "author" HAVING COUNT(*) >= $2 ORDER BY "author" LIMIT 1000`)).WithArgs("%Harry Potter%", 1.0).WillReturnRows(
+					sqlmock.NewRows([]string{"author", "books", "last"}).
+						AddRow("J. K. Rawling", 1, 2).
+						AddRow("J. K. Rowling", 1, 1))
+			case "TestBook/Aggregate_with_unknown_alias":
+			case "TestBook/Search_Harry_Potter":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT "books"."id" AS search_id,s.search_score,ts_headline('english', "books"."title", websearch_to_tsquery('english', $1), 'StartSel=<mark>, StopSel=</mark>') AS "search_title",ts_headline('english', "books"."author", websearch_to_tsquery('english', $2), 'StartSel=<mark>, StopSel=</mark>') AS "search_author",ts_headline('english', "books"."summary", websearch_to_tsquery('english', $3), 'StartSel=<mark>, StopSel=</mark>') AS "search_summary" FROM "books" JOIN (SELECT "id" AS search_id, ts_rank("document", websearch_to_tsquery('english', $4)) AS search_score FROM "books_search" WHERE "document" @@ websearch_to_tsquery('english', $5)) AS s ON s.search_id = "books"."id" WHERE "books"."deleted_at" IS NULL ORDER BY "search_score" DESC,"books"."id" LIMIT 1000`)).
+					WithArgs("harry -chamber", "harry -chamber", "harry -chamber", "harry -chamber", "harry -chamber").WillReturnRows(
+					sqlmock.NewRows([]string{"search_id", "search_score", "search_title", "search_author", "search_summary"}).
+						AddRow(1, 0.6, "<mark>Harry</mark> Potter and the Philosopher's Stone", "J. K. Rowling", "The boy who lived"))
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE "id" = $1 AND "books"."deleted_at" IS NULL`)).WithArgs(1).WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
+						AddRow(1, "Harry Potter and the Philosopher's Stone", "J. K. Rowling", "The boy who lived"))
+			case "TestBook/Bulk_in_best-effort_mode":
+				mock.ExpectBegin()
+				mock.ExpectQuery(test_lib.QuoteMeta(`INSERT INTO "books" ("title","author","summary","deleted_at") VALUES ($1,$2,$3,$4) RETURNING "id"`)).WithArgs("Tintin in Tibet", "Herge", "", nil).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(6))
+				expectIndex(mock, 6, "Tintin in Tibet", "Herge", "")
+				expectRevision(mock, 6, 1, models.AuditCreate)
+				expectEvent(mock, 6, models.AuditCreate)
+				mock.ExpectCommit()
+				mock.ExpectBegin()
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE "title" = $1 AND "books"."deleted_at" IS NULL LIMIT 1 FOR UPDATE`)).
+					WithArgs("Tintin in America").WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
+						AddRow(5, "Tintin in America", "Herge", ""))
+				mock.ExpectQuery(test_lib.QuoteMeta(`INSERT INTO "books" ("title","author","summary","deleted_at") VALUES ($1,$2,$3,$4) ON CONFLICT ("title")  WHERE deleted_at IS NULL DO UPDATE SET "author"="excluded"."author","summary"="excluded"."summary" RETURNING "id"`)).
+					WithArgs("Tintin in America", "Herge", "Tintin visits Chicago", nil).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+				expectIndex(mock, 5, "Tintin in America", "Herge", "Tintin visits Chicago")
+				expectRevision(mock, 5, 3, models.AuditUpdate)
+				expectEvent(mock, 5, models.AuditUpdate)
+				mock.ExpectCommit()
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE id = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT 1`)).
+					WithArgs(2.0).WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
+						AddRow(2, "Harry Potter and the Chamber of Secrets", "J. K. Rawling", ""))
+				mock.ExpectBegin()
+				mock.ExpectExec(test_lib.QuoteMeta(`UPDATE "books" SET "author"=$1 WHERE "books"."deleted_at" IS NULL AND "id" = $2`)).
+					WithArgs("J. K. Rowling", 2).WillReturnResult(driver.RowsAffected(1))
+				expectIndex(mock, 2, "Harry Potter and the Chamber of Secrets", "J. K. Rowling", "")
+				expectRevision(mock, 2, 2, models.AuditUpdate)
+				expectEvent(mock, 2, models.AuditUpdate)
+				mock.ExpectCommit()
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE id = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT 1`)).WithArgs(9999.0).WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title", "author", "summary"}))
+			case "TestBook/Bulk_in_atomic_mode":
+				mock.ExpectBegin()
+				mock.ExpectQuery(test_lib.QuoteMeta(`INSERT INTO "books" ("title","author","summary","deleted_at") VALUES ($1,$2,$3,$4) RETURNING "id"`)).WithArgs("Tintin in Congo", "Herge", "", nil).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+				expectIndex(mock, 7, "Tintin in Congo", "Herge", "")
+				expectRevision(mock, 7, 1, models.AuditCreate)
+				expectEvent(mock, 7, models.AuditCreate)
+				mock.ExpectQuery(test_lib.QuoteMeta(`INSERT INTO "books" ("title","author","summary","deleted_at") VALUES ($1,$2,$3,$4) RETURNING "id"`)).WithArgs("Tintin in Tibet", "Herge", "", nil).WillReturnError(duplicateTitle)
+				mock.ExpectRollback()
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE title LIKE $1 AND "books"."deleted_at" IS NULL`)).WithArgs("%Tintin%").WillReturnRows(sqlmock.NewRows(
+					[]string{"count"}).AddRow(2))
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT "id","title" FROM "books" WHERE title LIKE $1 AND "books"."deleted_at" IS NULL ORDER BY "id" LIMIT 1000`)).WithArgs("%Tintin%").WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title"}).
+						AddRow(5, "Tintin in America").
+						AddRow(6, "Tintin in Tibet"))
+			case "TestBook/Export_Tintin_books_as_CSV":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE title LIKE $1 AND "books"."deleted_at" IS NULL ORDER BY "id"`)).WithArgs("%Tintin%").WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
+						AddRow(5, "Tintin in America", "Herge", "Tintin visits Chicago").
+						AddRow(6, "Tintin in Tibet", "Herge", ""))
+			case "TestBook/Export_books_as_NDJSON":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT "id","title" FROM "books" WHERE "books"."deleted_at" IS NULL ORDER BY "id" DESC`)).WithArgs([]driver.Value{}...).WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title"}).
+						AddRow(6, "Tintin in Tibet").
+						AddRow(5, "Tintin in America").
+						AddRow(2, "Harry Potter and the Chamber of Secrets").
+						AddRow(1, "Harry Potter and the Philosopher's Stone"))
+			case "TestBook/Export_Herge_books_as_JSON":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE (author = $1 AND summary = $2) AND "books"."deleted_at" IS NULL`)).WithArgs("Herge", "").WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
+						AddRow(6, "Tintin in Tibet", "Herge", ""))
+			case "TestBook/Export_as_XML":
+			case "TestBook/Import_books_dry_run":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT "title" FROM "books" WHERE "title" IN ($1,$2,$3) AND "books"."deleted_at" IS NULL`)).WithArgs("Tintin in Congo", "Tintin in Tibet", "Tintin in Congo").WillReturnRows(
+					sqlmock.NewRows([]string{"title"}).AddRow("Tintin in Tibet"))
+			case "TestBook/Import_books_from_NDJSON":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT "title" FROM "books" WHERE "title" IN ($1,$2) AND "books"."deleted_at" IS NULL`)).WithArgs("Tintin in Congo", "The Blue Lotus").WillReturnRows(
+					sqlmock.NewRows([]string{"title"}))
+				mock.ExpectBegin()
+				mock.ExpectQuery(test_lib.QuoteMeta(`INSERT INTO "books" ("title","author","summary","deleted_at") VALUES ($1,$2,$3,$4) RETURNING "id"`)).WithArgs("Tintin in Congo", "Herge", "", nil).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+				expectIndex(mock, 7, "Tintin in Congo", "Herge", "")
+				expectRevision(mock, 7, 1, models.AuditCreate)
+				expectEvent(mock, 7, models.AuditCreate)
+				mock.ExpectQuery(test_lib.QuoteMeta(`INSERT INTO "books" ("title","author","summary","deleted_at") VALUES ($1,$2,$3,$4) RETURNING "id"`)).WithArgs("The Blue Lotus", "Herge", "", nil).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(8))
+				expectIndex(mock, 8, "The Blue Lotus", "Herge", "")
+				expectRevision(mock, 8, 1, models.AuditCreate)
+				expectEvent(mock, 8, models.AuditCreate)
+				mock.ExpectCommit()
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE id = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT 1`)).
+					WithArgs("7").WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
+						AddRow(7, "Tintin in Congo", "Herge", ""))
+			case "TestBook/Delete_Tintin_in_Congo":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE id = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT 1`)).
+					WithArgs("7").WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
+						AddRow(7, "Tintin in Congo", "Herge", ""))
+				mock.ExpectBegin()
+				mock.ExpectExec(test_lib.QuoteMeta(`UPDATE "books" SET "deleted_at"=$1 WHERE "books"."id" = $2 AND "books"."deleted_at" IS NULL`)).
+					WithArgs(sqlmock.AnyArg(), 7).WillReturnResult(driver.RowsAffected(1))
+				expectUnindex(mock, 7)
+				expectRevision(mock, 7, 2, models.AuditDelete)
+				expectEvent(mock, 7, models.AuditDelete)
+				mock.ExpectCommit()
+			case "TestBook/Get_deleted_Tintin_in_Congo":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE id = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT 1`)).WithArgs("7").WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title", "author", "summary"}))
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE id = $1 ORDER BY "books"."id" LIMIT 1`)).
+					WithArgs("7").WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title", "author", "summary", "deleted_at"}).
+						AddRow(7, "Tintin in Congo", "Herge", "", deletedAt))
+			case "TestBook/Finds_only_deleted_books":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE "books"."deleted_at" IS NOT NULL`)).WithArgs([]driver.Value{}...).WillReturnRows(sqlmock.NewRows(
+					[]string{"count"}).AddRow(3))
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE "books"."deleted_at" IS NOT NULL ORDER BY "id" LIMIT 1000`)).WithArgs([]driver.Value{}...).WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title", "author", "summary", "deleted_at"}).
+						AddRow(3, "Harry Potter and Book of Dark Magic", "Lord Voldermort", "", deletedAt).
+						AddRow(4, "Tintin in Tibet", "Herge", "", deletedAt).
+						AddRow(7, "Tintin in Congo", "Herge", "", deletedAt))
+			case "TestBook/Insert_deleted_Tintin_in_Congo_again":
+				mock.ExpectBegin()
+				mock.ExpectQuery(test_lib.QuoteMeta(`INSERT INTO "books" ("title","author","summary","deleted_at") VALUES ($1,$2,$3,$4) RETURNING "id"`)).WithArgs("Tintin in Congo", "Herge", "", nil).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+				expectIndex(mock, 9, "Tintin in Congo", "Herge", "")
+				expectRevision(mock, 9, 1, models.AuditCreate)
+				expectEvent(mock, 9, models.AuditCreate)
+				mock.ExpectCommit()
+			case "TestBook/Restore_Tintin_in_Congo_taken_again":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE id = $1 ORDER BY "books"."id" LIMIT 1`)).
+					WithArgs("7").WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title", "author", "summary", "deleted_at"}).
+						AddRow(7, "Tintin in Congo", "Herge", "", deletedAt))
+				mock.ExpectBegin()
+				mock.ExpectExec(test_lib.QuoteMeta(`UPDATE "books" SET "deleted_at"=$1 WHERE "books"."deleted_at" IS NOT NULL AND "id" = $2`)).
+					WithArgs(nil, 7).WillReturnError(duplicateTitle)
+				mock.ExpectRollback()
+			case "TestBook/Restore_Evil_book":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE id = $1 ORDER BY "books"."id" LIMIT 1`)).
+					WithArgs("3").WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title", "author", "summary", "deleted_at"}).
+						AddRow(3, "Harry Potter and Book of Dark Magic", "Lord Voldermort", "", deletedAt))
+				mock.ExpectBegin()
+				mock.ExpectExec(test_lib.QuoteMeta(`UPDATE "books" SET "deleted_at"=$1 WHERE "books"."deleted_at" IS NOT NULL AND "id" = $2`)).
+					WithArgs(nil, 3).WillReturnResult(driver.RowsAffected(1))
+				expectIndex(mock, 3, "Harry Potter and Book of Dark Magic", "Lord Voldermort", "")
+				expectRevision(mock, 3, 3, models.AuditRestore)
+				expectEvent(mock, 3, models.AuditRestore)
+				mock.ExpectCommit()
+			case "TestBook/Restore_book_that_is_not_deleted":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE id = $1 ORDER BY "books"."id" LIMIT 1`)).
+					WithArgs("1").WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title", "author", "summary", "deleted_at"}).
+						AddRow(1, "Harry Potter and the Philosopher's Stone", "J. K. Rowling", "The boy who lived", nil))
+			case "TestBook/Purge_deleted_books":
+				mock.ExpectBegin()
+				mock.ExpectExec(test_lib.QuoteMeta(`DELETE FROM "books" WHERE "books"."deleted_at" IS NOT NULL`)).
+					WithArgs([]driver.Value{}...).WillReturnResult(driver.RowsAffected(2))
+				mock.ExpectCommit()
+			case "TestBook/History_of_Harry_Potter":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "revisions" WHERE record_type = $1 AND record_id = $2`)).WithArgs("books", "1").WillReturnRows(sqlmock.NewRows(
+					[]string{"count"}).AddRow(3))
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "revisions" WHERE record_type = $1 AND record_id = $2 ORDER BY rev DESC LIMIT 1000`)).WithArgs("books", "1").WillReturnRows(
+					sqlmock.NewRows(revisionColumns).
+						AddRow(3, "books", "1", 3, models.AuditUpdate, "", "", deletedAt, harryPotterRowling, harryPotter, `[{"field":"summary","before":null,"after":"The boy who lived"}]`).
+						AddRow(2, "books", "1", 2, models.AuditUpdate, "", "", deletedAt, harryPotter, harryPotterRowling, `[{"field":"author","before":"J. K. Rawling","after":"J. K. Rowling"},{"field":"summary","before":"The boy who lived","after":null}]`).
+						AddRow(1, "books", "1", 1, models.AuditCreate, "", "", deletedAt, nil, harryPotter, `[]`))
+			case "TestBook/Get_revision_of_Harry_Potter":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "revisions" WHERE record_type = $1 AND record_id = $2 AND rev = $3 ORDER BY "revisions"."id" LIMIT 1`)).WithArgs("books", "1", 1).WillReturnRows(
+					sqlmock.NewRows(revisionColumns).
+						AddRow(1, "books", "1", 1, models.AuditCreate, "", "", deletedAt, nil, harryPotter, `[]`))
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "revisions" WHERE record_type = $1 AND record_id = $2 AND rev = $3 ORDER BY "revisions"."id" LIMIT 1`)).WithArgs("books", "1", 99).WillReturnRows(
+					sqlmock.NewRows(revisionColumns))
+			case "TestBook/Revert_Harry_Potter_author":
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "revisions" WHERE record_type = $1 AND record_id = $2 AND rev = $3 ORDER BY "revisions"."id" LIMIT 1`)).WithArgs("books", "1", 1).WillReturnRows(
+					sqlmock.NewRows(revisionColumns).
+						AddRow(1, "books", "1", 1, models.AuditCreate, "", "", deletedAt, nil, harryPotter, `[]`))
+				mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE id = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT 1`)).
+					WithArgs("1").WillReturnRows(
+					sqlmock.NewRows([]string{"id", "title", "author", "summary"}).
+						AddRow(1, "Harry Potter and the Philosopher's Stone", "J. K. Rowling", "The boy who lived"))
+				mock.ExpectBegin()
+				mock.ExpectExec(test_lib.QuoteMeta(`UPDATE "books" SET "author"=$1 WHERE "books"."deleted_at" IS NULL AND "id" = $2`)).
+					WithArgs("J. K. Rawling", 1).WillReturnResult(driver.RowsAffected(1))
+				expectIndex(mock, 1, "Harry Potter and the Philosopher's Stone", "J. K. Rawling", "The boy who lived")
+				expectRevision(mock, 1, 4, models.AuditUpdate)
+				expectEvent(mock, 1, models.AuditUpdate)
+				mock.ExpectCommit()
+			case "TestBook/Search_without_text":
 			default:
 				log.Printf("UNKNOWN mock name '%s'", name)
 			}
@@ -204,6 +581,32 @@ func TestBook(t *testing.T) {
 	}
 }
 
+// expectIndex expects the search index write of a created or updated book.
+func expectIndex(mock sqlmock.Sqlmock, id int, title, author, summary string) {
+	mock.ExpectExec(test_lib.QuoteMeta(`INSERT INTO "books_search" ("id","document") VALUES ($1,setweight(to_tsvector('english', $2), 'A') || setweight(to_tsvector('english', $3), 'B') || setweight(to_tsvector('english', $4), 'C')) ON CONFLICT ("id") DO UPDATE SET "document" = excluded."document"`)).
+		WithArgs(id, title, author, summary).WillReturnResult(driver.RowsAffected(1))
+}
+
+func expectUnindex(mock sqlmock.Sqlmock, id int) {
+	mock.ExpectExec(test_lib.QuoteMeta(`DELETE FROM "books_search" WHERE "id" = $1`)).WithArgs(id).WillReturnResult(driver.RowsAffected(1))
+}
+
+// expectRevision expects the audit of a write as revision rev of a book.
+func expectRevision(mock sqlmock.Sqlmock, id int, rev int, action string) {
+	mock.ExpectQuery(test_lib.QuoteMeta(`SELECT COALESCE(MAX(rev), 0) FROM "revisions" WHERE record_type = $1 AND record_id = $2`)).
+		WithArgs("books", strconv.Itoa(id)).WillReturnRows(sqlmock.NewRows([]string{"rev"}).AddRow(rev - 1))
+	mock.ExpectQuery(test_lib.QuoteMeta(`INSERT INTO "revisions" ("record_type","record_id","rev","action","actor","request_id","created_at","before","after","diff") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) RETURNING "id"`)).
+		WithArgs("books", strconv.Itoa(id), rev, action, "", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+}
+
+// expectEvent expects the outbox event of a write of a book.
+func expectEvent(mock sqlmock.Sqlmock, id int, action string) {
+	mock.ExpectQuery(test_lib.QuoteMeta(`INSERT INTO "outbox_events" ("record_type","record_id","action","request_id","created_at","data","published_at","attempts","last_error","parked_at") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) RETURNING "id"`)).
+		WithArgs("books", strconv.Itoa(id), action, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), nil, 0, "", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+}
+
 func TestBook_2(t *testing.T) {
 	if sqlDB, mock, err := sqlmock.New(); err != nil {
 		t.Fatal("init SQLMock Error", err)
@@ -221,9 +624,9 @@ func TestBook_2(t *testing.T) {
 					ctx.Api.T.Error("ExpectationsNotMet", err)
 				}
 			}()
-			mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE title LIKE $1`)).WithArgs("%Tintin%").WillReturnRows(sqlmock.NewRows(
+			mock.ExpectQuery(test_lib.QuoteMeta(`SELECT count(*) FROM "books" WHERE title LIKE $1 AND "books"."deleted_at" IS NULL`)).WithArgs("%Tintin%").WillReturnRows(sqlmock.NewRows(
 				[]string{"count"}).AddRow(0))
-			mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE title LIKE $1 LIMIT 10`)).WithArgs("%Tintin%").WillReturnRows(
+			mock.ExpectQuery(test_lib.QuoteMeta(`SELECT * FROM "books" WHERE title LIKE $1 AND "books"."deleted_at" IS NULL ORDER BY "id" LIMIT 10`)).WithArgs("%Tintin%").WillReturnRows(
 				sqlmock.NewRows([]string{"id", "title", "author", "summary"}))
 
 			ctx.Api.HttpGet("/books?limit=10&query="+models.NewQuery(nil, models.NewCondition().Like("title", "Tintin"), nil).String(),
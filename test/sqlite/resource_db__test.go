@@ -0,0 +1,122 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senomas/go-api/controllers"
+	"github.com/senomas/go-api/models"
+	test_lib "github.com/senomas/go-api/test/lib"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type Publisher struct {
+	ID      uint   `json:"id,omitempty" gorm:"primary_key"`
+	Name    string `json:"name,omitempty" gorm:"uniqueIndex"`
+	City    string `json:"city,omitempty"`
+	Version uint   `json:"version,omitempty" gorm:"version"`
+}
+
+type CreatePublisherInput struct {
+	Name string `json:"name" binding:"required"`
+	City string `json:"city"`
+}
+
+type UpdatePublisherInput struct {
+	Name string `json:"name"`
+	City string `json:"city"`
+}
+
+type ResponsePublishers struct {
+	Count int64       `json:"count"`
+	Data  []Publisher `json:"data"`
+}
+
+type ResponsePublisher struct {
+	Data Publisher `json:"data"`
+}
+
+func TestResourceDB(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	controllers.RegisterResource[Publisher, CreatePublisherInput, UpdatePublisherInput](r.Group("/api"), "/publishers", nil)
+	server := httptest.NewServer(r)
+	defer server.Close()
+	api := &test_lib.Api{Server: server, T: t}
+
+	if db, err := gorm.Open(sqlite.Open("file:resource?mode=memory"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)}); err != nil {
+		t.Fatal("Init GORM Error", err)
+	} else {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.SetMaxOpenConns(1)
+		}
+		models.Setup(db)
+		if err := models.AutoMigrate(db); err != nil {
+			t.Fatal("AutoMigrate Error", err)
+		}
+	}
+
+	api.HttpPut("/api/publishers", CreatePublisherInput{Name: "Casterman", City: "Tournai"}, 201, ResponsePublisher{
+		Data: Publisher{ID: 1, Name: "Casterman", City: "Tournai", Version: 1},
+	})
+	assert.Equal(t, `"1"`, api.Response.Header.Get("ETag"))
+
+	api.Header = http.Header{"If-Match": []string{`"1"`}}
+	api.HttpPatch("/api/publishers/1", UpdatePublisherInput{City: "Brussels"}, 200, ResponsePublisher{
+		Data: Publisher{ID: 1, Name: "Casterman", City: "Brussels", Version: 2},
+	})
+	assert.Equal(t, `"2"`, api.Response.Header.Get("ETag"))
+	api.HttpPatch("/api/publishers/1", UpdatePublisherInput{City: "Paris"}, 412, models.Problem{
+		Type:   "about:blank",
+		Title:  "Precondition Failed",
+		Status: 412,
+		Detail: "ETag mismatch",
+	})
+	api.Header = nil
+
+	api.HttpPost("/api/publishers", models.NewQuery(nil, models.NewCondition().Equal("city", "Brussels"), nil), 200, ResponsePublishers{
+		Count: 1,
+		Data:  []Publisher{{ID: 1, Name: "Casterman", City: "Brussels", Version: 2}},
+	})
+	api.HttpGet("/api/publishers/1", 200, ResponsePublisher{
+		Data: Publisher{ID: 1, Name: "Casterman", City: "Brussels", Version: 2},
+	})
+
+	api.Header = http.Header{"If-None-Match": []string{`W/"2"`}}
+	api.HttpGet("/api/publishers/1", 304, nil)
+	api.Header = http.Header{"If-Match": []string{`"1"`}}
+	api.HttpDelete("/api/publishers/1", 412, models.Problem{
+		Type:   "about:blank",
+		Title:  "Precondition Failed",
+		Status: 412,
+		Detail: "ETag mismatch",
+	})
+	api.Header = http.Header{"If-Match": []string{`"2"`}}
+	api.HttpDelete("/api/publishers/1", 200, map[string]bool{"data": true})
+
+	api.Header = http.Header{"Idempotency-Key": []string{"publisher-2"}}
+	for i := 0; i < 2; i++ {
+		api.HttpPut("/api/publishers", CreatePublisherInput{Name: "Dupuis", City: "Marcinelle"}, 201, ResponsePublisher{
+			Data: Publisher{ID: 1, Name: "Dupuis", City: "Marcinelle", Version: 1},
+		})
+		assert.Equal(t, "/api/publishers/1", api.Response.Header.Get("Location"))
+	}
+	assert.Equal(t, "true", api.Response.Header.Get("Idempotent-Replayed"))
+	api.HttpPut("/api/publishers", CreatePublisherInput{Name: "Lombard", City: "Brussels"}, 422, models.Problem{
+		Type:   "about:blank",
+		Title:  "Unprocessable Entity",
+		Status: 422,
+		Detail: "INVALID INPUT Idempotency-Key: KEY REUSED WITH A DIFFERENT REQUEST",
+		Errors: []models.FieldError{{Path: "Idempotency-Key", Message: "KEY REUSED WITH A DIFFERENT REQUEST"}},
+	})
+	api.Header = nil
+	api.HttpGet("/api/publishers", 200, ResponsePublishers{
+		Count: 1,
+		Data:  []Publisher{{ID: 1, Name: "Dupuis", City: "Marcinelle", Version: 1}},
+	})
+}
@@ -0,0 +1,156 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senomas/go-api/controllers"
+	"github.com/senomas/go-api/models"
+	test_lib "github.com/senomas/go-api/test/lib"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type ResponseWebhook struct {
+	Data models.WebhookSubscription `json:"data"`
+}
+
+type ResponseWebhooks struct {
+	Count int64                        `json:"count"`
+	Data  []models.WebhookSubscription `json:"data"`
+}
+
+type ResponseDeliveries struct {
+	Count int64                    `json:"count"`
+	Data  []models.WebhookDelivery `json:"data"`
+}
+
+func TestWebhookDB(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	publishers := controllers.RegisterResource[Publisher, CreatePublisherInput, UpdatePublisherInput](r.Group("/api"), "/publishers", nil)
+	publishers.Repository.Outbox = true
+	webhooks := controllers.SetupWebhookRoutes(r.Group("/api"))
+	// the receivers of the test listen on the loopback
+	webhooks.Webhooks.AllowAddress = func(ip net.IP) bool { return ip.IsLoopback() }
+	server := httptest.NewServer(r)
+	defer server.Close()
+	api := &test_lib.Api{Server: server, T: t}
+
+	if db, err := gorm.Open(sqlite.Open("file:webhook?mode=memory"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)}); err != nil {
+		t.Fatal("Init GORM Error", err)
+	} else {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.SetMaxOpenConns(1)
+		}
+		models.Setup(db)
+		if err := models.AutoMigrate(db); err != nil {
+			t.Fatal("AutoMigrate Error", err)
+		}
+	}
+
+	const secret = "0123456789abcdef"
+	received := []string{}
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := models.VerifyWebhook(secret, r.Header, body, time.Minute); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		var event models.OutboxEvent
+		assert.NoError(t, json.Unmarshal(body, &event))
+		received = append(received, r.Header.Get(models.WebhookEventHeader)+" "+string(event.Data))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer receiver.Close()
+
+	api.HttpPut("/api/webhooks", controllers.CreateWebhookInput{URL: "not a url", Type: "publishers", Secret: "short"}, 422, models.Problem{
+		Type:   "about:blank",
+		Title:  "Unprocessable Entity",
+		Status: 422,
+		Detail: "INVALID INPUT url: URL, secret: MIN 16",
+		Errors: []models.FieldError{{Path: "url", Field: "url", Message: "URL"}, {Path: "secret", Field: "secret", Message: "MIN 16"}},
+	})
+	api.HttpPut("/api/webhooks", controllers.CreateWebhookInput{URL: "ftp://example.com/hooks", Type: "publishers", Secret: secret}, 422, models.Problem{
+		Type:   "about:blank",
+		Title:  "Unprocessable Entity",
+		Status: 422,
+		Detail: "INVALID INPUT url: HTTP OR HTTPS URL",
+		Errors: []models.FieldError{{Path: "url", Field: "url", Message: "HTTP OR HTTPS URL"}},
+	})
+	api.HttpPut("/api/webhooks", controllers.CreateWebhookInput{URL: receiver.URL, Type: "comics", Secret: secret}, 422, models.Problem{
+		Type:   "about:blank",
+		Title:  "Unprocessable Entity",
+		Status: 422,
+		Detail: "INVALID INPUT type: UNKNOWN TYPE comics",
+		Errors: []models.FieldError{{Path: "type", Field: "type", Message: "UNKNOWN TYPE comics"}},
+	})
+
+	condition := models.JSONText(`{"o":"AND","e":[{"o":"=","f":"city","v":"Brussels"}]}`)
+	api.HttpPut("/api/webhooks", controllers.CreateWebhookInput{URL: receiver.URL, Type: "publishers", Events: []string{"create"}, Condition: condition, Secret: secret}, 201, ResponseWebhook{
+		Data: models.WebhookSubscription{ID: 1, URL: receiver.URL, Type: "publishers", Events: models.StringList{"create"}, Condition: condition},
+	})
+	api.HttpPatch("/api/webhooks/1", controllers.UpdateWebhookInput{Events: []string{"create", "update"}}, 200, ResponseWebhook{
+		Data: models.WebhookSubscription{ID: 1, URL: receiver.URL, Type: "publishers", Events: models.StringList{"create", "update"}, Condition: condition},
+	})
+	api.HttpPatch("/api/webhooks/1", controllers.UpdateWebhookInput{Events: []string{"publish"}}, 422, models.Problem{
+		Type:   "about:blank",
+		Title:  "Unprocessable Entity",
+		Status: 422,
+		Detail: "INVALID INPUT events[0]: UNKNOWN EVENT publish",
+		Errors: []models.FieldError{{Path: "events[0]", Field: "events", Message: "UNKNOWN EVENT publish"}},
+	})
+
+	api.HttpPut("/api/publishers", CreatePublisherInput{Name: "Casterman", City: "Tournai"}, 201, ResponsePublisher{
+		Data: Publisher{ID: 1, Name: "Casterman", City: "Tournai", Version: 1},
+	})
+	api.HttpPatch("/api/publishers/1", UpdatePublisherInput{City: "Brussels"}, 200, ResponsePublisher{
+		Data: Publisher{ID: 1, Name: "Casterman", City: "Brussels", Version: 2},
+	})
+	api.HttpDelete("/api/publishers/1", 200, map[string]bool{"data": true})
+
+	ctx := context.Background()
+	relay := models.NewOutboxRelay(nil, models.MultiSink{models.DB.Feed, webhooks.Webhooks})
+	n, err := relay.Flush(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	n, err = webhooks.Webhooks.Deliver(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{`publishers.update {"id":1,"name":"Casterman","city":"Brussels","version":2}`}, received)
+
+	var deliveries ResponseDeliveries
+	api.HttpGetInto("/api/webhooks/1/deliveries?state=delivered", 200, &deliveries)
+	assert.Equal(t, int64(1), deliveries.Count)
+	if assert.Len(t, deliveries.Data, 1) {
+		assert.Equal(t, "publishers.update", deliveries.Data[0].Event)
+		assert.Equal(t, 1, deliveries.Data[0].Attempts)
+		assert.Equal(t, http.StatusOK, deliveries.Data[0].ResponseStatus)
+		assert.NotNil(t, deliveries.Data[0].DeliveredAt)
+	}
+	api.HttpGet("/api/webhooks/1/dead-letters", 200, map[string]any{"count": 0, "data": []any{}})
+	api.HttpGet("/api/webhooks/1/deliveries?state=lost", 400, models.Problem{
+		Type:   "about:blank",
+		Title:  "Bad Request",
+		Status: 400,
+		Detail: "Unknown state lost",
+	})
+	api.HttpGet("/api/webhooks/2/deliveries", 404, models.Problem{
+		Type:   "about:blank",
+		Title:  "Not Found",
+		Status: 404,
+		Detail: "record not found",
+	})
+
+	api.HttpDelete("/api/webhooks/1", 200, map[string]bool{"data": true})
+	api.HttpGet("/api/webhooks", 200, ResponseWebhooks{Count: 0, Data: []models.WebhookSubscription{}})
+}
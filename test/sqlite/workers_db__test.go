@@ -0,0 +1,52 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/senomas/go-api/controllers"
+	"github.com/senomas/go-api/models"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestRunWorkers(t *testing.T) {
+	models.RegisterModel(&Publisher{})
+	if db, err := gorm.Open(sqlite.Open("file:workers?mode=memory"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)}); err != nil {
+		t.Fatal("Init GORM Error", err)
+	} else {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.SetMaxOpenConns(1)
+		}
+		models.Setup(db)
+		if err := models.AutoMigrate(db); err != nil {
+			t.Fatal("AutoMigrate Error", err)
+		}
+	}
+	sub := models.DB.Feed.Subscribe()
+	defer sub.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- controllers.RunWorkers(ctx)
+	}()
+
+	repo := models.NewRepository[Publisher](nil)
+	repo.Outbox = true
+	_, err := repo.Create(context.Background(), Publisher{Name: "Casterman", City: "Tournai"})
+	assert.NoError(t, err)
+	select {
+	case event := <-sub.Events():
+		assert.Equal(t, "publishers", event.RecordType)
+		assert.Equal(t, models.AuditCreate, event.Action)
+	case <-time.After(5 * time.Second):
+		t.Error("no event published")
+	}
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}